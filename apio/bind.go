@@ -0,0 +1,206 @@
+package apio
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// valueSource looks up the raw values of a named request parameter.
+// It returns nil if the parameter wasn't provided.
+type valueSource func(name string) []string
+
+// bindParams sets the fields of the struct pointed to by dst which have the
+// given tag, using values from lookup.
+//
+// The tag contains the parameter name and optionally ",required", for example `query:"limit,required"`.
+// An `enum:"a,b,c"` tag restricts the allowed values.
+//
+// Problems with the provided values are returned as FieldErrors. The error is only
+// set if dst isn't a pointer to a struct, which is a programming error rather than a bad request.
+func bindParams(dst interface{}, tag string, lookup valueSource) ([]FieldError, error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("apio: destination must be a non-nil pointer to a struct, got %T", dst)
+	}
+
+	var fieldErrs []FieldError
+	bindStruct(v.Elem(), tag, lookup, &fieldErrs)
+	return fieldErrs, nil
+}
+
+func bindStruct(v reflect.Value, tag string, lookup valueSource, fieldErrs *[]FieldError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+
+		name, opts, ok := parseTag(sf.Tag.Get(tag))
+		if !ok {
+			// look for tagged fields in embedded structs.
+			if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+				bindStruct(fv, tag, lookup, fieldErrs)
+			}
+			continue
+		}
+		if !fv.CanSet() {
+			continue
+		}
+
+		values := lookup(name)
+		if len(values) == 0 {
+			if opts["required"] {
+				*fieldErrs = append(*fieldErrs, FieldError{Field: name, Error: "is required"})
+			}
+			continue
+		}
+
+		if err := setField(fv, values, enumValues(sf.Tag.Get("enum"))); err != nil {
+			*fieldErrs = append(*fieldErrs, FieldError{Field: name, Error: err.Error()})
+		}
+	}
+}
+
+// parseTag splits a tag into the parameter name and its options.
+func parseTag(tag string) (string, map[string]bool, bool) {
+	if tag == "" || tag == "-" {
+		return "", nil, false
+	}
+	parts := strings.Split(tag, ",")
+	opts := map[string]bool{}
+	for _, o := range parts[1:] {
+		opts[strings.TrimSpace(o)] = true
+	}
+	return parts[0], opts, true
+}
+
+func enumValues(tag string) []string {
+	if tag == "" {
+		return nil
+	}
+	return strings.Split(tag, ",")
+}
+
+// setField parses values into the field. Slices accept repeated
+// parameters as well as comma-separated values, with whitespace
+// around each value trimmed.
+func setField(fv reflect.Value, values []string, enum []string) error {
+	t := fv.Type()
+
+	if t.Kind() == reflect.Slice && !isTextUnmarshaler(t) {
+		var items []string
+		for _, v := range values {
+			// headers such as "X-Roles: admin, owner" put a space after each comma.
+			for _, item := range strings.Split(v, ",") {
+				items = append(items, strings.TrimSpace(item))
+			}
+		}
+		slice := reflect.MakeSlice(t, len(items), len(items))
+		for i, item := range items {
+			if err := setValue(slice.Index(i), item, enum); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+
+	if len(values) > 1 {
+		return errors.New("must only be provided once")
+	}
+	return setValue(fv, values[0], enum)
+}
+
+// setValue parses a single string into v, allocating pointers as needed.
+func setValue(v reflect.Value, s string, enum []string) error {
+	if len(enum) > 0 && !contains(enum, s) {
+		return fmt.Errorf("must be one of: %s", strings.Join(enum, ", "))
+	}
+
+	if v.Kind() == reflect.Ptr {
+		ptr := reflect.New(v.Type().Elem())
+		if err := setValue(ptr.Elem(), s, nil); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+
+	switch v.Type() {
+	case timeType:
+		ts, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return errors.New("must be an RFC 3339 timestamp")
+		}
+		v.Set(reflect.ValueOf(ts))
+		return nil
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return errors.New("must be a duration such as 30s or 5m")
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	if tu, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := tu.UnmarshalText([]byte(s)); err != nil {
+			return errors.New("is invalid")
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("must be a boolean")
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be a non-negative integer")
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("has an unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func isTextUnmarshaler(t reflect.Type) bool {
+	return reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
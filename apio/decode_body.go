@@ -1,56 +1,111 @@
 package apio
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
+	"reflect"
+	"sort"
 )
 
+// maxBodyBytes is the largest request body DecodeJSONBody will read.
+const maxBodyBytes = 1048576
+
+// DecodeOptions customise how JSON request bodies are decoded.
+type DecodeOptions struct {
+	// Strict rejects documents which encoding/json would otherwise accept:
+	// duplicate object keys, invalid UTF-8, unpaired UTF-16 surrogates in \u
+	// escapes and objects or arrays nested deeper than MaxDepth. Keys which
+	// match the same struct field are duplicates even if their case differs.
+	Strict bool
+	// MaxDepth is the maximum nesting depth of objects and arrays in strict mode.
+	// If zero, DefaultMaxDepth is used.
+	MaxDepth int
+}
+
 // DecodeJSONBody decodes a JSON body and returns client-friendly errors.
+//
+// Invalid values and unknown fields are returned as an *APIError with a FieldError
+// for each problem. The Field is a JSON Pointer to the value, such as /items/3/price.
 func DecodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) error {
-	if r.Header.Get("Content-Type") != "application/json" {
-		err := errors.New("Content-Type header is not application/json")
-		return NewRequestError(err, http.StatusUnsupportedMediaType)
+	return DecodeJSONBodyWithOptions(w, r, dst, nil)
+}
+
+// DecodeJSONBodyWithOptions decodes a JSON body and returns client-friendly errors.
+// Options may be nil, in which case it behaves the same as DecodeJSONBody.
+func DecodeJSONBodyWithOptions(w http.ResponseWriter, r *http.Request, dst interface{}, options *DecodeOptions) error {
+	data, err := readBody(w, r, "application/json")
+	if err != nil {
+		return err
+	}
+	return decodeJSON(data, dst, options)
+}
+
+// readBody checks the Content-Type of the request and reads the body, up to 1MB.
+func readBody(w http.ResponseWriter, r *http.Request, contentType string) ([]byte, error) {
+	if r.Header.Get("Content-Type") != contentType {
+		err := fmt.Errorf("Content-Type header is not %s", contentType)
+		return nil, NewRequestError(err, http.StatusUnsupportedMediaType)
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
 
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		if err.Error() == "http: request body too large" {
+			err := errors.New("request body must not be larger than 1MB")
+			return nil, NewRequestError(err, http.StatusRequestEntityTooLarge)
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// decodeJSON decodes a single JSON value from data into dst.
+func decodeJSON(data []byte, dst interface{}, options *DecodeOptions) error {
+	return decodeJSONAt(data, dst, options, position{Line: 1, Column: 1})
+}
+
+// decodeJSONAt is like decodeJSON, but positions in error messages are reported
+// relative to start, the position in the request body that data starts at.
+func decodeJSONAt(data []byte, dst interface{}, options *DecodeOptions, start position) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		err := errors.New("request body must not be empty")
+		return NewRequestError(err, http.StatusBadRequest)
+	}
+
+	if options != nil && options.Strict {
+		// syntax errors are ignored here, they're reported when decoding below.
+		errs, err := strictCheck(data, reflect.TypeOf(dst), options.MaxDepth)
+		if err == nil && len(errs) > 0 {
+			return newFieldsError(data, start, "request body contains invalid fields", errs)
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	// type errors are found by checkFields below, which reports every invalid value
+	// rather than just the first. The error from the decoder is used as a fallback.
+	var typeErr *json.UnmarshalTypeError
 
 	err := dec.Decode(&dst)
 	if err != nil {
 		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
 
 		switch {
 		case errors.As(err, &syntaxError):
-			err := fmt.Errorf("request body contains badly-formed JSON (at position %d)", syntaxError.Offset)
+			// the offset is reported after the invalid character has been read.
+			err := fmt.Errorf("request body contains badly-formed JSON (at %s)", positionFrom(data, start, syntaxError.Offset-1))
 			return NewRequestError(err, http.StatusBadRequest)
 
 		case errors.Is(err, io.ErrUnexpectedEOF):
 			err := fmt.Errorf("request body contains badly-formed JSON")
 			return NewRequestError(err, http.StatusBadRequest)
 
-		case errors.As(err, &unmarshalTypeError):
-			err := fmt.Errorf("request body contains an invalid value for the %q field (at position %d)", unmarshalTypeError.Field, unmarshalTypeError.Offset)
-			return NewRequestError(err, http.StatusBadRequest)
-
-		case strings.HasPrefix(err.Error(), "json: unknown field "):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			err := fmt.Errorf("request body contains unknown field %s", fieldName)
-			return NewRequestError(err, http.StatusBadRequest)
-
-		case errors.Is(err, io.EOF):
-			err := errors.New("request body must not be empty")
-			return NewRequestError(err, http.StatusBadRequest)
-
-		case err.Error() == "http: request body too large":
-			err := errors.New("request body must not be larger than 1MB")
-			return NewRequestError(err, http.StatusRequestEntityTooLarge)
+		case errors.As(err, &typeErr):
 
 		default:
 			return err
@@ -63,5 +118,86 @@ func DecodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) err
 		return NewRequestError(err, http.StatusBadRequest)
 	}
 
+	fieldErrs, err := checkFields(data, reflect.TypeOf(dst))
+	if err != nil {
+		return err
+	}
+
+	// the decoder's offset is only reliable if the error didn't come from
+	// a json.Unmarshaler, so it's only used if checkFields found nothing.
+	if typeErr != nil && len(fieldErrs) == 0 {
+		ptr, start := valueAt(data, typeErr.Offset)
+		fieldErrs = append(fieldErrs, decodeError{
+			Path:   ptr,
+			Offset: start,
+			Msg:    fmt.Sprintf("cannot use %s as %s", typeErr.Value, jsonTypeName(typeErr.Type)),
+		})
+	}
+
+	if len(fieldErrs) > 0 {
+		return newFieldsError(data, start, "request body contains invalid fields", fieldErrs)
+	}
+
 	return nil
 }
+
+// newFieldsError builds a HTTP 400 error with a FieldError for each decodeError,
+// ordered by their position in the document.
+func newFieldsError(data []byte, start position, msg string, errs []decodeError) *APIError {
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Offset < errs[j].Offset
+	})
+
+	fields := make([]FieldError, len(errs))
+	for i, e := range errs {
+		fields[i] = FieldError{
+			Field: e.Path,
+			Error: fmt.Sprintf("%s (at %s)", e.Msg, positionFrom(data, start, e.Offset)),
+		}
+	}
+
+	return &APIError{
+		Err:    errors.New(msg),
+		Status: http.StatusBadRequest,
+		Fields: fields,
+	}
+}
+
+// positionFrom returns the position of offset in data, where data starts at start.
+func positionFrom(data []byte, start position, offset int64) position {
+	pos := positionAt(data, offset)
+	if pos.Line == 1 {
+		pos.Column += start.Column - 1
+	}
+	pos.Line += start.Line - 1
+	return pos
+}
+
+// jsonTypeName describes a Go type using JSON terminology, so that
+// we don't leak internal type names in error messages.
+func jsonTypeName(t reflect.Type) string {
+	if t == nil {
+		return "value"
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return "non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	default:
+		return "value"
+	}
+}
@@ -53,3 +53,104 @@ func TestDecodeJSONBody(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeJSONBodyFieldErrors(t *testing.T) {
+	type item struct {
+		Price int `json:"price"`
+	}
+	type embedded struct {
+		Owner string `json:"owner"`
+	}
+	type order struct {
+		embedded
+		Name  string            `json:"name"`
+		Items []item            `json:"items"`
+		Tags  map[string]string `json:"tags"`
+		Raw   json.RawMessage   `json:"raw"`
+		// Amount is a json.Number, which has the kind string but is decoded from numbers.
+		Amount json.Number `json:"amount"`
+	}
+
+	type testcase struct {
+		name     string
+		giveBody string
+		wantErr  error
+	}
+
+	testcases := []testcase{
+		{name: "ok", giveBody: `{"name":"a","OWNER":"b","items":[{"price":1}],"tags":{"any":"x"},"raw":{"anything":true}}`, wantErr: nil},
+		{name: "json.Number", giveBody: `{"amount":12.5}`, wantErr: nil},
+		{
+			name:     "boolean type error",
+			giveBody: `{"name":true}`,
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "/name", Error: "cannot use boolean as string (at line 1, column 9)"}},
+			},
+		},
+		{
+			name:     "nested type error",
+			giveBody: `{"items":[{"price":1},{"price":"x"}]}`,
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "/items/1/price", Error: "cannot use string as integer (at line 1, column 32)"}},
+			},
+		},
+		{
+			name:     "object instead of array",
+			giveBody: "{\n  \"items\": {}\n}",
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "/items", Error: "cannot use object as array (at line 2, column 12)"}},
+			},
+		},
+		{
+			name:     "unknown fields",
+			giveBody: `{"items":[{"price":1,"cost":2}],"a/b":1}`,
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{
+					{Field: "/items/0/cost", Error: "unknown field (at line 1, column 22)"},
+					{Field: "/a~1b", Error: "unknown field (at line 1, column 33)"},
+				},
+			},
+		},
+		{
+			name:     "type error and unknown field",
+			giveBody: `{"other":1,"name":2}`,
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{
+					{Field: "/other", Error: "unknown field (at line 1, column 2)"},
+					{Field: "/name", Error: "cannot use number as string (at line 1, column 19)"},
+				},
+			},
+		},
+		{
+			name:     "syntax error",
+			giveBody: "{\n\"name\" 1}",
+			wantErr:  &APIError{Err: errors.New("request body contains badly-formed JSON (at line 2, column 8)"), Status: http.StatusBadRequest},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var o order
+			w := httptest.NewRecorder()
+
+			r := http.Request{
+				Body:   io.NopCloser(strings.NewReader(tc.giveBody)),
+				Header: make(http.Header),
+			}
+			r.Header.Add("Content-Type", "application/json")
+
+			err := DecodeJSONBody(w, &r, &o)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
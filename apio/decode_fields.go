@@ -0,0 +1,314 @@
+package apio
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	optionalType        = reflect.TypeOf((*optional)(nil)).Elem()
+	numberType          = reflect.TypeOf(json.Number(""))
+)
+
+// decodeError is a problem found with a particular value in a JSON document.
+type decodeError struct {
+	// Path is the JSON Pointer to the value.
+	Path string
+	// Offset is the byte offset where the value starts.
+	Offset int64
+	Msg    string
+}
+
+// checkFields walks data alongside the Go type t and returns an error for every object
+// key which doesn't match a field in the corresponding struct, and every value which
+// can't be decoded into the corresponding Go type.
+//
+// Field names are matched using the same rules as encoding/json: exported fields,
+// `json` tags, promoted fields from embedded structs and case-insensitive matches.
+// Values which implement json.Unmarshaler are not inspected, other than Optional
+// which is inspected using its wrapped type.
+func checkFields(data []byte, t reflect.Type) ([]decodeError, error) {
+	c := fieldChecker{
+		dec:  json.NewDecoder(bytes.NewReader(data)),
+		data: data,
+	}
+	c.dec.UseNumber()
+	err := c.value(t, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.errs, nil
+}
+
+type fieldChecker struct {
+	dec  *json.Decoder
+	data []byte
+	errs []decodeError
+}
+
+// value reads the next value from the decoder. If t is nil the value
+// is read without any checks.
+func (c *fieldChecker) value(t reflect.Type, path []string) error {
+	t = checkedType(t)
+	start := skipSeparators(c.data, c.dec.InputOffset())
+	tok, err := c.dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if t != nil {
+		if got, ok := mismatch(t, tok); !ok {
+			c.errs = append(c.errs, decodeError{
+				Path:   jsonPointer(path),
+				Offset: start,
+				Msg:    fmt.Sprintf("cannot use %s as %s", got, jsonTypeName(t)),
+			})
+			// the rest of the value can't be checked against the type.
+			t = nil
+		}
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		return c.object(t, path)
+	case json.Delim('['):
+		var elem reflect.Type
+		if t != nil {
+			elem = t.Elem()
+		}
+		for i := 0; c.dec.More(); i++ {
+			if err := c.value(elem, append(path, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		_, err = c.dec.Token()
+		return err
+	}
+	return nil
+}
+
+func (c *fieldChecker) object(t reflect.Type, path []string) error {
+	var fields map[string]fieldInfo
+	if t != nil && t.Kind() == reflect.Struct {
+		fields = structFields(t)
+	}
+
+	for c.dec.More() {
+		start := skipSeparators(c.data, c.dec.InputOffset())
+		key, err := c.dec.Token()
+		if err != nil {
+			return err
+		}
+		k, _ := key.(string)
+		keyPath := append(path, k)
+
+		var elem reflect.Type
+		switch {
+		case fields != nil:
+			f, ok := lookupField(fields, k)
+			if !ok {
+				c.errs = append(c.errs, decodeError{
+					Path:   jsonPointer(keyPath),
+					Offset: start,
+					Msg:    "unknown field",
+				})
+			}
+			// values of fields with the ",string" option are encoded
+			// inside a JSON string, so they can't be checked here.
+			if !f.quoted {
+				elem = f.typ
+			}
+		case t != nil && t.Kind() == reflect.Map:
+			elem = t.Elem()
+		}
+
+		if err := c.value(elem, keyPath); err != nil {
+			return err
+		}
+	}
+	_, err := c.dec.Token()
+	return err
+}
+
+// mismatch checks whether a JSON token can be decoded into t, following the rules
+// of encoding/json. If it can't, the JSON value is described for use in an error message.
+func mismatch(t reflect.Type, tok json.Token) (string, bool) {
+	// types which decode themselves accept any value.
+	if t.Implements(jsonUnmarshalerType) || reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+		return "", true
+	}
+
+	switch v := tok.(type) {
+	case nil:
+		// null is accepted for any type and leaves the value unchanged.
+		return "", true
+
+	case json.Delim:
+		if v == '{' {
+			return "object", t.Kind() == reflect.Struct || t.Kind() == reflect.Map
+		}
+		return "array", (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) || t.Kind() == reflect.Array
+
+	case string:
+		// []byte is encoded as a base64 string.
+		return "string", t.Kind() == reflect.String || (t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8)
+
+	case bool:
+		return "boolean", t.Kind() == reflect.Bool
+
+	case json.Number:
+		if t == numberType {
+			return "", true
+		}
+		s := v.String()
+		var err error
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			_, err = strconv.ParseInt(s, 10, t.Bits())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			_, err = strconv.ParseUint(s, 10, t.Bits())
+		case reflect.Float32, reflect.Float64:
+			_, err = strconv.ParseFloat(s, t.Bits())
+		default:
+			return "number", false
+		}
+		if err != nil {
+			return "number " + s, false
+		}
+		return "", true
+	}
+	return "value", false
+}
+
+// checkedType dereferences pointers and unwraps Optional values.
+// It returns nil for types which decode themselves and so can't be checked.
+func checkedType(t reflect.Type) reflect.Type {
+	for t != nil {
+		if t.Kind() == reflect.Struct && t.Implements(optionalType) {
+			t = reflect.Zero(t).Interface().(optional).valueType()
+			continue
+		}
+		if t.Implements(jsonUnmarshalerType) || reflect.PtrTo(t).Implements(jsonUnmarshalerType) ||
+			t.Implements(textUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+			return nil
+		}
+		switch t.Kind() {
+		case reflect.Ptr:
+			t = t.Elem()
+		case reflect.Interface:
+			return nil
+		default:
+			return t
+		}
+	}
+	return nil
+}
+
+func lookupField(fields map[string]fieldInfo, key string) (fieldInfo, bool) {
+	if f, ok := fields[key]; ok {
+		return f, true
+	}
+	for name, f := range fields {
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return fieldInfo{}, false
+}
+
+// fieldInfo describes a struct field which can be decoded from JSON.
+type fieldInfo struct {
+	typ reflect.Type
+	// index is the index sequence for reflect.Value.FieldByIndex.
+	index []int
+	// quoted is set if the field has the ",string" option.
+	quoted bool
+}
+
+var fieldCache sync.Map // map[reflect.Type]map[string]fieldInfo
+
+// structFields returns the JSON field names for a struct type.
+func structFields(t reflect.Type) map[string]fieldInfo {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(map[string]fieldInfo)
+	}
+
+	type candidate struct {
+		fieldInfo
+		depth  int
+		tagged bool
+		count  int
+	}
+	candidates := map[string]*candidate{}
+
+	var collect func(t reflect.Type, index []int, visited map[reflect.Type]bool)
+	collect = func(t reflect.Type, index []int, visited map[reflect.Type]bool) {
+		if visited[t] {
+			return
+		}
+		visited[t] = true
+		defer delete(visited, t)
+
+		depth := len(index)
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			tag := sf.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			opts := strings.Split(tag, ",")
+			name := opts[0]
+			fieldIndex := append(append([]int{}, index...), i)
+
+			if sf.Anonymous && name == "" {
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					collect(ft, fieldIndex, visited)
+					continue
+				}
+			}
+			if sf.PkgPath != "" {
+				// unexported field
+				continue
+			}
+
+			tagged := name != ""
+			if !tagged {
+				name = sf.Name
+			}
+
+			info := fieldInfo{typ: sf.Type, index: fieldIndex, quoted: contains(opts[1:], "string")}
+
+			c, ok := candidates[name]
+			switch {
+			case !ok || depth < c.depth || (depth == c.depth && tagged && !c.tagged):
+				candidates[name] = &candidate{fieldInfo: info, depth: depth, tagged: tagged, count: 1}
+			case depth == c.depth && tagged == c.tagged:
+				c.count++
+			}
+		}
+	}
+	collect(t, nil, map[reflect.Type]bool{})
+
+	fields := make(map[string]fieldInfo, len(candidates))
+	for name, c := range candidates {
+		// ambiguous fields are ignored by encoding/json.
+		if c.count == 1 {
+			fields[name] = c.fieldInfo
+		}
+	}
+
+	fieldCache.Store(t, fields)
+	return fields
+}
@@ -0,0 +1,51 @@
+package apio
+
+import (
+	"errors"
+	"net/http"
+)
+
+// DecodeQuery binds the URL query parameters of a request into the struct pointed to by dst.
+// Fields are matched using the `query` tag:
+//
+//	type ListParams struct {
+//		Limit  int        `query:"limit,required"`
+//		Since  *time.Time `query:"since"`
+//		Status []string   `query:"status" enum:"active,inactive"`
+//	}
+//
+// Supported types are strings, bools, ints, uints, floats, time.Time (RFC 3339),
+// time.Duration, encoding.TextUnmarshaler and slices of these. Slices accept repeated
+// parameters (?status=a&status=b) as well as comma-separated values (?status=a,b).
+// Whitespace around comma-separated values is trimmed, so ?status=a,%20b is the same
+// as ?status=a,b. Values which aren't in a slice are used as provided.
+// Use a pointer field to tell whether an optional parameter was provided.
+//
+// If any parameters are invalid, an *APIError with status 400 is returned
+// with a FieldError for each bad parameter.
+func DecodeQuery(r *http.Request, dst interface{}) error {
+	fieldErrs, err := bindParams(dst, "query", queryValues(r))
+	if err != nil {
+		return err
+	}
+	return paramsError("request contains invalid query parameters", fieldErrs)
+}
+
+func queryValues(r *http.Request) valueSource {
+	query := r.URL.Query()
+	return func(name string) []string {
+		return query[name]
+	}
+}
+
+// paramsError returns a HTTP 400 error if there are any field errors.
+func paramsError(msg string, fieldErrs []FieldError) error {
+	if len(fieldErrs) == 0 {
+		return nil
+	}
+	return &APIError{
+		Err:    errors.New(msg),
+		Status: http.StatusBadRequest,
+		Fields: fieldErrs,
+	}
+}
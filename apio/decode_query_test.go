@@ -0,0 +1,95 @@
+package apio
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testListParams struct {
+	Limit   int           `query:"limit,required"`
+	Active  *bool         `query:"active"`
+	Since   time.Time     `query:"since"`
+	Timeout time.Duration `query:"timeout"`
+	Sort    string        `query:"sort" enum:"asc,desc"`
+	Status  []string      `query:"status" enum:"open,closed"`
+	IDs     []int         `query:"id"`
+	Cursor  *string       `query:"cursor"`
+}
+
+func TestDecodeQuery(t *testing.T) {
+	active := true
+
+	type testcase struct {
+		name    string
+		give    string
+		want    testListParams
+		wantErr error
+	}
+
+	testcases := []testcase{
+		{
+			name: "ok",
+			give: "/?limit=10&active=true&since=2022-01-02T03:04:05Z&timeout=30s&sort=asc&status=open,%20closed&id=1&id=2,3",
+			want: testListParams{
+				Limit:   10,
+				Active:  &active,
+				Since:   time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+				Timeout: 30 * time.Second,
+				Sort:    "asc",
+				Status:  []string{"open", "closed"},
+				IDs:     []int{1, 2, 3},
+			},
+		},
+		{
+			name: "invalid values",
+			give: "/?limit=abc&active=maybe&since=yesterday&timeout=1&sort=up&status=open,pending&id=1&cursor=a&cursor=b",
+			wantErr: &APIError{
+				Err:    errors.New("request contains invalid query parameters"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{
+					{Field: "limit", Error: "must be an integer"},
+					{Field: "active", Error: "must be a boolean"},
+					{Field: "since", Error: "must be an RFC 3339 timestamp"},
+					{Field: "timeout", Error: "must be a duration such as 30s or 5m"},
+					{Field: "sort", Error: "must be one of: asc, desc"},
+					{Field: "status", Error: "must be one of: open, closed"},
+					{Field: "cursor", Error: "must only be provided once"},
+				},
+			},
+		},
+		{
+			name: "missing required",
+			give: "/",
+			wantErr: &APIError{
+				Err:    errors.New("request contains invalid query parameters"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "limit", Error: "is required"}},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.give, nil)
+
+			var got testListParams
+			err := DecodeQuery(r, &got)
+			assert.Equal(t, tc.wantErr, err)
+			if tc.wantErr == nil {
+				assert.Equal(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeQueryInvalidDestination(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	var s string
+	err := DecodeQuery(r, &s)
+	assert.EqualError(t, err, "apio: destination must be a non-nil pointer to a struct, got *string")
+}
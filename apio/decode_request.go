@@ -0,0 +1,134 @@
+package apio
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DecodePath binds chi URL parameters into the struct pointed to by dst.
+// Fields are matched using the `path` tag, for example `path:"id"`.
+// The supported types are the same as DecodeQuery.
+//
+// If any parameters are invalid, an *APIError with status 400 is returned
+// with a FieldError for each bad parameter.
+func DecodePath(r *http.Request, dst interface{}) error {
+	fieldErrs, err := bindParams(dst, "path", pathValues(r))
+	if err != nil {
+		return err
+	}
+	return paramsError("request contains invalid path parameters", fieldErrs)
+}
+
+// DecodeHeaders binds request headers into the struct pointed to by dst.
+// Fields are matched using the `header` tag, for example `header:"X-Tenant"`.
+// The supported types are the same as DecodeQuery.
+//
+// If any headers are invalid, an *APIError with status 400 is returned
+// with a FieldError for each bad header.
+func DecodeHeaders(r *http.Request, dst interface{}) error {
+	fieldErrs, err := bindParams(dst, "header", headerValues(r))
+	if err != nil {
+		return err
+	}
+	return paramsError("request contains invalid headers", fieldErrs)
+}
+
+// DecodeRequest populates a request struct from the path parameters, query parameters,
+// headers and JSON body of a request:
+//
+//	type UpdateUserRequest struct {
+//		ID     string     `path:"id"`
+//		Tenant string     `header:"X-Tenant,required"`
+//		DryRun bool       `query:"dryRun"`
+//		Body   UpdateUser `body:"json"`
+//	}
+//
+// Problems with parameters are reported together in a single *APIError. If they are all valid,
+// the body is decoded into the field with the `body` tag using DecodeJSONBody.
+// The body is not read if the struct has no `body` field.
+//
+// Like the parameter tags, the `body` tag can be used in embedded structs, but not in
+// other nested structs such as the body type itself. An error is returned if more than
+// one field has the `body` tag, or if one is found in a nested struct.
+func DecodeRequest(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	var fieldErrs []FieldError
+
+	sources := []struct {
+		tag    string
+		lookup valueSource
+	}{
+		{"path", pathValues(r)},
+		{"query", queryValues(r)},
+		{"header", headerValues(r)},
+	}
+	for _, s := range sources {
+		errs, err := bindParams(dst, s.tag, s.lookup)
+		if err != nil {
+			return err
+		}
+		fieldErrs = append(fieldErrs, errs...)
+	}
+
+	if err := paramsError("request contains invalid parameters", fieldErrs); err != nil {
+		return err
+	}
+
+	var body []reflect.Value
+	if err := findBody(reflect.ValueOf(dst).Elem(), false, &body); err != nil {
+		return err
+	}
+	switch len(body) {
+	case 0:
+		return nil
+	case 1:
+		return DecodeJSONBody(w, r, body[0].Addr().Interface())
+	default:
+		return fmt.Errorf("apio: %T must only have one field with the body tag", dst)
+	}
+}
+
+// findBody collects the fields with the `body` tag in v and its embedded structs.
+// If nested is set, v is a field which isn't embedded, so it mustn't contain any.
+func findBody(v reflect.Value, nested bool, body *[]reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+
+		if tag, ok := sf.Tag.Lookup("body"); ok && tag != "-" {
+			if nested {
+				return fmt.Errorf("apio: body field %s must not be in a nested struct", sf.Name)
+			}
+			if !fv.CanSet() {
+				return fmt.Errorf("apio: body field %s must be exported", sf.Name)
+			}
+			*body = append(*body, fv)
+			continue
+		}
+
+		if sf.Type.Kind() == reflect.Struct {
+			if err := findBody(fv, nested || !sf.Anonymous, body); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func pathValues(r *http.Request) valueSource {
+	return func(name string) []string {
+		if v := chi.URLParam(r, name); v != "" {
+			return []string{v}
+		}
+		return nil
+	}
+}
+
+func headerValues(r *http.Request) valueSource {
+	return func(name string) []string {
+		return r.Header.Values(name)
+	}
+}
@@ -0,0 +1,136 @@
+package apio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	type updateUser struct {
+		Name string `json:"name"`
+	}
+	type updateUserRequest struct {
+		ID     int        `path:"id"`
+		Tenant string     `header:"X-Tenant,required"`
+		Roles  []string   `header:"X-Roles"`
+		DryRun bool       `query:"dryRun"`
+		Body   updateUser `body:"json"`
+	}
+
+	type testcase struct {
+		name       string
+		giveID     string
+		giveTarget string
+		giveHeader http.Header
+		giveBody   string
+		want       updateUserRequest
+		wantErr    error
+	}
+
+	testcases := []testcase{
+		{
+			name:       "ok",
+			giveID:     "123",
+			giveTarget: "/users/123?dryRun=true",
+			giveHeader: http.Header{"X-Tenant": {"acme"}, "X-Roles": {"admin, owner", "user"}},
+			giveBody:   `{"name":"alice"}`,
+			want: updateUserRequest{
+				ID:     123,
+				Tenant: "acme",
+				Roles:  []string{"admin", "owner", "user"},
+				DryRun: true,
+				Body:   updateUser{Name: "alice"},
+			},
+		},
+		{
+			name:       "invalid params",
+			giveID:     "abc",
+			giveTarget: "/users/abc?dryRun=maybe",
+			giveHeader: http.Header{},
+			giveBody:   `{"name":"alice"}`,
+			wantErr: &APIError{
+				Err:    errors.New("request contains invalid parameters"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{
+					{Field: "id", Error: "must be an integer"},
+					{Field: "dryRun", Error: "must be a boolean"},
+					{Field: "X-Tenant", Error: "is required"},
+				},
+			},
+		},
+		{
+			name:       "invalid body",
+			giveID:     "123",
+			giveTarget: "/users/123",
+			giveHeader: http.Header{"X-Tenant": {"acme"}},
+			giveBody:   `{"name":1}`,
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "/name", Error: "cannot use number as string (at line 1, column 9)"}},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, tc.giveTarget, strings.NewReader(tc.giveBody))
+			r.Header = tc.giveHeader
+			r.Header.Set("Content-Type", "application/json")
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", tc.giveID)
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+
+			var got updateUserRequest
+			err := DecodeRequest(httptest.NewRecorder(), r, &got)
+			assert.Equal(t, tc.wantErr, err)
+			if tc.wantErr == nil {
+				assert.Equal(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeRequestBodyField(t *testing.T) {
+	type updateUser struct {
+		Name string `json:"name"`
+	}
+	type withBody struct {
+		Body updateUser `body:"json"`
+	}
+	type embedded struct {
+		withBody
+		DryRun bool `query:"dryRun"`
+	}
+	type nested struct {
+		Params withBody
+	}
+	type twoBodies struct {
+		withBody
+		Other updateUser `body:"json"`
+	}
+
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/?dryRun=true", strings.NewReader(`{"name":"alice"}`))
+		r.Header.Set("Content-Type", "application/json")
+		return r
+	}
+
+	var e embedded
+	assert.NoError(t, DecodeRequest(httptest.NewRecorder(), newRequest(), &e))
+	assert.Equal(t, embedded{withBody: withBody{Body: updateUser{Name: "alice"}}, DryRun: true}, e)
+
+	var n nested
+	assert.EqualError(t, DecodeRequest(httptest.NewRecorder(), newRequest(), &n), "apio: body field Body must not be in a nested struct")
+
+	var two twoBodies
+	assert.EqualError(t, DecodeRequest(httptest.NewRecorder(), newRequest(), &two), "apio: *apio.twoBodies must only have one field with the body tag")
+}
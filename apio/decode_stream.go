@@ -0,0 +1,453 @@
+package apio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// StreamOptions customise how streamed request bodies are decoded.
+type StreamOptions struct {
+	// MaxRecordSize is the largest record accepted in bytes.
+	// If zero, records may be up to 1MB, the same limit as DecodeJSONBody.
+	MaxRecordSize int64
+	// MaxTotalSize is the largest request body accepted in bytes. If zero, there is no limit.
+	// Like DecodeJSONBody, the body is limited with http.MaxBytesReader, so the server
+	// closes the connection if the client sends more.
+	MaxTotalSize int64
+	// MaxRecords is the most records accepted. If zero, there is no limit.
+	MaxRecords int
+	// MaxRecordErrors is the number of invalid records DecodeStream skips before stopping,
+	// so that the valid records after them are still passed to its callback. If zero,
+	// DecodeStream stops at the first invalid record. Either way, DecodeStream returns an
+	// error listing the invalid records if there were any.
+	MaxRecordErrors int
+	// JSON are the options used to decode each record.
+	JSON *DecodeOptions
+}
+
+// RecordError is returned by StreamDecoder.Next when a single record is invalid.
+// The stream can continue to be read after a RecordError.
+//
+// It wraps an *APIError, so it can be passed to apio.Error directly. The Fields of the
+// APIError are JSON Pointers prefixed with the index of the record, such as /3/price.
+type RecordError struct {
+	// Index is the 0-indexed position of the record in the stream.
+	Index int
+	// Line is the line of the request body the record starts on.
+	Line int
+	Err  *APIError
+}
+
+// Error implements the error interface.
+func (e *RecordError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the wrapped *APIError, for use with errors.Cause.
+func (e *RecordError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the wrapped *APIError, for use with errors.As.
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// StreamDecoder reads records one at a time from an application/x-ndjson request body,
+// or from a JSON array in an application/json request body, so that large bulk
+// requests don't need to be held in memory.
+type StreamDecoder struct {
+	options StreamOptions
+
+	// ndjson is used for application/x-ndjson bodies.
+	ndjson *bufio.Reader
+	line   int
+
+	// array is used for application/json bodies. Records are read from it a byte at a time,
+	// so that no more than MaxRecordSize bytes of a record are held in memory.
+	array   *bufio.Reader
+	pos     position
+	started bool
+
+	count int
+	done  bool
+	err   error
+}
+
+// NewStreamDecoder returns a decoder for the records in a request body.
+// Options may be nil to use the defaults.
+//
+// An *APIError with status 415 is returned if the request isn't application/x-ndjson or application/json.
+func NewStreamDecoder(w http.ResponseWriter, r *http.Request, options *StreamOptions) (*StreamDecoder, error) {
+	var opts StreamOptions
+	if options != nil {
+		opts = *options
+	}
+	if opts.MaxRecordSize == 0 {
+		opts.MaxRecordSize = maxBodyBytes
+	}
+
+	if opts.MaxTotalSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, opts.MaxTotalSize)
+	}
+	body := r.Body
+
+	d := &StreamDecoder{options: opts}
+
+	switch r.Header.Get("Content-Type") {
+	case "application/x-ndjson":
+		d.ndjson = bufio.NewReader(body)
+	case "application/json":
+		d.array = bufio.NewReader(body)
+		d.pos = position{Line: 1, Column: 1}
+	default:
+		err := errors.New("Content-Type header is not application/x-ndjson or application/json")
+		return nil, NewRequestError(err, http.StatusUnsupportedMediaType)
+	}
+
+	return d, nil
+}
+
+// Next decodes the next record into dst. It returns io.EOF when there are no more records.
+//
+// If the record is invalid, a *RecordError is returned and Next can be called again to
+// continue with the following record. Any other error, such as badly-formed JSON between
+// records or exceeding a size limit, is an *APIError which stops the stream.
+func (d *StreamDecoder) Next(dst interface{}) error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.done {
+		return io.EOF
+	}
+
+	data, start, err := d.nextRecord()
+	if err == io.EOF {
+		d.done = true
+		return io.EOF
+	}
+	if err != nil {
+		d.err = d.streamError(err)
+		return d.err
+	}
+
+	if d.options.MaxRecords > 0 && d.count >= d.options.MaxRecords {
+		err := fmt.Errorf("request body must not contain more than %d records", d.options.MaxRecords)
+		d.err = NewRequestError(err, http.StatusRequestEntityTooLarge)
+		return d.err
+	}
+
+	index := d.count
+	d.count++
+
+	line := start.Line
+	if int64(len(data)) > d.options.MaxRecordSize {
+		d.err = NewRequestError(fmt.Errorf("%s must not be larger than %s", describeRecord(index, line), formatSize(d.options.MaxRecordSize)), http.StatusRequestEntityTooLarge)
+		return d.err
+	}
+
+	err = decodeJSONAt(data, dst, d.options.JSON, start)
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		// errors without fields, such as badly-formed JSON, are reported
+		// against the record itself so they aren't lost by DecodeStream.
+		fields := []FieldError{{Field: fmt.Sprintf("/%d", index), Error: apiErr.Err.Error()}}
+		if len(apiErr.Fields) > 0 {
+			fields = make([]FieldError, len(apiErr.Fields))
+			for i, f := range apiErr.Fields {
+				fields[i] = FieldError{Field: fmt.Sprintf("/%d%s", index, f.Field), Error: f.Error}
+			}
+		}
+		return &RecordError{
+			Index: index,
+			Line:  line,
+			Err: &APIError{
+				Err:    fmt.Errorf("%s is invalid: %s", describeRecord(index, line), apiErr.Err),
+				Status: apiErr.Status,
+				Fields: fields,
+			},
+		}
+	}
+	return err
+}
+
+// Count returns the number of records read so far, including invalid records.
+func (d *StreamDecoder) Count() int {
+	return d.count
+}
+
+// nextRecord returns the raw JSON of the next record and the position it starts at.
+func (d *StreamDecoder) nextRecord() ([]byte, position, error) {
+	if d.ndjson != nil {
+		for {
+			data, err := d.readLine()
+			if err != nil {
+				return nil, position{}, err
+			}
+			d.line++
+			// blank lines, such as a trailing newline, are ignored.
+			if len(bytes.TrimSpace(data)) > 0 {
+				return data, position{Line: d.line, Column: 1}, nil
+			}
+		}
+	}
+
+	c, pos, err := d.nextByte()
+	if !d.started {
+		d.started = true
+		if err != nil && err != io.EOF {
+			return nil, position{}, err
+		}
+		if err == io.EOF || c != '[' {
+			return nil, position{}, NewRequestError(errors.New("request body must be a JSON array"), http.StatusBadRequest)
+		}
+		c, pos, err = d.nextByte()
+		if err == nil && c == ']' {
+			return nil, position{}, d.end()
+		}
+	} else if err == nil {
+		// records after the first must follow a comma.
+		switch c {
+		case ']':
+			return nil, position{}, d.end()
+		case ',':
+			c, pos, err = d.nextByte()
+		default:
+			return nil, position{}, d.syntaxError(pos)
+		}
+	}
+	if err == io.EOF {
+		return nil, position{}, d.syntaxError(d.pos)
+	}
+	if err != nil {
+		return nil, position{}, err
+	}
+
+	switch c {
+	case ',', ':', ']', '}':
+		return nil, position{}, d.syntaxError(pos)
+	}
+	data, err := d.readValue(c, pos)
+	return data, pos, err
+}
+
+// nextByte returns the next byte of an application/json body which isn't whitespace, and its position.
+func (d *StreamDecoder) nextByte() (byte, position, error) {
+	for {
+		pos := d.pos
+		c, err := d.readByte()
+		if err != nil {
+			return 0, pos, err
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			continue
+		}
+		return c, pos, nil
+	}
+}
+
+// readByte reads a byte of an application/json body, keeping track of the position.
+func (d *StreamDecoder) readByte() (byte, error) {
+	c, err := d.array.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if c == '\n' {
+		d.pos.Line++
+		d.pos.Column = 1
+	} else {
+		d.pos.Column++
+	}
+	return c, nil
+}
+
+// readValue reads the rest of the record in a JSON array which starts with c, without reading
+// more than MaxRecordSize bytes into memory. It only finds where the record ends; the record
+// is checked when it's decoded.
+func (d *StreamDecoder) readValue(c byte, start position) ([]byte, error) {
+	data := []byte{c}
+	depth := 0
+	inString := false
+	switch c {
+	case '{', '[':
+		depth++
+	case '"':
+		inString = true
+	}
+	scalar := depth == 0 && !inString
+
+	for {
+		if int64(len(data)) > d.options.MaxRecordSize {
+			err := fmt.Errorf("%s must not be larger than %s", describeRecord(d.count, start.Line), formatSize(d.options.MaxRecordSize))
+			return nil, NewRequestError(err, http.StatusRequestEntityTooLarge)
+		}
+		if depth == 0 && !inString {
+			if !scalar {
+				return data, nil
+			}
+			// numbers, booleans and null end at the next separator.
+			next, err := d.array.Peek(1)
+			if err == io.EOF || (err == nil && bytes.IndexByte([]byte(",] \t\r\n"), next[0]) >= 0) {
+				return data, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		c, err := d.readByte()
+		if err == io.EOF {
+			return nil, d.syntaxError(d.pos)
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, c)
+
+		switch {
+		case inString && c == '\\':
+			// the escaped character can't end the string.
+			c, err := d.readByte()
+			if err == io.EOF {
+				return nil, d.syntaxError(d.pos)
+			}
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, c)
+		case inString:
+			inString = c != '"'
+		case c == '"':
+			inString = true
+		case c == '{', c == '[':
+			depth++
+		case c == '}', c == ']':
+			depth--
+		}
+	}
+}
+
+// end checks that nothing follows the closing ']' of an application/json body.
+func (d *StreamDecoder) end() error {
+	_, pos, err := d.nextByte()
+	switch {
+	case err == io.EOF:
+		return io.EOF
+	case err != nil:
+		return err
+	}
+	return NewRequestError(fmt.Errorf("request body must only contain a single JSON array (at %s)", pos), http.StatusBadRequest)
+}
+
+// syntaxError reports badly-formed JSON between the records of an application/json body.
+func (d *StreamDecoder) syntaxError(pos position) error {
+	err := fmt.Errorf("request body contains badly-formed JSON after %d records (at %s)", d.count, pos)
+	return NewRequestError(err, http.StatusBadRequest)
+}
+
+// readLine reads a line of an application/x-ndjson body, without reading
+// more than MaxRecordSize bytes into memory.
+func (d *StreamDecoder) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := d.ndjson.ReadSlice('\n')
+		line = append(line, chunk...)
+		if int64(len(line)) > d.options.MaxRecordSize+2 {
+			err := fmt.Errorf("%s must not be larger than %s", describeRecord(d.count, d.line+1), formatSize(d.options.MaxRecordSize))
+			return nil, NewRequestError(err, http.StatusRequestEntityTooLarge)
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && len(line) > 0:
+			// the last line doesn't need to end with a newline.
+		case err != nil:
+			return nil, err
+		}
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+}
+
+// streamError converts errors which stop the stream into client-friendly errors.
+func (d *StreamDecoder) streamError(err error) error {
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case err.Error() == "http: request body too large":
+		err := fmt.Errorf("request body must not be larger than %s", formatSize(d.options.MaxTotalSize))
+		return NewRequestError(err, http.StatusRequestEntityTooLarge)
+	default:
+		return err
+	}
+}
+
+func describeRecord(index, line int) string {
+	if line > 0 {
+		return fmt.Sprintf("record %d on line %d", index, line)
+	}
+	return fmt.Sprintf("record %d", index)
+}
+
+// DecodeStream reads the records in an application/x-ndjson or application/json array
+// request body, calling fn with each valid record in turn. Options may be nil to use the defaults.
+//
+// If fn returns an error, DecodeStream stops and returns it. Invalid records are skipped until
+// more than StreamOptions.MaxRecordErrors are found. Skipping them only lets the valid records
+// which follow be processed: once the stream ends or too many are found, an error with status 400
+// is returned for the invalid records. It's the *RecordError if there was only one, and otherwise
+// an *APIError containing the FieldErrors for all of them.
+func DecodeStream[T any](w http.ResponseWriter, r *http.Request, options *StreamOptions, fn func(record T) error) error {
+	d, err := NewStreamDecoder(w, r, options)
+	if err != nil {
+		return err
+	}
+
+	var recordErrs []*RecordError
+	for {
+		var record T
+		err := d.Next(&record)
+		if err == io.EOF {
+			break
+		}
+
+		var recErr *RecordError
+		if errors.As(err, &recErr) {
+			recordErrs = append(recordErrs, recErr)
+			if len(recordErrs) > d.options.MaxRecordErrors {
+				break
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	switch len(recordErrs) {
+	case 0:
+		return nil
+	case 1:
+		return recordErrs[0]
+	}
+
+	var fields []FieldError
+	for _, e := range recordErrs {
+		fields = append(fields, e.Err.Fields...)
+	}
+	return &APIError{
+		Err:    fmt.Errorf("request body contains %d invalid records", len(recordErrs)),
+		Status: http.StatusBadRequest,
+		Fields: fields,
+	}
+}
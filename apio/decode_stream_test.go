@@ -0,0 +1,227 @@
+package apio
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testRecord struct {
+	ID    string  `json:"id"`
+	Price float64 `json:"price"`
+}
+
+func TestDecodeStream(t *testing.T) {
+	type testcase struct {
+		name            string
+		giveBody        string
+		giveContentType string
+		giveOptions     *StreamOptions
+		want            []testRecord
+		wantErr         error
+	}
+
+	testcases := []testcase{
+		{
+			name:            "ndjson",
+			giveBody:        "{\"id\":\"a\",\"price\":1}\r\n\n{\"id\":\"b\",\"price\":2}",
+			giveContentType: "application/x-ndjson",
+			want:            []testRecord{{ID: "a", Price: 1}, {ID: "b", Price: 2}},
+		},
+		{
+			name:            "array",
+			giveBody:        `[{"id":"a","price":1}, {"id":"b","price":2}]`,
+			giveContentType: "application/json",
+			want:            []testRecord{{ID: "a", Price: 1}, {ID: "b", Price: 2}},
+		},
+		{
+			name:            "empty array",
+			giveBody:        `[]`,
+			giveContentType: "application/json",
+		},
+		{
+			name:            "ndjson invalid record",
+			giveBody:        "{\"id\":\"a\",\"price\":1}\n{\"id\":\"b\",\"price\":\"x\"}\n{\"id\":\"c\",\"price\":3}\n",
+			giveContentType: "application/x-ndjson",
+			want:            []testRecord{{ID: "a", Price: 1}},
+			wantErr: &RecordError{
+				Index: 1,
+				Line:  2,
+				Err: &APIError{
+					Err:    errors.New("record 1 on line 2 is invalid: request body contains invalid fields"),
+					Status: http.StatusBadRequest,
+					Fields: []FieldError{{Field: "/1/price", Error: "cannot use string as number (at line 2, column 19)"}},
+				},
+			},
+		},
+		{
+			name:            "tolerated record errors",
+			giveBody:        "{\"id\":\"a\",\"price\":\"x\"}\n{\"id\":\"b\",\"price\":2}\n{bad\n{\"id\":\"d\",\"price\":4}\n",
+			giveContentType: "application/x-ndjson",
+			giveOptions:     &StreamOptions{MaxRecordErrors: 5},
+			want:            []testRecord{{ID: "b", Price: 2}, {ID: "d", Price: 4}},
+			wantErr: &APIError{
+				Err:    errors.New("request body contains 2 invalid records"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{
+					{Field: "/0/price", Error: "cannot use string as number (at line 1, column 19)"},
+					{Field: "/2", Error: "request body contains badly-formed JSON (at line 3, column 2)"},
+				},
+			},
+		},
+		{
+			name:            "record too large",
+			giveBody:        "{\"id\":\"a\"}\n{\"id\":\"" + strings.Repeat("b", 100) + "\"}\n",
+			giveContentType: "application/x-ndjson",
+			giveOptions:     &StreamOptions{MaxRecordSize: 64},
+			want:            []testRecord{{ID: "a"}},
+			wantErr:         &APIError{Err: errors.New("record 1 on line 2 must not be larger than 64 bytes"), Status: http.StatusRequestEntityTooLarge},
+		},
+		{
+			name:            "array invalid record",
+			giveBody:        "[\n  {\"id\":\"a\",\"price\":1},\n  {\"id\":\"b]\\\"\",\"price\":\"x\"}\n]",
+			giveContentType: "application/json",
+			want:            []testRecord{{ID: "a", Price: 1}},
+			wantErr: &RecordError{
+				Index: 1,
+				Line:  3,
+				Err: &APIError{
+					Err:    errors.New("record 1 on line 3 is invalid: request body contains invalid fields"),
+					Status: http.StatusBadRequest,
+					Fields: []FieldError{{Field: "/1/price", Error: "cannot use string as number (at line 3, column 24)"}},
+				},
+			},
+		},
+		{
+			name:            "array record too large",
+			giveBody:        `[{"id":"a"},` + "\n" + `{"id":"` + strings.Repeat("b", 100) + `"}]`,
+			giveContentType: "application/json",
+			giveOptions:     &StreamOptions{MaxRecordSize: 64},
+			want:            []testRecord{{ID: "a"}},
+			wantErr:         &APIError{Err: errors.New("record 1 on line 2 must not be larger than 64 bytes"), Status: http.StatusRequestEntityTooLarge},
+		},
+		{
+			name:            "array trailing comma",
+			giveBody:        `[{"id":"a"},]`,
+			giveContentType: "application/json",
+			want:            []testRecord{{ID: "a"}},
+			wantErr:         &APIError{Err: errors.New("request body contains badly-formed JSON after 1 records (at line 1, column 13)"), Status: http.StatusBadRequest},
+		},
+		{
+			name:            "array missing comma",
+			giveBody:        "[{\"id\":\"a\"}\n {\"id\":\"b\"}]",
+			giveContentType: "application/json",
+			want:            []testRecord{{ID: "a"}},
+			wantErr:         &APIError{Err: errors.New("request body contains badly-formed JSON after 1 records (at line 2, column 2)"), Status: http.StatusBadRequest},
+		},
+		{
+			name:            "array of scalars",
+			giveBody:        `[1, "b"]`,
+			giveContentType: "application/json",
+			wantErr: &RecordError{
+				Index: 0,
+				Line:  1,
+				Err: &APIError{
+					Err:    errors.New("record 0 on line 1 is invalid: request body contains invalid fields"),
+					Status: http.StatusBadRequest,
+					Fields: []FieldError{{Field: "/0", Error: "cannot use number as object (at line 1, column 2)"}},
+				},
+			},
+		},
+		{
+			name:            "too many records",
+			giveBody:        `[{"id":"a"},{"id":"b"},{"id":"c"}]`,
+			giveContentType: "application/json",
+			giveOptions:     &StreamOptions{MaxRecords: 2},
+			want:            []testRecord{{ID: "a"}, {ID: "b"}},
+			wantErr:         &APIError{Err: errors.New("request body must not contain more than 2 records"), Status: http.StatusRequestEntityTooLarge},
+		},
+		{
+			name:            "total too large",
+			giveBody:        `[{"id":"a"},{"id":"b"},{"id":"c"}]`,
+			giveContentType: "application/json",
+			giveOptions:     &StreamOptions{MaxTotalSize: 20},
+			want:            []testRecord{{ID: "a"}},
+			wantErr:         &APIError{Err: errors.New("request body must not be larger than 20 bytes"), Status: http.StatusRequestEntityTooLarge},
+		},
+		{
+			name:            "not an array",
+			giveBody:        `{"id":"a"}`,
+			giveContentType: "application/json",
+			wantErr:         &APIError{Err: errors.New("request body must be a JSON array"), Status: http.StatusBadRequest},
+		},
+		{
+			name:            "unsupported content type",
+			giveBody:        `[]`,
+			giveContentType: "text/csv",
+			wantErr:         &APIError{Err: errors.New("Content-Type header is not application/x-ndjson or application/json"), Status: http.StatusUnsupportedMediaType},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.giveBody))
+			r.Header.Set("Content-Type", tc.giveContentType)
+
+			var got []testRecord
+			err := DecodeStream(httptest.NewRecorder(), r, tc.giveOptions, func(rec testRecord) error {
+				got = append(got, rec)
+				return nil
+			})
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestStreamDecoderLimitsArrayRecords(t *testing.T) {
+	// the record never ends, so it must be rejected without being read into memory.
+	body := io.MultiReader(strings.NewReader(`[{"id":"`), infiniteReader{})
+	r := httptest.NewRequest(http.MethodPost, "/", body)
+	r.Header.Set("Content-Type", "application/json")
+
+	d, err := NewStreamDecoder(httptest.NewRecorder(), r, &StreamOptions{MaxRecordSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rec testRecord
+	err = d.Next(&rec)
+	assert.Equal(t, &APIError{Err: errors.New("record 0 on line 1 must not be larger than 1KB"), Status: http.StatusRequestEntityTooLarge}, err)
+}
+
+type infiniteReader struct{}
+
+func (infiniteReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 'a'
+	}
+	return len(b), nil
+}
+
+func TestStreamDecoderContinuesAfterRecordError(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`[{"id":1},{"id":"b"}]`))
+	r.Header.Set("Content-Type", "application/json")
+
+	d, err := NewStreamDecoder(httptest.NewRecorder(), r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rec testRecord
+	err = d.Next(&rec)
+	var recErr *RecordError
+	assert.True(t, errors.As(err, &recErr))
+	assert.Equal(t, 0, recErr.Index)
+
+	rec = testRecord{}
+	assert.NoError(t, d.Next(&rec))
+	assert.Equal(t, testRecord{ID: "b"}, rec)
+	assert.Equal(t, io.EOF, d.Next(&rec))
+	assert.Equal(t, 2, d.Count())
+}
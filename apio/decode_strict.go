@@ -0,0 +1,200 @@
+package apio
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// DefaultMaxDepth is the maximum nesting depth of objects and arrays
+// allowed in strict mode if DecodeOptions.MaxDepth isn't set.
+const DefaultMaxDepth = 32
+
+// strictCheck looks for problems in data which encoding/json silently accepts.
+// It returns an error if data isn't valid JSON.
+//
+// Keys of objects decoded into a struct of type t are compared the way encoding/json
+// matches them to fields, so keys differing only in case are duplicates.
+func strictCheck(data []byte, t reflect.Type, maxDepth int) ([]decodeError, error) {
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+
+	// encoding/json replaces invalid UTF-8 with the replacement character,
+	// so we need to check the raw bytes rather than the decoded tokens.
+	if off := invalidUTF8(data); off >= 0 {
+		ptr, _ := valueAt(data, off+1)
+		return []decodeError{{Path: ptr, Offset: off, Msg: "invalid UTF-8"}}, nil
+	}
+	// unpaired surrogates in \u escapes are replaced in the same way.
+	if off := invalidSurrogate(data); off >= 0 {
+		ptr, _ := valueAt(data, off+1)
+		return []decodeError{{Path: ptr, Offset: off, Msg: "invalid UTF-16 surrogate in escape sequence"}}, nil
+	}
+
+	c := strictChecker{
+		dec:      json.NewDecoder(bytes.NewReader(data)),
+		data:     data,
+		maxDepth: maxDepth,
+	}
+	c.dec.UseNumber()
+	err := c.value(t, nil, 0)
+	if err == errStopWalk {
+		err = nil
+	}
+	return c.errs, err
+}
+
+// invalidUTF8 returns the offset of the first invalid UTF-8 sequence in data,
+// or -1 if data is valid.
+func invalidUTF8(data []byte) int64 {
+	for i := 0; i < len(data); {
+		if data[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size == 1 {
+			return int64(i)
+		}
+		i += size
+	}
+	return -1
+}
+
+// invalidSurrogate returns the offset of the first \u escape in a string in data which
+// is half of a UTF-16 surrogate pair without the other half, or -1 if there isn't one.
+func invalidSurrogate(data []byte) int64 {
+	inString := false
+	for i := 0; i < len(data); i++ {
+		switch {
+		case data[i] == '"':
+			inString = !inString
+		case !inString || data[i] != '\\':
+		case i+1 < len(data) && data[i+1] == 'u':
+			r, ok := unicodeEscape(data, i)
+			if !ok || !utf16.IsSurrogate(r) {
+				i++
+				continue
+			}
+			r2, ok := unicodeEscape(data, i+6)
+			if !ok || utf16.DecodeRune(r, r2) == utf8.RuneError {
+				return int64(i)
+			}
+			// skip both halves of the pair.
+			i += 11
+		default:
+			// skip the escaped character, which may be a quote.
+			i++
+		}
+	}
+	return -1
+}
+
+// unicodeEscape decodes the \uXXXX escape at data[i:].
+func unicodeEscape(data []byte, i int) (rune, bool) {
+	if i+6 > len(data) || data[i] != '\\' || data[i+1] != 'u' {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(string(data[i+2:i+6]), 16, 16)
+	if err != nil {
+		return 0, false
+	}
+	return rune(n), true
+}
+
+type strictChecker struct {
+	dec      *json.Decoder
+	data     []byte
+	maxDepth int
+	errs     []decodeError
+}
+
+// value reads the next value from the decoder. t is the Go type it's decoded
+// into, which is used to find duplicate keys, or nil if it isn't known.
+func (c *strictChecker) value(t reflect.Type, path []string, depth int) error {
+	t = checkedType(t)
+	start := skipSeparators(c.data, c.dec.InputOffset())
+	tok, err := c.dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'), json.Delim('['):
+		if depth >= c.maxDepth {
+			c.errs = append(c.errs, decodeError{
+				Path:   jsonPointer(path),
+				Offset: start,
+				Msg:    fmt.Sprintf("exceeds the maximum nesting depth of %d", c.maxDepth),
+			})
+			// there's no point checking the rest of the document.
+			return errStopWalk
+		}
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		var fields map[string]fieldInfo
+		if t != nil && t.Kind() == reflect.Struct {
+			fields = structFields(t)
+		}
+
+		keys := map[string]bool{}
+		for c.dec.More() {
+			keyStart := skipSeparators(c.data, c.dec.InputOffset())
+			key, err := c.dec.Token()
+			if err != nil {
+				return err
+			}
+			k, _ := key.(string)
+			keyPath := append(path, k)
+
+			// keys matching the same struct field are duplicates, even if their case differs.
+			name := k
+			var elem reflect.Type
+			switch {
+			case fields != nil:
+				if f, ok := lookupField(fields, k); ok {
+					name = fmt.Sprint(f.index)
+					elem = f.typ
+				}
+			case t != nil && t.Kind() == reflect.Map:
+				elem = t.Elem()
+			}
+
+			if keys[name] {
+				c.errs = append(c.errs, decodeError{
+					Path:   jsonPointer(keyPath),
+					Offset: keyStart,
+					Msg:    "duplicate key",
+				})
+			}
+			keys[name] = true
+			if err := c.value(elem, keyPath, depth+1); err != nil {
+				return err
+			}
+		}
+		_, err = c.dec.Token()
+		return err
+
+	case json.Delim('['):
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		for i := 0; c.dec.More(); i++ {
+			if err := c.value(elem, append(path, strconv.Itoa(i)), depth+1); err != nil {
+				return err
+			}
+		}
+		_, err = c.dec.Token()
+		return err
+	}
+
+	return nil
+}
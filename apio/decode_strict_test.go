@@ -0,0 +1,163 @@
+package apio
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeJSONBodyStrict(t *testing.T) {
+	type testcase struct {
+		name        string
+		giveBody    string
+		giveOptions *DecodeOptions
+		wantErr     error
+	}
+
+	testcases := []testcase{
+		{name: "ok", giveBody: `{"a":{"b":[1,2]}}`, giveOptions: &DecodeOptions{Strict: true}, wantErr: nil},
+		{name: "duplicate keys allowed when not strict", giveBody: `{"a":1,"a":2}`, giveOptions: nil, wantErr: nil},
+		{
+			name:        "duplicate keys",
+			giveBody:    `{"a":{"b":1,"b":2}}`,
+			giveOptions: &DecodeOptions{Strict: true},
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "/a/b", Error: "duplicate key (at line 1, column 13)"}},
+			},
+		},
+		{
+			name:        "escaped duplicate keys",
+			giveBody:    `{"a":1,"\u0061":2}`,
+			giveOptions: &DecodeOptions{Strict: true},
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "/a", Error: "duplicate key (at line 1, column 8)"}},
+			},
+		},
+		{
+			name:        "invalid utf8",
+			giveBody:    "{\"a\":[\"ok\",\"bad\xff\"]}",
+			giveOptions: &DecodeOptions{Strict: true},
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "/a/1", Error: "invalid UTF-8 (at line 1, column 16)"}},
+			},
+		},
+		{name: "surrogate pair", giveBody: `{"a":"\ud83d\ude00"}`, giveOptions: &DecodeOptions{Strict: true}, wantErr: nil},
+		{name: "escaped backslash before u", giveBody: `{"a":"\\ud800"}`, giveOptions: &DecodeOptions{Strict: true}, wantErr: nil},
+		{name: "case variants are distinct map keys", giveBody: `{"a":1,"A":2}`, giveOptions: &DecodeOptions{Strict: true}, wantErr: nil},
+		{
+			name:        "lone high surrogate",
+			giveBody:    `{"a":["ok","x\ud800y"]}`,
+			giveOptions: &DecodeOptions{Strict: true},
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "/a/1", Error: "invalid UTF-16 surrogate in escape sequence (at line 1, column 14)"}},
+			},
+		},
+		{
+			name:        "lone low surrogate",
+			giveBody:    `{"a":"\udc00"}`,
+			giveOptions: &DecodeOptions{Strict: true},
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "/a", Error: "invalid UTF-16 surrogate in escape sequence (at line 1, column 7)"}},
+			},
+		},
+		{
+			name:        "too deep",
+			giveBody:    `{"a":[[{"b":1}]]}`,
+			giveOptions: &DecodeOptions{Strict: true, MaxDepth: 3},
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "/a/0/0", Error: "exceeds the maximum nesting depth of 3 (at line 1, column 8)"}},
+			},
+		},
+		{
+			name:        "syntax errors are reported normally",
+			giveBody:    `{"a" 1}`,
+			giveOptions: &DecodeOptions{Strict: true},
+			wantErr:     &APIError{Err: errors.New("request body contains badly-formed JSON (at line 1, column 6)"), Status: http.StatusBadRequest},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var i map[string]interface{}
+			w := httptest.NewRecorder()
+
+			r := http.Request{
+				Body:   io.NopCloser(strings.NewReader(tc.giveBody)),
+				Header: make(http.Header),
+			}
+			r.Header.Add("Content-Type", "application/json")
+
+			err := DecodeJSONBodyWithOptions(w, &r, &i, tc.giveOptions)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestDecodeJSONBodyStrictCaseInsensitiveDuplicates(t *testing.T) {
+	type item struct {
+		Name string `json:"name"`
+	}
+	type body struct {
+		Name  string            `json:"name"`
+		Items []item            `json:"items"`
+		Tags  map[string]string `json:"tags"`
+	}
+
+	type testcase struct {
+		name     string
+		giveBody string
+		wantErr  error
+	}
+
+	testcases := []testcase{
+		{name: "map keys differing in case", giveBody: `{"tags":{"a":"1","A":"2"}}`, wantErr: nil},
+		{
+			name:     "field",
+			giveBody: `{"name":"a","NAME":"b"}`,
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "/NAME", Error: "duplicate key (at line 1, column 13)"}},
+			},
+		},
+		{
+			name:     "nested field",
+			giveBody: `{"items":[{"Name":"a","name":"b"}]}`,
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "/items/0/name", Error: "duplicate key (at line 1, column 23)"}},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var b body
+			r := http.Request{
+				Body:   io.NopCloser(strings.NewReader(tc.giveBody)),
+				Header: http.Header{"Content-Type": {"application/json"}},
+			}
+
+			err := DecodeJSONBodyWithOptions(httptest.NewRecorder(), &r, &b, &DecodeOptions{Strict: true})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
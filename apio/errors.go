@@ -1,5 +1,7 @@
 package apio
 
+import "net/http"
+
 // FieldError is used to indicate an error with a specific request field.
 type FieldError struct {
 	Field string `json:"field"`
@@ -10,6 +12,8 @@ type FieldError struct {
 type ErrorResponse struct {
 	Error  string       `json:"error"`
 	Fields []FieldError `json:"fields,omitempty"`
+	// RequestID is the ID of the request from requestid.Get, if there is one.
+	RequestID string `json:"requestId,omitempty"`
 }
 
 // APIError is used to pass an error during the request through the
@@ -31,3 +35,37 @@ func NewRequestError(err error, status int) error {
 func (e *APIError) Error() string {
 	return e.Err.Error()
 }
+
+// errorKinds are the names given to error status codes by ErrorKind.
+var errorKinds = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorised",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusPreconditionFailed:    "precondition_failed",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnprocessableEntity:   "unprocessable",
+	http.StatusPreconditionRequired:  "precondition_required",
+	http.StatusTooManyRequests:       "too_many_requests",
+	http.StatusInternalServerError:   "internal",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusGatewayTimeout:        "timeout",
+}
+
+// ErrorKind returns a short name for the kind of error, such as "not_found" or "internal",
+// based on the status code Error sends for it. It's used to label errors in metrics and logs.
+func ErrorKind(err error) string {
+	_, status := errorResponse(err)
+	return statusKind(status)
+}
+
+func statusKind(status int) string {
+	if kind, ok := errorKinds[status]; ok {
+		return kind
+	}
+	if status >= http.StatusInternalServerError {
+		return "server_error"
+	}
+	return "client_error"
+}
@@ -0,0 +1,257 @@
+package apio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/common-fate/apikit/logger"
+	"go.uber.org/zap"
+)
+
+// ETag is an entity tag identifying a version of a resource.
+type ETag struct {
+	// Tag is the opaque value of the entity tag, without quotes. It must only
+	// contain printable ASCII characters other than double quotes.
+	Tag string
+	// Weak marks the entity tag as weak, meaning the resource is semantically
+	// equivalent but not necessarily byte-for-byte identical between versions with the same tag.
+	Weak bool
+}
+
+// StrongETag returns a strong ETag for a caller-supplied version, such as a revision number.
+func StrongETag(version string) ETag {
+	return ETag{Tag: version}
+}
+
+// WeakETag returns a weak ETag for a caller-supplied version, such as an update timestamp.
+func WeakETag(version string) ETag {
+	return ETag{Tag: version, Weak: true}
+}
+
+// BodyETag returns a strong ETag computed from a hash of an encoded response body.
+func BodyETag(body []byte) ETag {
+	sum := sha256.Sum256(body)
+	return ETag{Tag: base64.RawURLEncoding.EncodeToString(sum[:16])}
+}
+
+// IsZero reports whether the ETag is unset.
+func (e ETag) IsZero() bool {
+	return e.Tag == ""
+}
+
+// String returns the ETag in the format used in the ETag header, such as "abc" or W/"abc".
+func (e ETag) String() string {
+	if e.Weak {
+		return `W/"` + e.Tag + `"`
+	}
+	return `"` + e.Tag + `"`
+}
+
+// parseETags parses the list of entity tags in an If-Match or If-None-Match header.
+// The wildcard "*" is returned as an ETag with the tag "*". Malformed entries are skipped.
+func parseETags(header string) []ETag {
+	var tags []ETag
+	s := header
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return tags
+		}
+		if s[0] == '*' {
+			tags = append(tags, ETag{Tag: "*"})
+			s = s[1:]
+			continue
+		}
+
+		weak := strings.HasPrefix(s, "W/")
+		if weak {
+			s = s[2:]
+		}
+		if !strings.HasPrefix(s, `"`) {
+			// skip to the next entry.
+			i := strings.IndexByte(s, ',')
+			if i < 0 {
+				return tags
+			}
+			s = s[i:]
+			continue
+		}
+		end := strings.IndexByte(s[1:], '"')
+		if end < 0 {
+			return tags
+		}
+		tags = append(tags, ETag{Tag: s[1 : end+1], Weak: weak})
+		s = s[end+2:]
+	}
+}
+
+// matchesAny reports whether the current ETag matches any of the tags in a conditional
+// header. Strong comparison requires both tags to be strong and identical, while weak
+// comparison only requires the tags to be identical.
+func matchesAny(header string, current ETag, strong bool) bool {
+	for _, t := range parseETags(header) {
+		if t.Tag == "*" {
+			if !current.IsZero() {
+				return true
+			}
+			continue
+		}
+		if t.Tag != current.Tag {
+			continue
+		}
+		if !strong || (!t.Weak && !current.Weak) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validators describe the current version of a resource, for use in conditional requests.
+type Validators struct {
+	// ETag is the current entity tag of the resource. If it's zero,
+	// the resource is treated as not existing for If-Match: *.
+	ETag ETag
+	// LastModified is when the resource was last changed, if known.
+	LastModified time.Time
+}
+
+func (v Validators) setHeaders(w http.ResponseWriter) {
+	if !v.ETag.IsZero() {
+		w.Header().Set("ETag", v.ETag.String())
+	}
+	if !v.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", v.LastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// modifiedSince reports whether the resource was modified after the time in a date header.
+// It returns false if the header is invalid or the last modified time isn't known.
+func (v Validators) modifiedSince(header string) (modified, ok bool) {
+	if v.LastModified.IsZero() {
+		return false, false
+	}
+	t, err := http.ParseTime(header)
+	if err != nil {
+		return false, false
+	}
+	// HTTP dates only have second precision.
+	return v.LastModified.Truncate(time.Second).After(t), true
+}
+
+// JSONConditional is like JSON, but sends ETag and Last-Modified headers with the response,
+// and responds with 304 Not Modified to GET and HEAD requests whose If-None-Match or
+// If-Modified-Since headers show the client already has the current version.
+//
+// If current.ETag is zero, a strong ETag is computed from the encoded response body.
+// No ETag is computed if the body can't be encoded, or for 204 No Content responses, which
+// are sent without a body like JSON does.
+func JSONConditional(ctx context.Context, w http.ResponseWriter, r *http.Request, data interface{}, statusCode int, current Validators) {
+	// If there is nothing to marshal then set the headers and status code and return.
+	if statusCode == http.StatusNoContent {
+		current.setHeaders(w)
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	log := logger.Get(ctx)
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		log.Errorw("marshalling JSON", zap.Error(err))
+	}
+	jsonData = omitAbsent(data, jsonData)
+
+	if err == nil && current.ETag.IsZero() {
+		current.ETag = BodyETag(jsonData)
+	}
+	current.setHeaders(w)
+
+	if statusCode == http.StatusOK && notModified(r, current) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if _, err := w.Write(jsonData); err != nil {
+		log.Errorw("writing response", zap.Error(err))
+	}
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since for a GET or HEAD request.
+func notModified(r *http.Request, current Validators) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return matchesAny(inm, current.ETag, false)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		modified, ok := current.modifiedSince(ims)
+		return ok && !modified
+	}
+	return false
+}
+
+// PreconditionOptions customise how CheckPreconditions evaluates a request.
+type PreconditionOptions struct {
+	// RequireIfMatch rejects requests without an If-Match or If-Unmodified-Since header
+	// with 428 Precondition Required, so clients can't overwrite changes they haven't seen.
+	RequireIfMatch bool
+}
+
+// CheckPreconditions evaluates the If-Match, If-Unmodified-Since and If-None-Match headers
+// of a request which modifies a resource against the resource's current version, for
+// optimistic concurrency control. Options may be nil to use the defaults.
+//
+// It returns an *APIError with status 412 if a precondition fails, which should be sent
+// with apio.Error before making any changes:
+//
+//	if err := apio.CheckPreconditions(r, apio.Validators{ETag: apio.StrongETag(doc.Revision)}, nil); err != nil {
+//		apio.Error(ctx, w, err)
+//		return
+//	}
+func CheckPreconditions(r *http.Request, current Validators, options *PreconditionOptions) error {
+	var opts PreconditionOptions
+	if options != nil {
+		opts = *options
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	ifUnmodifiedSince := r.Header.Get("If-Unmodified-Since")
+
+	switch {
+	case ifMatch != "":
+		if !matchesAny(ifMatch, current.ETag, true) {
+			return preconditionFailed("If-Match header does not match the current version of the resource")
+		}
+	case ifUnmodifiedSince != "":
+		// If-Unmodified-Since is ignored if the date is invalid or the resource has no modification time.
+		if modified, ok := current.modifiedSince(ifUnmodifiedSince); ok && modified {
+			return preconditionFailed("resource has been modified since the If-Unmodified-Since time")
+		}
+	case opts.RequireIfMatch:
+		return NewRequestError(errors.New("request must include an If-Match header"), http.StatusPreconditionRequired)
+	}
+
+	// If-None-Match on a modifying request, such as If-None-Match: * to
+	// only create a resource if it doesn't exist.
+	if inm := r.Header.Get("If-None-Match"); inm != "" && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		if matchesAny(inm, current.ETag, false) {
+			return preconditionFailed("If-None-Match header matches the current version of the resource")
+		}
+	}
+
+	return nil
+}
+
+func preconditionFailed(msg string) error {
+	return NewRequestError(errors.New(msg), http.StatusPreconditionFailed)
+}
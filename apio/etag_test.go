@@ -0,0 +1,138 @@
+package apio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseETags(t *testing.T) {
+	got := parseETags(`"a", W/"b",*, bad, "c,d"`)
+	assert.Equal(t, []ETag{{Tag: "a"}, {Tag: "b", Weak: true}, {Tag: "*"}, {Tag: "c,d"}}, got)
+}
+
+func TestJSONConditional(t *testing.T) {
+	modified := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	body := `{"id":1}`
+	bodyETag := BodyETag([]byte(body)).String()
+
+	type testcase struct {
+		name       string
+		method     string
+		headers    map[string]string
+		current    Validators
+		wantStatus int
+		wantETag   string
+		wantBody   string
+	}
+
+	testcases := []testcase{
+		{name: "no conditions", method: http.MethodGet, wantStatus: http.StatusOK, wantETag: bodyETag, wantBody: body},
+		{name: "body etag matches", method: http.MethodGet, headers: map[string]string{"If-None-Match": bodyETag}, wantStatus: http.StatusNotModified, wantETag: bodyETag},
+		{name: "weak comparison", method: http.MethodGet, headers: map[string]string{"If-None-Match": `"x", W/"v1"`}, current: Validators{ETag: StrongETag("v1")}, wantStatus: http.StatusNotModified, wantETag: `"v1"`},
+		{name: "wildcard", method: http.MethodHead, headers: map[string]string{"If-None-Match": `*`}, current: Validators{ETag: WeakETag("v1")}, wantStatus: http.StatusNotModified, wantETag: `W/"v1"`},
+		{name: "etag changed", method: http.MethodGet, headers: map[string]string{"If-None-Match": `"v1"`}, current: Validators{ETag: StrongETag("v2")}, wantStatus: http.StatusOK, wantETag: `"v2"`, wantBody: body},
+		{name: "not modified since", method: http.MethodGet, headers: map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, current: Validators{LastModified: modified.Add(500 * time.Millisecond)}, wantStatus: http.StatusNotModified, wantETag: bodyETag},
+		{name: "modified since", method: http.MethodGet, headers: map[string]string{"If-Modified-Since": modified.Add(-time.Second).Format(http.TimeFormat)}, current: Validators{LastModified: modified}, wantStatus: http.StatusOK, wantETag: bodyETag, wantBody: body},
+		{name: "if-none-match takes precedence", method: http.MethodGet, headers: map[string]string{"If-None-Match": `"old"`, "If-Modified-Since": modified.Format(http.TimeFormat)}, current: Validators{LastModified: modified}, wantStatus: http.StatusOK, wantETag: bodyETag, wantBody: body},
+		{name: "not a GET", method: http.MethodPost, headers: map[string]string{"If-None-Match": bodyETag}, wantStatus: http.StatusOK, wantETag: bodyETag, wantBody: body},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+
+			JSONConditional(context.Background(), rr, r, testItem{ID: 1}, http.StatusOK, tc.current)
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			assert.Equal(t, tc.wantETag, rr.Header().Get("ETag"))
+			assert.Equal(t, tc.wantBody, rr.Body.String())
+		})
+	}
+}
+
+func TestJSONConditionalLastModified(t *testing.T) {
+	modified := time.Date(2022, 3, 4, 5, 6, 7, 0, time.FixedZone("AEST", 10*60*60))
+	rr := httptest.NewRecorder()
+
+	JSONConditional(context.Background(), rr, httptest.NewRequest(http.MethodGet, "/", nil), testItem{ID: 1}, http.StatusOK, Validators{LastModified: modified})
+
+	assert.Equal(t, "Thu, 03 Mar 2022 19:06:07 GMT", rr.Header().Get("Last-Modified"))
+}
+
+func TestJSONConditionalNoContent(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	JSONConditional(context.Background(), rr, httptest.NewRequest(http.MethodPut, "/", nil), testItem{ID: 1}, http.StatusNoContent, Validators{ETag: StrongETag("v1")})
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, `"v1"`, rr.Header().Get("ETag"))
+	assert.Equal(t, "", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "", rr.Body.String())
+}
+
+func TestJSONConditionalMarshalError(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	JSONConditional(context.Background(), rr, httptest.NewRequest(http.MethodGet, "/", nil), map[string]interface{}{"bad": make(chan int)}, http.StatusOK, Validators{})
+
+	assert.Equal(t, "", rr.Header().Get("ETag"))
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	modified := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	current := Validators{ETag: StrongETag("v2"), LastModified: modified}
+
+	type testcase struct {
+		name       string
+		method     string
+		headers    map[string]string
+		current    Validators
+		options    *PreconditionOptions
+		wantStatus int
+	}
+
+	testcases := []testcase{
+		{name: "no conditions", method: http.MethodPut, current: current},
+		{name: "if-match ok", method: http.MethodPut, headers: map[string]string{"If-Match": `"v1", "v2"`}, current: current},
+		{name: "if-match stale", method: http.MethodPut, headers: map[string]string{"If-Match": `"v1"`}, current: current, wantStatus: http.StatusPreconditionFailed},
+		{name: "if-match uses strong comparison", method: http.MethodPut, headers: map[string]string{"If-Match": `W/"v2"`}, current: current, wantStatus: http.StatusPreconditionFailed},
+		{name: "if-match wildcard exists", method: http.MethodDelete, headers: map[string]string{"If-Match": `*`}, current: current},
+		{name: "if-match wildcard missing", method: http.MethodPut, headers: map[string]string{"If-Match": `*`}, wantStatus: http.StatusPreconditionFailed},
+		{name: "unmodified since", method: http.MethodPatch, headers: map[string]string{"If-Unmodified-Since": modified.Format(http.TimeFormat)}, current: current},
+		{name: "modified since", method: http.MethodPatch, headers: map[string]string{"If-Unmodified-Since": modified.Add(-time.Minute).Format(http.TimeFormat)}, current: current, wantStatus: http.StatusPreconditionFailed},
+		{name: "if-match takes precedence", method: http.MethodPatch, headers: map[string]string{"If-Match": `"v2"`, "If-Unmodified-Since": modified.Add(-time.Minute).Format(http.TimeFormat)}, current: current},
+		{name: "invalid date is ignored", method: http.MethodPatch, headers: map[string]string{"If-Unmodified-Since": "yesterday"}, current: current},
+		{name: "create only", method: http.MethodPut, headers: map[string]string{"If-None-Match": `*`}},
+		{name: "create only exists", method: http.MethodPut, headers: map[string]string{"If-None-Match": `*`}, current: current, wantStatus: http.StatusPreconditionFailed},
+		{name: "required", method: http.MethodPut, current: current, options: &PreconditionOptions{RequireIfMatch: true}, wantStatus: http.StatusPreconditionRequired},
+		{name: "required and provided", method: http.MethodPut, headers: map[string]string{"If-Match": `"v2"`}, current: current, options: &PreconditionOptions{RequireIfMatch: true}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+
+			err := CheckPreconditions(r, tc.current, tc.options)
+			if tc.wantStatus == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			rr := httptest.NewRecorder()
+			Error(context.Background(), rr, err)
+			assert.Equal(t, tc.wantStatus, rr.Code)
+		})
+	}
+}
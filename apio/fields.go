@@ -0,0 +1,253 @@
+package apio
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// FieldsParam is the query parameter read by ParseFields.
+const FieldsParam = "fields"
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// FieldsOptions customise the fields a client can request.
+type FieldsOptions struct {
+	// Allowed are the field paths clients may request, such as "owner.email".
+	// Any field inside an allowed path may also be requested. If nil, any field of
+	// the response type may be requested.
+	Allowed []string
+}
+
+// Fieldset is a set of fields to include in a JSON response. The zero
+// value includes every field.
+type Fieldset struct {
+	root *fieldNode
+}
+
+// fieldNode is a field in a Fieldset. A node without children includes
+// the whole value of the field.
+type fieldNode struct {
+	children map[string]*fieldNode
+}
+
+// ParseFields reads the fields query parameter of a request, which is a comma-separated
+// list of field paths to include in the response, such as:
+//
+//	?fields=id,name,owner.email
+//
+// Paths are the JSON names of fields, separated by dots for nested objects. Arrays are
+// projected element by element, so if owners is an array, owners.email selects the email
+// field of each owner. The fields are checked against T, the type of the response.
+// Options may be nil to use the defaults.
+//
+// If the parameter isn't provided or is empty, the returned Fieldset includes every field. An
+// *APIError with status 400 is returned if a field doesn't exist or isn't allowed.
+func ParseFields[T any](r *http.Request, options *FieldsOptions) (Fieldset, error) {
+	var opts FieldsOptions
+	if options != nil {
+		opts = *options
+	}
+
+	// An empty parameter, such as ?fields=, is treated the same as a missing one.
+	var values []string
+	for _, v := range r.URL.Query()[FieldsParam] {
+		if strings.TrimSpace(v) != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return Fieldset{}, nil
+	}
+
+	var allowed *fieldNode
+	if opts.Allowed != nil {
+		allowed = &fieldNode{children: map[string]*fieldNode{}}
+		for _, p := range opts.Allowed {
+			allowed.add(strings.Split(p, "."))
+		}
+	}
+
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	fs := Fieldset{root: &fieldNode{children: map[string]*fieldNode{}}}
+
+	var fieldErrs []FieldError
+	fail := func(msg string) {
+		fieldErrs = append(fieldErrs, FieldError{Field: FieldsParam, Error: msg})
+	}
+
+	for _, v := range values {
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			path := strings.Split(p, ".")
+			switch {
+			case contains(path, ""):
+				fail(fmt.Sprintf("%q is not a valid field path", p))
+			case !hasFieldPath(t, path):
+				fail(fmt.Sprintf("unknown field %q", p))
+			case allowed != nil && !allowed.allows(path):
+				fail(fmt.Sprintf("field %q is not allowed", p))
+			default:
+				fs.root.add(path)
+			}
+		}
+	}
+
+	if err := paramsError("request contains invalid query parameters", fieldErrs); err != nil {
+		return Fieldset{}, err
+	}
+	return fs, nil
+}
+
+// Includes reports whether a field path, such as "owner.email", is included in the
+// Fieldset. Handlers can use it to avoid loading data which won't be sent.
+func (f Fieldset) Includes(path string) bool {
+	n := f.root
+	for _, name := range strings.Split(path, ".") {
+		if n == nil || n.children == nil {
+			return true
+		}
+		n = n.children[name]
+		if n == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// add adds a path to the node. Paths which are already included by
+// a shorter path are ignored.
+func (n *fieldNode) add(path []string) {
+	for i, name := range path {
+		child, ok := n.children[name]
+		if ok && child.children == nil {
+			return
+		}
+		if !ok {
+			child = &fieldNode{}
+			n.children[name] = child
+		}
+		if i < len(path)-1 && child.children == nil {
+			child.children = map[string]*fieldNode{}
+		}
+		n = child
+	}
+	// the whole field is included.
+	n.children = nil
+}
+
+// allows reports whether the path is inside one of the node's paths.
+func (n *fieldNode) allows(path []string) bool {
+	for _, name := range path {
+		if n.children == nil {
+			return true
+		}
+		n = n.children[name]
+		if n == nil {
+			return false
+		}
+	}
+	return n.children == nil
+}
+
+// project removes the fields which aren't included in the node from a decoded JSON document.
+func (n *fieldNode) project(doc interface{}) interface{} {
+	if n.children == nil {
+		return doc
+	}
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(n.children))
+		for name, child := range n.children {
+			if val, ok := v[name]; ok {
+				out[name] = child.project(val)
+			}
+		}
+		return out
+	case []interface{}:
+		for i := range v {
+			v[i] = n.project(v[i])
+		}
+		return v
+	}
+	return doc
+}
+
+// hasFieldPath reports whether a field path can exist in the JSON encoding of t. Paths
+// into values which encode themselves, or into interfaces, can't be checked and are allowed.
+func hasFieldPath(t reflect.Type, path []string) bool {
+	for _, name := range path {
+		t = encodedType(t)
+		if t == nil {
+			return true
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			f, ok := structFields(t)[name]
+			if !ok {
+				return false
+			}
+			t = f.typ
+		case reflect.Map:
+			t = t.Elem()
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// encodedType returns the type whose fields appear in the JSON encoding of t, looking
+// through pointers, arrays, slices and Optional values. It returns nil for types which
+// encode themselves or whose encoding isn't known.
+func encodedType(t reflect.Type) reflect.Type {
+	for {
+		if t.Kind() == reflect.Struct && t.Implements(optionalType) {
+			t = reflect.Zero(t).Interface().(optional).valueType()
+			continue
+		}
+		if t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) ||
+			t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+			return nil
+		}
+		switch t.Kind() {
+		case reflect.Interface:
+			return nil
+		case reflect.Ptr:
+			t = t.Elem()
+		case reflect.Slice, reflect.Array:
+			if t.Elem().Kind() == reflect.Uint8 {
+				// []byte is encoded as a base64 string.
+				return t
+			}
+			t = t.Elem()
+		default:
+			return t
+		}
+	}
+}
+
+// JSONFields is like JSON, but only sends the fields of data which are included in fields.
+// If fields is the zero Fieldset, every field is sent.
+func JSONFields(ctx context.Context, w http.ResponseWriter, data interface{}, statusCode int, fields Fieldset) {
+	if fields.root == nil || statusCode == http.StatusNoContent {
+		JSON(ctx, w, data, statusCode)
+		return
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		JSON(ctx, w, data, statusCode)
+		return
+	}
+	doc, err := unmarshalDocument(omitAbsent(data, encoded))
+	if err != nil {
+		JSON(ctx, w, data, statusCode)
+		return
+	}
+	JSON(ctx, w, fields.root.project(doc), statusCode)
+}
@@ -0,0 +1,134 @@
+package apio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fieldsOwner struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type fieldsResource struct {
+	ID       string                 `json:"id"`
+	Name     string                 `json:"name"`
+	Owner    *fieldsOwner           `json:"owner"`
+	Members  []fieldsOwner          `json:"members"`
+	Labels   map[string]string      `json:"labels"`
+	Created  time.Time              `json:"created"`
+	Extra    interface{}            `json:"extra"`
+	Nickname Optional[fieldsOwner]  `json:"nickname"`
+	Meta     map[string]fieldsOwner `json:"meta"`
+}
+
+func TestParseFields(t *testing.T) {
+	type testcase struct {
+		name      string
+		giveQuery string
+		giveOpts  *FieldsOptions
+		wantErrs  []string
+	}
+
+	testcases := []testcase{
+		{name: "none", giveQuery: ""},
+		{name: "empty", giveQuery: "fields="},
+		{name: "top level", giveQuery: "fields=id,name"},
+		{name: "nested", giveQuery: "fields=owner.email,members.name,labels.team,meta.x.email,nickname.name"},
+		{name: "repeated parameter", giveQuery: "fields=id&fields=name"},
+		{name: "repeated with empty", giveQuery: "fields=&fields=id"},
+		{name: "unchecked types", giveQuery: "fields=extra.anything,created.year"},
+		{name: "unknown", giveQuery: "fields=id,nmae,owner.mail,name.first", wantErrs: []string{`unknown field "nmae"`, `unknown field "owner.mail"`, `unknown field "name.first"`}},
+		{name: "empty path", giveQuery: "fields=id,,owner.", wantErrs: []string{`"" is not a valid field path`, `"owner." is not a valid field path`}},
+		{name: "allowed", giveQuery: "fields=id,owner.email", giveOpts: &FieldsOptions{Allowed: []string{"id", "owner"}}},
+		{name: "not allowed", giveQuery: "fields=name,owner", giveOpts: &FieldsOptions{Allowed: []string{"id", "owner.email"}}, wantErrs: []string{`field "name" is not allowed`, `field "owner" is not allowed`}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?"+tc.giveQuery, nil)
+			_, err := ParseFields[fieldsResource](r, tc.giveOpts)
+			if tc.wantErrs == nil {
+				assert.NoError(t, err)
+				return
+			}
+			apiErr, ok := err.(*APIError)
+			if assert.True(t, ok) {
+				assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+				var msgs []string
+				for _, f := range apiErr.Fields {
+					assert.Equal(t, "fields", f.Field)
+					msgs = append(msgs, f.Error)
+				}
+				assert.Equal(t, tc.wantErrs, msgs)
+			}
+		})
+	}
+}
+
+func TestParseFieldsEmpty(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/?fields=", nil)
+	fs, err := ParseFields[fieldsResource](r, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, Fieldset{}, fs)
+}
+
+func TestJSONFields(t *testing.T) {
+	data := []fieldsResource{
+		{
+			ID:      "1",
+			Name:    "a",
+			Owner:   &fieldsOwner{Email: "a@example.com", Name: "A"},
+			Members: []fieldsOwner{{Email: "b@example.com", Name: "B"}, {Email: "c@example.com", Name: "C"}},
+		},
+		{ID: "2", Name: "b"},
+	}
+
+	type testcase struct {
+		name      string
+		giveQuery string
+		want      string
+	}
+
+	testcases := []testcase{
+		{name: "top level", giveQuery: "fields=id", want: `[{"id":"1"},{"id":"2"}]`},
+		{name: "nested", giveQuery: "fields=id,owner.email,members.name", want: `[{"id":"1","owner":{"email":"a@example.com"},"members":[{"name":"B"},{"name":"C"}]},{"id":"2","owner":null,"members":null}]`},
+		{name: "whole object wins over nested path", giveQuery: "fields=owner.email,owner&fields=id", want: `[{"id":"1","owner":{"email":"a@example.com","name":"A"}},{"id":"2","owner":null}]`},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?"+tc.giveQuery, nil)
+			fields, err := ParseFields[[]fieldsResource](r, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			JSONFields(context.Background(), rr, data, http.StatusOK, fields)
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.JSONEq(t, tc.want, rr.Body.String())
+		})
+	}
+}
+
+func TestFieldsetIncludes(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/?fields=id,owner.email", nil)
+	fields, err := ParseFields[fieldsResource](r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, fields.Includes("id"))
+	assert.True(t, fields.Includes("owner"))
+	assert.True(t, fields.Includes("owner.email"))
+	assert.False(t, fields.Includes("owner.name"))
+	assert.False(t, fields.Includes("members"))
+	assert.True(t, Fieldset{}.Includes("members"))
+}
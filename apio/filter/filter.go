@@ -0,0 +1,409 @@
+// Package filter parses the filter and sort query parameters of list endpoints.
+//
+// Filters are written in a small expression language:
+//
+//	status eq 'active' and (age gt 30 or name contains "smith") and role in ['admin', 'owner']
+//
+// Comparisons are a field name, an operator (eq, ne, lt, gt, in or contains) and a value,
+// which is a quoted string, a number, true or false, or a list of values for "in".
+// Comparisons can be combined with "and" and "or", where "and" binds more tightly,
+// and grouped with parentheses.
+//
+// Sorts are a comma-separated list of fields, each optionally prefixed with "-"
+// for descending order or "+" for ascending order:
+//
+//	-created_at,name
+//
+// Expressions are validated against a Schema listing the fields an endpoint supports,
+// and the values in the resulting AST are converted to the Go type of each field.
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/common-fate/apikit/apio"
+)
+
+// The query parameters read by Parse.
+const (
+	FilterParam = "filter"
+	SortParam   = "sort"
+)
+
+// Operator is a comparison operator.
+type Operator string
+
+const (
+	Eq       Operator = "eq"
+	Ne       Operator = "ne"
+	Lt       Operator = "lt"
+	Gt       Operator = "gt"
+	In       Operator = "in"
+	Contains Operator = "contains"
+)
+
+func (o Operator) valid() bool {
+	switch o {
+	case Eq, Ne, Lt, Gt, In, Contains:
+		return true
+	}
+	return false
+}
+
+// LogicalOp combines two expressions.
+type LogicalOp string
+
+const (
+	And LogicalOp = "and"
+	Or  LogicalOp = "or"
+)
+
+// Expr is a node in a filter expression: either a *Logical or a *Comparison.
+type Expr interface {
+	// Pos returns the byte offset of the expression in the filter.
+	Pos() int
+}
+
+// Logical is two expressions combined with "and" or "or".
+type Logical struct {
+	Op          LogicalOp
+	Left, Right Expr
+	// Position is the byte offset of the operator in the filter.
+	Position int
+}
+
+// Pos implements Expr.
+func (l *Logical) Pos() int {
+	return l.Position
+}
+
+// Comparison compares a field with a value.
+type Comparison struct {
+	Field string
+	Op    Operator
+	// Value has the Go type of the field's Type: string, float64, int64, bool
+	// or time.Time. For the "in" operator it's a []interface{} of these.
+	Value interface{}
+	// Position is the byte offset of the field name in the filter.
+	Position int
+}
+
+// Pos implements Expr.
+func (c *Comparison) Pos() int {
+	return c.Position
+}
+
+// number is a number which hasn't been converted to the type of its field yet.
+type number string
+
+// Type is the type of a filterable field.
+type Type int
+
+const (
+	// String fields support all operators.
+	String Type = iota
+	// Number fields are compared with float64 values.
+	// They support eq, ne, lt, gt and in.
+	Number
+	// Integer fields are compared with int64 values.
+	// They support eq, ne, lt, gt and in.
+	Integer
+	// Bool fields support eq and ne.
+	Bool
+	// Time fields are compared with time.Time values, written as RFC 3339 strings.
+	// They support eq, ne, lt, gt and in.
+	Time
+)
+
+func (t Type) String() string {
+	switch t {
+	case Number:
+		return "a number"
+	case Integer:
+		return "an integer"
+	case Bool:
+		return "true or false"
+	case Time:
+		return "an RFC 3339 time"
+	}
+	return "a string"
+}
+
+func (t Type) operators() []Operator {
+	switch t {
+	case Number, Integer, Time:
+		return []Operator{Eq, Ne, Lt, Gt, In}
+	case Bool:
+		return []Operator{Eq, Ne}
+	}
+	return []Operator{Eq, Ne, Lt, Gt, In, Contains}
+}
+
+// Field describes a field which can be filtered or sorted on.
+type Field struct {
+	Type Type
+	// Operators are the operators allowed for the field.
+	// If nil, all the operators supported by Type are allowed.
+	Operators []Operator
+	// Sortable allows the field to be used in sorts.
+	Sortable bool
+	// NoFilter prevents the field from being used in filters, for fields which are only sortable.
+	NoFilter bool
+}
+
+// Schema is the allowlist of fields an endpoint supports, by name.
+type Schema map[string]Field
+
+// Sort is a field to sort by.
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// Query is the parsed filter and sort of a request.
+type Query struct {
+	// Filter is nil if no filter was provided.
+	Filter Expr
+	Sort   []Sort
+}
+
+// Parse reads the filter and sort query parameters of a request, and validates them
+// against the schema. An *apio.APIError with status 400 is returned if either is
+// invalid, with FieldErrors naming the position of each problem.
+func Parse(r *http.Request, schema Schema) (Query, error) {
+	query := r.URL.Query()
+
+	var q Query
+	var fieldErrs []apio.FieldError
+	for _, param := range []string{FilterParam, SortParam} {
+		if len(query[param]) > 1 {
+			fieldErrs = append(fieldErrs, apio.FieldError{Field: param, Error: "must only be provided once"})
+		}
+	}
+
+	var err error
+	if f := query.Get(FilterParam); f != "" {
+		q.Filter, err = ParseFilter(f, schema)
+		fieldErrs = appendFieldErrors(fieldErrs, err)
+	}
+	if s := query.Get(SortParam); s != "" {
+		q.Sort, err = ParseSort(s, schema)
+		fieldErrs = appendFieldErrors(fieldErrs, err)
+	}
+
+	if len(fieldErrs) > 0 {
+		return Query{}, invalidParams(fieldErrs)
+	}
+	return q, nil
+}
+
+func appendFieldErrors(fieldErrs []apio.FieldError, err error) []apio.FieldError {
+	var apiErr *apio.APIError
+	if errors.As(err, &apiErr) {
+		return append(fieldErrs, apiErr.Fields...)
+	}
+	return fieldErrs
+}
+
+func invalidParams(fieldErrs []apio.FieldError) error {
+	return &apio.APIError{
+		Err:    errors.New("request contains invalid query parameters"),
+		Status: http.StatusBadRequest,
+		Fields: fieldErrs,
+	}
+}
+
+// ParseFilter parses a filter expression and validates it against the schema.
+// An *apio.APIError with status 400 is returned if it's invalid.
+func ParseFilter(s string, schema Schema) (Expr, error) {
+	e, err := parse(s)
+	var syntaxErr *syntaxError
+	if errors.As(err, &syntaxErr) {
+		return nil, invalidParams([]apio.FieldError{fieldError(FilterParam, s, syntaxErr)})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	v := validator{schema: schema}
+	v.expr(e)
+	if len(v.errs) > 0 {
+		fieldErrs := make([]apio.FieldError, len(v.errs))
+		for i, err := range v.errs {
+			fieldErrs[i] = fieldError(FilterParam, s, err)
+		}
+		return nil, invalidParams(fieldErrs)
+	}
+	return e, nil
+}
+
+// fieldError formats an error with its 1-indexed character position in the input.
+func fieldError(param, input string, err *syntaxError) apio.FieldError {
+	pos := utf8.RuneCountInString(input[:err.pos]) + 1
+	return apio.FieldError{Field: param, Error: fmt.Sprintf("%s (at position %d)", err.msg, pos)}
+}
+
+type validator struct {
+	schema Schema
+	errs   []*syntaxError
+}
+
+func (v *validator) fail(pos int, format string, args ...interface{}) {
+	v.errs = append(v.errs, &syntaxError{pos: pos, msg: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) expr(e Expr) {
+	switch e := e.(type) {
+	case *Logical:
+		v.expr(e.Left)
+		v.expr(e.Right)
+	case *Comparison:
+		v.comparison(e)
+	}
+}
+
+func (v *validator) comparison(c *Comparison) {
+	f, ok := v.schema[c.Field]
+	if !ok || f.NoFilter {
+		v.fail(c.Position, "unknown field %q", c.Field)
+		return
+	}
+
+	ops := f.Operators
+	if ops == nil {
+		ops = f.Type.operators()
+	}
+	if !hasOperator(ops, c.Op) {
+		v.fail(c.Position, "field %q does not support the %q operator", c.Field, c.Op)
+		return
+	}
+
+	list, isList := c.Value.([]interface{})
+	switch {
+	case c.Op == In && !isList:
+		v.fail(c.Position, "the \"in\" operator must be used with a list such as ['a', 'b']")
+		return
+	case c.Op != In && isList:
+		v.fail(c.Position, "the %q operator can't be used with a list", c.Op)
+		return
+	}
+
+	if isList {
+		converted := make([]interface{}, len(list))
+		for i, item := range list {
+			val, ok := convert(item, f.Type)
+			if !ok {
+				v.fail(c.Position, "field %q must be compared with %s", c.Field, f.Type)
+				return
+			}
+			converted[i] = val
+		}
+		c.Value = converted
+		return
+	}
+
+	val, ok := convert(c.Value, f.Type)
+	if !ok {
+		v.fail(c.Position, "field %q must be compared with %s", c.Field, f.Type)
+		return
+	}
+	c.Value = val
+}
+
+// convert converts a parsed value to the Go type for t.
+func convert(value interface{}, t Type) (interface{}, bool) {
+	switch t {
+	case String:
+		s, ok := value.(string)
+		return s, ok
+	case Number:
+		n, ok := value.(number)
+		if !ok {
+			return nil, false
+		}
+		f, err := strconv.ParseFloat(string(n), 64)
+		return f, err == nil
+	case Integer:
+		n, ok := value.(number)
+		if !ok {
+			return nil, false
+		}
+		i, err := strconv.ParseInt(string(n), 10, 64)
+		return i, err == nil
+	case Bool:
+		b, ok := value.(bool)
+		return b, ok
+	case Time:
+		s, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		return t, err == nil
+	}
+	return nil, false
+}
+
+func hasOperator(ops []Operator, op Operator) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSort parses a sort and validates it against the schema.
+// An *apio.APIError with status 400 is returned if it's invalid.
+func ParseSort(s string, schema Schema) ([]Sort, error) {
+	var sorts []Sort
+	var fieldErrs []apio.FieldError
+	seen := map[string]bool{}
+
+	pos := 0
+	for _, part := range strings.Split(s, ",") {
+		start := pos
+		pos += len(part) + 1
+
+		name := strings.TrimSpace(part)
+		start += strings.Index(part, name)
+		desc := false
+		switch {
+		case strings.HasPrefix(name, "-"):
+			desc = true
+			name = name[1:]
+		case strings.HasPrefix(name, "+"):
+			name = name[1:]
+		}
+
+		var msg string
+		switch f, ok := schema[name]; {
+		case name == "":
+			msg = "expected a field name"
+		case !ok:
+			msg = fmt.Sprintf("unknown field %q", name)
+		case !f.Sortable:
+			msg = fmt.Sprintf("field %q can't be sorted by", name)
+		case seen[name]:
+			msg = fmt.Sprintf("field %q must only be sorted by once", name)
+		}
+		if msg != "" {
+			fieldErrs = append(fieldErrs, fieldError(SortParam, s, &syntaxError{pos: start, msg: msg}))
+			continue
+		}
+
+		seen[name] = true
+		sorts = append(sorts, Sort{Field: name, Desc: desc})
+	}
+
+	if len(fieldErrs) > 0 {
+		return nil, invalidParams(fieldErrs)
+	}
+	return sorts, nil
+}
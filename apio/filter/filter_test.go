@@ -0,0 +1,228 @@
+package filter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/common-fate/apikit/apio"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSchema = Schema{
+	"status":     {Type: String, Operators: []Operator{Eq, Ne, In}},
+	"name":       {Type: String, Sortable: true},
+	"age":        {Type: Integer, Sortable: true},
+	"score":      {Type: Number},
+	"admin":      {Type: Bool},
+	"created_at": {Type: Time, Sortable: true},
+	"rank":       {Sortable: true, NoFilter: true},
+}
+
+func TestParseFilter(t *testing.T) {
+	created := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	type testcase struct {
+		name string
+		give string
+		want Expr
+	}
+
+	testcases := []testcase{
+		{
+			name: "comparison",
+			give: "status eq 'active'",
+			want: &Comparison{Field: "status", Op: Eq, Value: "active"},
+		},
+		{
+			name: "typed values",
+			give: `age gt 30 and score lt -1.5e2 and admin ne true and created_at gt "2022-01-02T03:04:05Z"`,
+			want: &Logical{
+				Op: And, Position: 48,
+				Left: &Logical{
+					Op: And, Position: 30,
+					Left: &Logical{
+						Op: And, Position: 10,
+						Left:  &Comparison{Field: "age", Op: Gt, Value: int64(30)},
+						Right: &Comparison{Field: "score", Op: Lt, Value: -150.0, Position: 14},
+					},
+					Right: &Comparison{Field: "admin", Op: Ne, Value: true, Position: 34},
+				},
+				Right: &Comparison{Field: "created_at", Op: Gt, Value: created, Position: 52},
+			},
+		},
+		{
+			name: "and binds more tightly than or",
+			give: "name eq 'a' or name eq 'b' and age lt 3",
+			want: &Logical{
+				Op: Or, Position: 12,
+				Left: &Comparison{Field: "name", Op: Eq, Value: "a"},
+				Right: &Logical{
+					Op: And, Position: 27,
+					Left:  &Comparison{Field: "name", Op: Eq, Value: "b", Position: 15},
+					Right: &Comparison{Field: "age", Op: Lt, Value: int64(3), Position: 31},
+				},
+			},
+		},
+		{
+			name: "parentheses",
+			give: "(name eq 'a' OR name eq 'b') and age lt 3",
+			want: &Logical{
+				Op: And, Position: 29,
+				Left: &Logical{
+					Op: Or, Position: 13,
+					Left:  &Comparison{Field: "name", Op: Eq, Value: "a", Position: 1},
+					Right: &Comparison{Field: "name", Op: Eq, Value: "b", Position: 16},
+				},
+				Right: &Comparison{Field: "age", Op: Lt, Value: int64(3), Position: 33},
+			},
+		},
+		{
+			name: "in list",
+			give: "status in ['active', \"pending\"]",
+			want: &Comparison{Field: "status", Op: In, Value: []interface{}{"active", "pending"}},
+		},
+		{
+			name: "escaped quotes",
+			give: `name contains 'O\'Brien'`,
+			want: &Comparison{Field: "name", Op: Contains, Value: "O'Brien"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseFilter(tc.give, testSchema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	type testcase struct {
+		name string
+		give string
+		want []string
+	}
+
+	testcases := []testcase{
+		{name: "unterminated string", give: "name eq 'abc", want: []string{"unterminated string (at position 9)"}},
+		{name: "missing operator", give: "name 'abc'", want: []string{`expected an operator (eq, ne, lt, gt, in or contains) after "name" but found "abc" (at position 6)`}},
+		{name: "missing value", give: "name eq", want: []string{"expected a value but found end of filter (at position 8)"}},
+		{name: "missing field", give: "and eq 1", want: []string{`expected a field name but found "and" (at position 1)`}},
+		{name: "unclosed paren", give: "(age eq 1", want: []string{`expected ")" but found end of filter (at position 10)`}},
+		{name: "trailing tokens", give: "age eq 1 age", want: []string{`unexpected "age" (at position 10)`}},
+		{name: "unexpected character", give: "age eq 1 & age eq 2", want: []string{`unexpected character '&' (at position 10)`}},
+		{name: "position counts characters", give: "name eq 'é' and x", want: []string{`expected an operator (eq, ne, lt, gt, in or contains) after "x" but found end of filter (at position 18)`}},
+		{name: "nested list", give: "status in [['a']]", want: []string{`expected a value but found "[" (at position 12)`}},
+		{
+			name: "validation errors are all reported",
+			give: "foo eq 1 and status contains 'a' and age eq 'x' and admin in [true] and rank eq 1 and score gt 1.5",
+			want: []string{
+				`unknown field "foo" (at position 1)`,
+				`field "status" does not support the "contains" operator (at position 14)`,
+				`field "age" must be compared with an integer (at position 38)`,
+				`field "admin" does not support the "in" operator (at position 53)`,
+				`unknown field "rank" (at position 73)`,
+			},
+		},
+		{name: "in without list", give: "status in 'a'", want: []string{`the "in" operator must be used with a list such as ['a', 'b'] (at position 1)`}},
+		{name: "list without in", give: "status eq ['a']", want: []string{`the "eq" operator can't be used with a list (at position 1)`}},
+		{name: "invalid time", give: "created_at gt 'yesterday'", want: []string{`field "created_at" must be compared with an RFC 3339 time (at position 1)`}},
+		{name: "non-integer", give: "age eq 1.5", want: []string{`field "age" must be compared with an integer (at position 1)`}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseFilter(tc.give, testSchema)
+			apiErr, ok := err.(*apio.APIError)
+			if !assert.True(t, ok, "expected an *apio.APIError but got %v", err) {
+				return
+			}
+			assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+
+			var got []string
+			for _, f := range apiErr.Fields {
+				assert.Equal(t, "filter", f.Field)
+				got = append(got, f.Error)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseFilterDepth(t *testing.T) {
+	s := ""
+	for i := 0; i < maxDepth+1; i++ {
+		s += "("
+	}
+	_, err := ParseFilter(s+"age eq 1", testSchema)
+	assert.Error(t, err)
+}
+
+func TestParseSort(t *testing.T) {
+	type testcase struct {
+		name    string
+		give    string
+		want    []Sort
+		wantErr []string
+	}
+
+	testcases := []testcase{
+		{name: "ok", give: "-created_at, +name,age", want: []Sort{{Field: "created_at", Desc: true}, {Field: "name"}, {Field: "age"}}},
+		{name: "sort only field", give: "rank", want: []Sort{{Field: "rank"}}},
+		{
+			name:    "invalid",
+			give:    "name,score,,foo,-name",
+			wantErr: []string{`field "score" can't be sorted by (at position 6)`, `expected a field name (at position 12)`, `unknown field "foo" (at position 13)`, `field "name" must only be sorted by once (at position 17)`},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseSort(tc.give, testSchema)
+			if tc.wantErr != nil {
+				apiErr, ok := err.(*apio.APIError)
+				if assert.True(t, ok) {
+					var msgs []string
+					for _, f := range apiErr.Fields {
+						msgs = append(msgs, f.Error)
+					}
+					assert.Equal(t, tc.wantErr, msgs)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	q := url.Values{"filter": {"age gt 1"}, "sort": {"-age"}}
+	r := httptest.NewRequest(http.MethodGet, "/users?"+q.Encode(), nil)
+
+	got, err := Parse(r, testSchema)
+	assert.NoError(t, err)
+	assert.Equal(t, Query{
+		Filter: &Comparison{Field: "age", Op: Gt, Value: int64(1)},
+		Sort:   []Sort{{Field: "age", Desc: true}},
+	}, got)
+
+	q = url.Values{"filter": {"age gt"}, "sort": {"foo"}}
+	r = httptest.NewRequest(http.MethodGet, "/users?"+q.Encode(), nil)
+
+	_, err = Parse(r, testSchema)
+	assert.Equal(t, &apio.APIError{
+		Err:    err.(*apio.APIError).Err,
+		Status: http.StatusBadRequest,
+		Fields: []apio.FieldError{
+			{Field: "filter", Error: "expected a value but found end of filter (at position 7)"},
+			{Field: "sort", Error: `unknown field "foo" (at position 1)`},
+		},
+	}, err)
+}
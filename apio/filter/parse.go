@@ -0,0 +1,311 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxLength is the longest filter expression accepted, in bytes.
+	maxLength = 2048
+	// maxDepth is the deepest nesting of parentheses accepted.
+	maxDepth = 32
+)
+
+// syntaxError is a problem found in a filter or sort, at the byte offset pos.
+type syntaxError struct {
+	pos int
+	msg string
+}
+
+func (e *syntaxError) Error() string {
+	return e.msg
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	// text is the raw text of the token, or the unquoted value for strings.
+	text string
+	pos  int
+}
+
+// describe returns the token as it should appear in an error message.
+func (t token) describe() string {
+	if t.kind == tokEOF {
+		return "end of filter"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lex splits a filter expression into tokens.
+func lex(s string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '(':
+			toks = append(toks, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			toks = append(toks, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case c == '[':
+			toks = append(toks, token{kind: tokLBracket, text: "[", pos: i})
+			i++
+		case c == ']':
+			toks = append(toks, token{kind: tokRBracket, text: "]", pos: i})
+			i++
+		case c == ',':
+			toks = append(toks, token{kind: tokComma, text: ",", pos: i})
+			i++
+
+		case c == '\'' || c == '"':
+			start := i
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(s) {
+					return nil, &syntaxError{pos: start, msg: "unterminated string"}
+				}
+				if s[i] == '\\' && i+1 < len(s) && (s[i+1] == c || s[i+1] == '\\') {
+					b.WriteByte(s[i+1])
+					i += 2
+					continue
+				}
+				if s[i] == c {
+					i++
+					break
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			toks = append(toks, token{kind: tokString, text: b.String(), pos: start})
+
+		case c == '-' || isDigit(c):
+			start := i
+			i++
+			for i < len(s) && (isDigit(s[i]) || s[i] == '.' || s[i] == 'e' || s[i] == 'E' ||
+				((s[i] == '+' || s[i] == '-') && (s[i-1] == 'e' || s[i-1] == 'E'))) {
+				i++
+			}
+			text := s[start:i]
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, &syntaxError{pos: start, msg: fmt.Sprintf("invalid number %q", text)}
+			}
+			toks = append(toks, token{kind: tokNumber, text: text, pos: start})
+
+		case isIdentStart(c):
+			start := i
+			for i < len(s) && (isIdentStart(s[i]) || isDigit(s[i]) || s[i] == '.') {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, text: s[start:i], pos: start})
+
+		default:
+			r, _ := utf8.DecodeRuneInString(s[i:])
+			return nil, &syntaxError{pos: i, msg: fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(s)}), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// parser is a recursive descent parser for filter expressions:
+//
+//	expr       = and { "or" and }
+//	and        = term { "and" term }
+//	term       = "(" expr ")" | comparison
+//	comparison = field operator value
+//	value      = string | number | "true" | "false" | "[" value { "," value } "]"
+type parser struct {
+	toks  []token
+	i     int
+	depth int
+}
+
+// parse parses a filter expression without validating it against a schema.
+func parse(s string) (Expr, error) {
+	if len(s) > maxLength {
+		return nil, &syntaxError{pos: 0, msg: fmt.Sprintf("must not be longer than %d characters", maxLength)}
+	}
+	toks, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &syntaxError{pos: t.pos, msg: fmt.Sprintf("unexpected %s", t.describe())}
+	}
+	return e, nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// keyword reports whether the next token is the given keyword, consuming it if so.
+// Keywords are case-insensitive.
+func (p *parser) keyword(k string) (token, bool) {
+	t := p.peek()
+	if t.kind == tokIdent && strings.EqualFold(t.text, k) {
+		p.i++
+		return t, true
+	}
+	return t, false
+}
+
+func (p *parser) expr() (Expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.keyword(string(Or))
+		if !ok {
+			return left, nil
+		}
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &Logical{Op: Or, Left: left, Right: right, Position: t.pos}
+	}
+}
+
+func (p *parser) and() (Expr, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.keyword(string(And))
+		if !ok {
+			return left, nil
+		}
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = &Logical{Op: And, Left: left, Right: right, Position: t.pos}
+	}
+}
+
+func (p *parser) term() (Expr, error) {
+	t := p.peek()
+	if t.kind == tokLParen {
+		p.next()
+		p.depth++
+		if p.depth > maxDepth {
+			return nil, &syntaxError{pos: t.pos, msg: fmt.Sprintf("must not be nested more than %d levels deep", maxDepth)}
+		}
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if close := p.next(); close.kind != tokRParen {
+			return nil, &syntaxError{pos: close.pos, msg: fmt.Sprintf("expected \")\" but found %s", close.describe())}
+		}
+		p.depth--
+		return e, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (Expr, error) {
+	field := p.next()
+	if field.kind != tokIdent || isKeyword(field.text) {
+		return nil, &syntaxError{pos: field.pos, msg: fmt.Sprintf("expected a field name but found %s", field.describe())}
+	}
+
+	opTok := p.next()
+	op := Operator(strings.ToLower(opTok.text))
+	if opTok.kind != tokIdent || !op.valid() {
+		return nil, &syntaxError{pos: opTok.pos, msg: fmt.Sprintf("expected an operator (eq, ne, lt, gt, in or contains) after %q but found %s", field.text, opTok.describe())}
+	}
+
+	value, err := p.value(true)
+	if err != nil {
+		return nil, err
+	}
+	return &Comparison{Field: field.text, Op: op, Value: value, Position: field.pos}, nil
+}
+
+func (p *parser) value(allowList bool) (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return t.text, nil
+	case tokNumber:
+		return number(t.text), nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	case tokLBracket:
+		if !allowList {
+			break
+		}
+		var list []interface{}
+		for {
+			v, err := p.value(false)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			sep := p.next()
+			if sep.kind == tokRBracket {
+				return list, nil
+			}
+			if sep.kind != tokComma {
+				return nil, &syntaxError{pos: sep.pos, msg: fmt.Sprintf("expected \",\" or \"]\" but found %s", sep.describe())}
+			}
+		}
+	}
+	return nil, &syntaxError{pos: t.pos, msg: fmt.Sprintf("expected a value but found %s", t.describe())}
+}
+
+func isKeyword(s string) bool {
+	return strings.EqualFold(s, string(And)) || strings.EqualFold(s, string(Or))
+}
@@ -0,0 +1,162 @@
+package apio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// errStopWalk is returned from a visitFunc to stop walking a JSON document early.
+var errStopWalk = errors.New("stop walking")
+
+// jsonPointer builds an RFC 6901 JSON Pointer from a list of reference tokens,
+// such as /items/3/price. The root of the document is the empty string.
+func jsonPointer(tokens []string) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteByte('/')
+		t = strings.ReplaceAll(t, "~", "~0")
+		t = strings.ReplaceAll(t, "/", "~1")
+		b.WriteString(t)
+	}
+	return b.String()
+}
+
+// parseJSONPointer splits an RFC 6901 JSON Pointer into its unescaped reference tokens.
+func parseJSONPointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, errors.New("must be a JSON Pointer starting with /")
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for i, t := range tokens {
+		// '~' must only be used in the escape sequences ~0 and ~1.
+		if strings.Count(t, "~") != strings.Count(t, "~0")+strings.Count(t, "~1") {
+			return nil, errors.New("must be a JSON Pointer with valid ~0 and ~1 escapes")
+		}
+		t = strings.ReplaceAll(t, "~1", "/")
+		tokens[i] = strings.ReplaceAll(t, "~0", "~")
+	}
+	return tokens, nil
+}
+
+// position is a human-friendly location in a JSON document.
+type position struct {
+	Line   int
+	Column int
+}
+
+// positionAt converts a byte offset in data into a 1-indexed line and column.
+func positionAt(data []byte, offset int64) position {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := int(offset) - (bytes.LastIndexByte(before, '\n') + 1) + 1
+	return position{Line: line, Column: col}
+}
+
+// String formats the position for use in error messages.
+func (p position) String() string {
+	return "line " + strconv.Itoa(p.Line) + ", column " + strconv.Itoa(p.Column)
+}
+
+// skipSeparators returns the offset of the first byte at or after offset
+// which isn't whitespace or a JSON separator. The json.Decoder reports offsets
+// directly after the previous token, so this is used to find where a value starts.
+func skipSeparators(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) {
+		switch data[offset] {
+		case ' ', '\t', '\n', '\r', ',', ':':
+			offset++
+		default:
+			return offset
+		}
+	}
+	return offset
+}
+
+// visitFunc is called for each value in a JSON document, with the path to the value
+// and the start and end offsets of the token which begins the value.
+// For objects and arrays the token is the opening delimiter.
+//
+// The path slice is reused between calls and must be copied if it is retained.
+type visitFunc func(path []string, tok json.Token, start, end int64) error
+
+// walkJSON walks the first JSON value in data, calling visit for every nested value.
+func walkJSON(data []byte, visit visitFunc) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err := walkValue(dec, data, nil, visit)
+	if err == errStopWalk {
+		return nil
+	}
+	return err
+}
+
+func walkValue(dec *json.Decoder, data []byte, path []string, visit visitFunc) error {
+	start := skipSeparators(data, dec.InputOffset())
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if err := visit(path, tok, start, dec.InputOffset()); err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			k, _ := key.(string)
+			if err := walkValue(dec, data, append(path, k), visit); err != nil {
+				return err
+			}
+		}
+		// consume the closing '}'
+		_, err = dec.Token()
+		return err
+
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := walkValue(dec, data, append(path, strconv.Itoa(i)), visit); err != nil {
+				return err
+			}
+		}
+		// consume the closing ']'
+		_, err = dec.Token()
+		return err
+	}
+
+	return nil
+}
+
+// valueAt finds the value in data which the json package reported an error for.
+// encoding/json reports the offset directly after the token which caused
+// the error, so we look for the first token ending at or after the offset.
+//
+// It returns the JSON Pointer to the value and the offset where the value starts.
+func valueAt(data []byte, offset int64) (string, int64) {
+	ptr := ""
+	start := offset
+	_ = walkJSON(data, func(path []string, tok json.Token, s, e int64) error {
+		if e >= offset {
+			ptr = jsonPointer(path)
+			start = s
+			return errStopWalk
+		}
+		return nil
+	})
+	return ptr, start
+}
@@ -0,0 +1,43 @@
+package apio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONPointer(t *testing.T) {
+	type testcase struct {
+		name string
+		give []string
+		want string
+	}
+
+	testcases := []testcase{
+		{name: "root", give: nil, want: ""},
+		{name: "nested", give: []string{"items", "3", "price"}, want: "/items/3/price"},
+		{name: "escaped", give: []string{"a/b", "m~n"}, want: "/a~1b/m~0n"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, jsonPointer(tc.give))
+		})
+	}
+}
+
+func TestPositionAt(t *testing.T) {
+	data := []byte("{\n  \"a\": 1,\n  \"b\": 2\n}")
+
+	assert.Equal(t, position{Line: 1, Column: 1}, positionAt(data, 0))
+	assert.Equal(t, position{Line: 2, Column: 3}, positionAt(data, 4))
+	assert.Equal(t, position{Line: 3, Column: 8}, positionAt(data, 19))
+}
+
+func TestValueAt(t *testing.T) {
+	data := []byte(`{"items":[{"price":1},{"price":"x"}]}`)
+
+	ptr, start := valueAt(data, 34)
+	assert.Equal(t, "/items/1/price", ptr)
+	assert.Equal(t, int64(31), start)
+}
@@ -0,0 +1,267 @@
+package apio
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// Optional is a value which records whether a JSON field was absent, explicitly null, or set.
+// It's useful for partial updates, where omitting a field should leave it unchanged but
+// setting it to null should clear it:
+//
+//	type UpdateUser struct {
+//		Name  apio.Optional[string] `json:"name"`
+//		Email apio.Optional[string] `json:"email"`
+//	}
+//
+// DecodeJSONBody checks the wrapped type for unknown fields and invalid values.
+// When marshalled, absent values are encoded as null. apio.JSON omits absent
+// values from objects entirely.
+type Optional[T any] struct {
+	value   T
+	present bool
+	null    bool
+}
+
+// Some returns an Optional which is set to v.
+func Some[T any](v T) Optional[T] {
+	return Optional[T]{value: v, present: true}
+}
+
+// Null returns an Optional which is explicitly null.
+func Null[T any]() Optional[T] {
+	return Optional[T]{present: true, null: true}
+}
+
+// IsPresent reports whether the field was provided, either with a value or as null.
+func (o Optional[T]) IsPresent() bool {
+	return o.present
+}
+
+// IsNull reports whether the field was explicitly set to null.
+func (o Optional[T]) IsNull() bool {
+	return o.present && o.null
+}
+
+// IsSet reports whether the field was provided with a non-null value.
+func (o Optional[T]) IsSet() bool {
+	return o.present && !o.null
+}
+
+// Get returns the value and whether it was set to a non-null value.
+func (o Optional[T]) Get() (T, bool) {
+	return o.value, o.IsSet()
+}
+
+// ValueOr returns the value if it was set, or def otherwise.
+func (o Optional[T]) ValueOr(def T) T {
+	if o.IsSet() {
+		return o.value
+	}
+	return def
+}
+
+// IsZero reports whether the value is absent. This allows the `omitzero`
+// JSON tag option to omit absent values in Go versions which support it.
+func (o Optional[T]) IsZero() bool {
+	return !o.present
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It's only called by
+// encoding/json when the field is present in the document.
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	var zero T
+	o.value = zero
+	o.present = true
+	o.null = bytes.Equal(bytes.TrimSpace(data), []byte("null"))
+	if o.null {
+		return nil
+	}
+	return json.Unmarshal(data, &o.value)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o Optional[T]) MarshalJSON() ([]byte, error) {
+	if !o.IsSet() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(o.value)
+}
+
+func (o Optional[T]) valueType() reflect.Type {
+	return reflect.TypeOf(&o.value).Elem()
+}
+
+// optional is implemented by Optional so that the decoding and
+// encoding helpers can find the wrapped type.
+type optional interface {
+	valueType() reflect.Type
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// omitAbsent removes absent Optional values from the objects in encoded,
+// which is the JSON encoding of data. Absent values in arrays are left as null.
+// Objects are re-encoded with their keys sorted if any values are removed.
+// Nothing is done unless the type of data contains an Optional; see hasOptional.
+func omitAbsent(data interface{}, encoded []byte) []byte {
+	v := reflect.ValueOf(data)
+	if !v.IsValid() || !hasOptional(v.Type()) || !bytes.Contains(encoded, []byte("null")) {
+		return encoded
+	}
+
+	doc, err := unmarshalDocument(encoded)
+	if err != nil || !removeAbsent(v, doc) {
+		return encoded
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return encoded
+	}
+	return out
+}
+
+// isAbsent reports whether v is an Optional which wasn't provided.
+func isAbsent(v reflect.Value) bool {
+	return v.Kind() == reflect.Struct && v.Type().Implements(optionalType) && !v.FieldByName("present").Bool()
+}
+
+// removeAbsent walks v alongside its decoded JSON encoding doc, deleting
+// object keys for absent Optional values. It reports whether anything was removed.
+func removeAbsent(v reflect.Value, doc interface{}) bool {
+	if !v.IsValid() || doc == nil {
+		return false
+	}
+	t := v.Type()
+
+	if t.Kind() == reflect.Struct && t.Implements(optionalType) {
+		if !v.FieldByName("null").Bool() {
+			return removeAbsent(v.FieldByName("value"), doc)
+		}
+		return false
+	}
+	if t.Implements(jsonMarshalerType) {
+		return false
+	}
+
+	removed := false
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return false
+		}
+		return removeAbsent(v.Elem(), doc)
+
+	case reflect.Struct:
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for name, f := range structFields(t) {
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok {
+				continue
+			}
+			if isAbsent(fv) {
+				_, found := obj[name]
+				removed = removed || found
+				delete(obj, name)
+				continue
+			}
+			removed = removeAbsent(fv, obj[name]) || removed
+		}
+
+	case reflect.Map:
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			var key string
+			switch iter.Key().Kind() {
+			case reflect.String:
+				key = iter.Key().String()
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				key = strconv.FormatInt(iter.Key().Int(), 10)
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+				key = strconv.FormatUint(iter.Key().Uint(), 10)
+			default:
+				continue
+			}
+			if isAbsent(iter.Value()) {
+				delete(obj, key)
+				removed = true
+				continue
+			}
+			removed = removeAbsent(iter.Value(), obj[key]) || removed
+		}
+
+	case reflect.Slice, reflect.Array:
+		arr, ok := doc.([]interface{})
+		if !ok {
+			return false
+		}
+		for i := 0; i < v.Len() && i < len(arr); i++ {
+			removed = removeAbsent(v.Index(i), arr[i]) || removed
+		}
+	}
+
+	return removed
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but returns false
+// rather than panicking if it reaches a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+var optionalCache sync.Map // map[reflect.Type]bool
+
+// hasOptional reports whether type t contains an Optional. Interface types aren't assumed
+// to, so that responses without an Optional aren't decoded and encoded again. Optional
+// values in interfaces are still omitted if the type containing the interface has an Optional.
+func hasOptional(t reflect.Type) bool {
+	if has, ok := optionalCache.Load(t); ok {
+		return has.(bool)
+	}
+	has := findOptional(t, map[reflect.Type]bool{})
+	optionalCache.Store(t, has)
+	return has
+}
+
+func findOptional(t reflect.Type, visited map[reflect.Type]bool) bool {
+	if visited[t] {
+		return false
+	}
+	visited[t] = true
+
+	if t.Kind() == reflect.Struct && t.Implements(optionalType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return findOptional(t.Elem(), visited)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if findOptional(t.Field(i).Type, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package apio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testAddress struct {
+	City string `json:"city"`
+}
+
+type testUpdateUser struct {
+	Name    Optional[string]       `json:"name"`
+	Age     Optional[int]          `json:"age"`
+	Address Optional[testAddress]  `json:"address"`
+	Tags    []Optional[string]     `json:"tags,omitempty"`
+	Extra   map[string]interface{} `json:"extra,omitempty"`
+}
+
+func TestOptionalDecode(t *testing.T) {
+	type testcase struct {
+		name     string
+		giveBody string
+		want     testUpdateUser
+		wantErr  error
+	}
+
+	testcases := []testcase{
+		{name: "absent", giveBody: `{}`, want: testUpdateUser{}},
+		{name: "null", giveBody: `{"name":null}`, want: testUpdateUser{Name: Null[string]()}},
+		{name: "set", giveBody: `{"name":"alice","address":{"city":"Sydney"}}`, want: testUpdateUser{Name: Some("alice"), Address: Some(testAddress{City: "Sydney"})}},
+		{
+			name:     "invalid wrapped value",
+			giveBody: `{"name":"alice","age":"old"}`,
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "/age", Error: "cannot use string as integer (at line 1, column 23)"}},
+			},
+		},
+		{
+			name:     "unknown field in wrapped struct",
+			giveBody: `{"address":{"city":"Sydney","country":"AU"}}`,
+			wantErr: &APIError{
+				Err:    errors.New("request body contains invalid fields"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "/address/country", Error: "unknown field (at line 1, column 29)"}},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := http.Request{
+				Body:   io.NopCloser(strings.NewReader(tc.giveBody)),
+				Header: http.Header{"Content-Type": {"application/json"}},
+			}
+
+			var got testUpdateUser
+			err := DecodeJSONBody(httptest.NewRecorder(), &r, &got)
+			assert.Equal(t, tc.wantErr, err)
+			if tc.wantErr == nil {
+				assert.Equal(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestOptionalAccessors(t *testing.T) {
+	var absent Optional[int]
+	assert.False(t, absent.IsPresent())
+	assert.Equal(t, 5, absent.ValueOr(5))
+
+	null := Null[int]()
+	assert.True(t, null.IsPresent())
+	assert.True(t, null.IsNull())
+	assert.False(t, null.IsSet())
+
+	v, ok := Some(3).Get()
+	assert.True(t, ok)
+	assert.Equal(t, 3, v)
+}
+
+func TestOptionalMarshal(t *testing.T) {
+	give := testUpdateUser{
+		Name:  Null[string](),
+		Age:   Some(30),
+		Tags:  []Optional[string]{Some("a"), {}},
+		Extra: map[string]interface{}{"nested": testUpdateUser{Age: Some(1)}},
+	}
+
+	data, err := json.Marshal(give)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.JSONEq(t, `{"name":null,"age":30,"address":null,"tags":["a",null],"extra":{"nested":{"name":null,"age":1,"address":null}}}`, string(data))
+
+	rr := httptest.NewRecorder()
+	JSON(context.Background(), rr, give, http.StatusOK)
+	assert.JSONEq(t, `{"name":null,"age":30,"tags":["a",null],"extra":{"nested":{"age":1}}}`, rr.Body.String())
+}
+
+func TestOmitAbsentSkipsTypesWithoutOptional(t *testing.T) {
+	type response struct {
+		B interface{} `json:"b"`
+		A int         `json:"a"`
+	}
+	assert.False(t, hasOptional(reflect.TypeOf(response{})))
+	assert.True(t, hasOptional(reflect.TypeOf(testUpdateUser{})))
+
+	// the response isn't decoded and encoded again, which would sort its keys.
+	rr := httptest.NewRecorder()
+	JSON(context.Background(), rr, response{A: 1}, http.StatusOK)
+	assert.Equal(t, `{"b":null,"a":1}`, rr.Body.String())
+}
@@ -0,0 +1,130 @@
+package pagination
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/common-fate/apikit/apio"
+)
+
+// minKeySize is the smallest key accepted by NewCodec, in bytes.
+const minKeySize = 16
+
+// CodecOptions customise how cursors are encoded.
+type CodecOptions struct {
+	// Encrypt encrypts cursors with AES-GCM as well as signing them, so that
+	// clients can't read the values they contain.
+	Encrypt bool
+}
+
+// Codec encodes values into opaque cursor strings and decodes them again.
+// Cursors are signed with HMAC-SHA256, so clients can't tamper with them or
+// create their own. It's safe for concurrent use.
+type Codec struct {
+	signKey []byte
+	aead    cipher.AEAD
+}
+
+// NewCodec returns a Codec which derives its signing and encryption keys from key.
+// Key must be at least 16 bytes, and should be kept secret and shared between all
+// instances of the service so that cursors can be used with any of them.
+// Options may be nil to use the defaults.
+func NewCodec(key []byte, options *CodecOptions) (*Codec, error) {
+	if len(key) < minKeySize {
+		return nil, errors.New("pagination: cursor key must be at least 16 bytes")
+	}
+	var opts CodecOptions
+	if options != nil {
+		opts = *options
+	}
+
+	c := &Codec{signKey: deriveKey(key, "sign")}
+	if opts.Encrypt {
+		block, err := aes.NewCipher(deriveKey(key, "encrypt"))
+		if err != nil {
+			return nil, err
+		}
+		c.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// deriveKey derives a 256-bit key for a particular purpose, so the same
+// key is never used for both signing and encryption.
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("apikit pagination " + purpose))
+	return mac.Sum(nil)
+}
+
+// Encode converts v to JSON and returns it as an opaque, URL-safe cursor.
+func (c *Codec) Encode(v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	if c.aead != nil {
+		nonce := make([]byte, c.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		payload = c.aead.Seal(nonce, nonce, payload, nil)
+	}
+
+	mac := hmac.New(sha256.New, c.signKey)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(payload)), nil
+}
+
+// Decode decodes a cursor created by Encode into the value pointed to by dst.
+//
+// An *apio.APIError with status 400 is returned if the cursor has been
+// modified, wasn't created with the same key, or can't be decoded into dst.
+func (c *Codec) Decode(cursor string, dst interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(data) < sha256.Size {
+		return invalidCursor()
+	}
+
+	payload, sig := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	mac := hmac.New(sha256.New, c.signKey)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return invalidCursor()
+	}
+
+	if c.aead != nil {
+		n := c.aead.NonceSize()
+		if len(payload) < n {
+			return invalidCursor()
+		}
+		payload, err = c.aead.Open(nil, payload[:n], payload[n:], nil)
+		if err != nil {
+			return invalidCursor()
+		}
+	}
+
+	if err := json.Unmarshal(payload, dst); err != nil {
+		return invalidCursor()
+	}
+	return nil
+}
+
+func invalidCursor() error {
+	return &apio.APIError{
+		Err:    errors.New("request contains invalid query parameters"),
+		Status: http.StatusBadRequest,
+		Fields: []apio.FieldError{{Field: CursorParam, Error: "is not a valid cursor"}},
+	}
+}
@@ -0,0 +1,252 @@
+// Package pagination contains helpers for paginating list endpoints consistently,
+// using either opaque cursors or offsets.
+//
+// A cursor-paginated endpoint parses the request, decodes the cursor to find where to
+// resume, and responds with a Page containing the cursors for the next and previous pages:
+//
+//	params, err := pagination.Parse(r, nil)
+//	if err != nil {
+//		apio.Error(ctx, w, err)
+//		return
+//	}
+//	var after string
+//	if params.Cursor != "" {
+//		if err := codec.Decode(params.Cursor, &after); err != nil {
+//			apio.Error(ctx, w, err)
+//			return
+//		}
+//	}
+//	users, last, err := db.ListUsers(ctx, after, params.Limit)
+//	...
+//	next, err := codec.Encode(last)
+//	...
+//	pagination.Respond(ctx, w, r, pagination.NewPage(users, next, ""))
+package pagination
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/common-fate/apikit/apio"
+)
+
+const (
+	// DefaultLimit is the page size used if Options.DefaultLimit isn't set.
+	DefaultLimit = 50
+	// DefaultMaxLimit is the largest page size accepted if Options.MaxLimit isn't set.
+	DefaultMaxLimit = 100
+)
+
+// The query parameters read by Parse and ParseOffset.
+const (
+	LimitParam  = "limit"
+	CursorParam = "cursor"
+	OffsetParam = "offset"
+)
+
+// Options customise the pagination parameters accepted by an endpoint.
+type Options struct {
+	// DefaultLimit is the page size if the limit parameter isn't provided.
+	// If zero, DefaultLimit is used.
+	DefaultLimit int
+	// MaxLimit is the largest page size accepted. If zero, DefaultMaxLimit is used.
+	MaxLimit int
+	// MaxOffset is the largest offset accepted by ParseOffset. If zero, there is no limit.
+	MaxOffset int
+}
+
+func (o *Options) withDefaults() Options {
+	var opts Options
+	if o != nil {
+		opts = *o
+	}
+	if opts.DefaultLimit == 0 {
+		opts.DefaultLimit = DefaultLimit
+	}
+	if opts.MaxLimit == 0 {
+		opts.MaxLimit = DefaultMaxLimit
+	}
+	if opts.DefaultLimit > opts.MaxLimit {
+		opts.DefaultLimit = opts.MaxLimit
+	}
+	return opts
+}
+
+// Params are the pagination parameters for a cursor-paginated request.
+type Params struct {
+	// Limit is the number of items to return.
+	Limit int
+	// Cursor is the cursor to resume from, which can be decoded with Codec.Decode.
+	// It is empty when the first page is requested.
+	Cursor string
+}
+
+// Parse reads the limit and cursor query parameters of a request.
+// Options may be nil to use the defaults.
+//
+// An *apio.APIError with status 400 is returned if the limit isn't between 1 and the maximum.
+func Parse(r *http.Request, options *Options) (Params, error) {
+	opts := options.withDefaults()
+	query := r.URL.Query()
+
+	var fieldErrs []apio.FieldError
+	limit, fe := parseLimit(query, opts)
+	fieldErrs = append(fieldErrs, fe...)
+
+	if len(query[CursorParam]) > 1 {
+		fieldErrs = append(fieldErrs, apio.FieldError{Field: CursorParam, Error: "must only be provided once"})
+	}
+
+	if err := paramsError(fieldErrs); err != nil {
+		return Params{}, err
+	}
+	return Params{Limit: limit, Cursor: query.Get(CursorParam)}, nil
+}
+
+// OffsetParams are the pagination parameters for an offset-paginated request.
+type OffsetParams struct {
+	// Limit is the number of items to return.
+	Limit int
+	// Offset is the number of items to skip.
+	Offset int
+}
+
+// ParseOffset reads the limit and offset query parameters of a request.
+// Options may be nil to use the defaults.
+//
+// An *apio.APIError with status 400 is returned if the limit isn't between 1 and the
+// maximum, or the offset is negative or larger than Options.MaxOffset.
+func ParseOffset(r *http.Request, options *Options) (OffsetParams, error) {
+	opts := options.withDefaults()
+	query := r.URL.Query()
+
+	var fieldErrs []apio.FieldError
+	limit, fe := parseLimit(query, opts)
+	fieldErrs = append(fieldErrs, fe...)
+
+	var offset int
+	if v := query.Get(OffsetParam); v != "" {
+		var err error
+		offset, err = strconv.Atoi(v)
+		switch {
+		case err != nil || offset < 0:
+			fieldErrs = append(fieldErrs, apio.FieldError{Field: OffsetParam, Error: "must be a non-negative integer"})
+		case opts.MaxOffset > 0 && offset > opts.MaxOffset:
+			fieldErrs = append(fieldErrs, apio.FieldError{Field: OffsetParam, Error: fmt.Sprintf("must not be greater than %d", opts.MaxOffset)})
+		}
+	}
+
+	if err := paramsError(fieldErrs); err != nil {
+		return OffsetParams{}, err
+	}
+	return OffsetParams{Limit: limit, Offset: offset}, nil
+}
+
+func parseLimit(query url.Values, opts Options) (int, []apio.FieldError) {
+	v := query.Get(LimitParam)
+	if v == "" {
+		return opts.DefaultLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, []apio.FieldError{{Field: LimitParam, Error: "must be an integer"}}
+	}
+	if limit < 1 || limit > opts.MaxLimit {
+		return 0, []apio.FieldError{{Field: LimitParam, Error: fmt.Sprintf("must be between 1 and %d", opts.MaxLimit)}}
+	}
+	return limit, nil
+}
+
+func paramsError(fieldErrs []apio.FieldError) error {
+	if len(fieldErrs) == 0 {
+		return nil
+	}
+	return &apio.APIError{
+		Err:    errors.New("request contains invalid query parameters"),
+		Status: http.StatusBadRequest,
+		Fields: fieldErrs,
+	}
+}
+
+// Page is the standard response body for paginated endpoints:
+//
+//	{"items": [...], "next": "...", "prev": "..."}
+//
+// Next and Prev are omitted if there is no next or previous page.
+type Page[T any] struct {
+	Items []T    `json:"items"`
+	Next  string `json:"next,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+
+	// param is the query parameter Next and Prev are passed back in.
+	param string
+}
+
+// NewPage returns a page of cursor-paginated items. Next and prev are
+// the cursors for the adjacent pages, or empty if there isn't one.
+func NewPage[T any](items []T, next, prev string) Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+	return Page[T]{Items: items, Next: next, Prev: prev, param: CursorParam}
+}
+
+// NewOffsetPage returns a page of offset-paginated items. Next and Prev are set to
+// the offsets of the adjacent pages.
+//
+// To find out whether there is a next page, fetch up to Limit+1 items. If more than
+// Limit items are provided, the extra items are removed and Next is set.
+func NewOffsetPage[T any](items []T, params OffsetParams) Page[T] {
+	p := Page[T]{Items: items, param: OffsetParam}
+	if len(items) > params.Limit {
+		p.Items = items[:params.Limit]
+		p.Next = strconv.Itoa(params.Offset + params.Limit)
+	}
+	if params.Offset > 0 {
+		prev := params.Offset - params.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		p.Prev = strconv.Itoa(prev)
+	}
+	if p.Items == nil {
+		p.Items = []T{}
+	}
+	return p
+}
+
+// Respond sends a page to the client with apio.JSON, along with an RFC 8288
+// Link header containing the URLs of the next and previous pages.
+func Respond[T any](ctx context.Context, w http.ResponseWriter, r *http.Request, page Page[T]) {
+	param := page.param
+	if param == "" {
+		param = CursorParam
+	}
+	if links := LinkHeader(r.URL, param, page.Next, page.Prev); links != "" {
+		w.Header().Set("Link", links)
+	}
+	apio.JSON(ctx, w, page, http.StatusOK)
+}
+
+// LinkHeader returns an RFC 8288 Link header value with "next" and "prev" links, which
+// are u with the param query parameter set to next and prev. Other query parameters,
+// such as filters and the limit, are kept. Empty values are left out.
+func LinkHeader(u *url.URL, param, next, prev string) string {
+	var links []string
+	for _, l := range []struct{ rel, value string }{{"next", next}, {"prev", prev}} {
+		if l.value == "" {
+			continue
+		}
+		ref := *u
+		query := ref.Query()
+		query.Set(param, l.value)
+		ref.RawQuery = query.Encode()
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, ref.String(), l.rel))
+	}
+	return strings.Join(links, ", ")
+}
@@ -0,0 +1,176 @@
+package pagination
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/common-fate/apikit/apio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	type testcase struct {
+		name       string
+		giveQuery  string
+		giveOpts   *Options
+		want       Params
+		wantFields []apio.FieldError
+	}
+
+	testcases := []testcase{
+		{name: "defaults", giveQuery: "", want: Params{Limit: DefaultLimit}},
+		{name: "limit and cursor", giveQuery: "limit=10&cursor=abc", want: Params{Limit: 10, Cursor: "abc"}},
+		{name: "custom default", giveQuery: "", giveOpts: &Options{DefaultLimit: 5}, want: Params{Limit: 5}},
+		{name: "limit too large", giveQuery: "limit=101", wantFields: []apio.FieldError{{Field: "limit", Error: "must be between 1 and 100"}}},
+		{name: "limit zero", giveQuery: "limit=0", giveOpts: &Options{MaxLimit: 20}, wantFields: []apio.FieldError{{Field: "limit", Error: "must be between 1 and 20"}}},
+		{name: "limit not a number", giveQuery: "limit=ten", wantFields: []apio.FieldError{{Field: "limit", Error: "must be an integer"}}},
+		{name: "repeated cursor", giveQuery: "cursor=a&cursor=b", wantFields: []apio.FieldError{{Field: "cursor", Error: "must only be provided once"}}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/users?"+tc.giveQuery, nil)
+			got, err := Parse(r, tc.giveOpts)
+			if tc.wantFields != nil {
+				apiErr, ok := err.(*apio.APIError)
+				if assert.True(t, ok) {
+					assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+					assert.Equal(t, tc.wantFields, apiErr.Fields)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseOffset(t *testing.T) {
+	type testcase struct {
+		name       string
+		giveQuery  string
+		giveOpts   *Options
+		want       OffsetParams
+		wantFields []apio.FieldError
+	}
+
+	testcases := []testcase{
+		{name: "defaults", giveQuery: "", want: OffsetParams{Limit: DefaultLimit}},
+		{name: "ok", giveQuery: "limit=10&offset=20", want: OffsetParams{Limit: 10, Offset: 20}},
+		{name: "negative offset", giveQuery: "offset=-1", wantFields: []apio.FieldError{{Field: "offset", Error: "must be a non-negative integer"}}},
+		{name: "offset too large", giveQuery: "offset=1001", giveOpts: &Options{MaxOffset: 1000}, wantFields: []apio.FieldError{{Field: "offset", Error: "must not be greater than 1000"}}},
+		{name: "multiple errors", giveQuery: "limit=x&offset=y", wantFields: []apio.FieldError{{Field: "limit", Error: "must be an integer"}, {Field: "offset", Error: "must be a non-negative integer"}}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/users?"+tc.giveQuery, nil)
+			got, err := ParseOffset(r, tc.giveOpts)
+			if tc.wantFields != nil {
+				apiErr, ok := err.(*apio.APIError)
+				if assert.True(t, ok) {
+					assert.Equal(t, tc.wantFields, apiErr.Fields)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestCodec(t *testing.T) {
+	type cursor struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	for _, encrypt := range []bool{false, true} {
+		c, err := NewCodec(key, &CodecOptions{Encrypt: encrypt})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := c.Encode(cursor{ID: "usr_1", Name: "alice"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, url.QueryEscape(token), token, "cursors should be URL-safe")
+
+		var got cursor
+		assert.NoError(t, c.Decode(token, &got))
+		assert.Equal(t, cursor{ID: "usr_1", Name: "alice"}, got)
+
+		// changing any character invalidates the cursor.
+		tampered := []byte(token)
+		tampered[len(tampered)/2] ^= 1
+		assert.Error(t, c.Decode(string(tampered), &got))
+
+		// cursors from another key are rejected.
+		other, _ := NewCodec([]byte("fedcba9876543210fedcba9876543210"), &CodecOptions{Encrypt: encrypt})
+		err = other.Decode(token, &got)
+		if assert.IsType(t, &apio.APIError{}, err) {
+			assert.Equal(t, []apio.FieldError{{Field: "cursor", Error: "is not a valid cursor"}}, err.(*apio.APIError).Fields)
+		}
+	}
+
+	_, err := NewCodec([]byte("short"), nil)
+	assert.Error(t, err)
+}
+
+func TestEncryptedCursorIsOpaque(t *testing.T) {
+	c, _ := NewCodec([]byte("0123456789abcdef"), &CodecOptions{Encrypt: true})
+	token, _ := c.Encode("secret-value")
+
+	plain, _ := NewCodec([]byte("0123456789abcdef"), nil)
+	plainToken, _ := plain.Encode("secret-value")
+
+	assert.NotContains(t, token, plainToken[:10])
+}
+
+func TestNewOffsetPage(t *testing.T) {
+	type testcase struct {
+		name       string
+		giveItems  []int
+		giveParams OffsetParams
+		want       Page[int]
+	}
+
+	testcases := []testcase{
+		{name: "first page with more", giveItems: []int{1, 2, 3}, giveParams: OffsetParams{Limit: 2}, want: Page[int]{Items: []int{1, 2}, Next: "2", param: OffsetParam}},
+		{name: "middle page", giveItems: []int{3, 4, 5}, giveParams: OffsetParams{Limit: 2, Offset: 2}, want: Page[int]{Items: []int{3, 4}, Next: "4", Prev: "0", param: OffsetParam}},
+		{name: "last page", giveItems: []int{5}, giveParams: OffsetParams{Limit: 2, Offset: 4}, want: Page[int]{Items: []int{5}, Prev: "2", param: OffsetParam}},
+		{name: "empty", giveItems: nil, giveParams: OffsetParams{Limit: 2}, want: Page[int]{Items: []int{}, param: OffsetParam}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, NewOffsetPage(tc.giveItems, tc.giveParams))
+		})
+	}
+}
+
+func TestRespond(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/users?limit=2&status=active&cursor=old", nil)
+	rr := httptest.NewRecorder()
+
+	Respond(context.Background(), rr, r, NewPage([]string{"a", "b"}, "n1", "p1"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"items":["a","b"],"next":"n1","prev":"p1"}`, rr.Body.String())
+	assert.Equal(t, `</users?cursor=n1&limit=2&status=active>; rel="next", </users?cursor=p1&limit=2&status=active>; rel="prev"`, rr.Header().Get("Link"))
+}
+
+func TestRespondLastPage(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	rr := httptest.NewRecorder()
+
+	Respond(context.Background(), rr, r, NewPage[string](nil, "", ""))
+
+	assert.JSONEq(t, `{"items":[]}`, rr.Body.String())
+	assert.Empty(t, rr.Header().Get("Link"))
+}
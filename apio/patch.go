@@ -0,0 +1,538 @@
+package apio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// MergePatch is a JSON Merge Patch document, as described in RFC 7396.
+//
+// Unlike decoding into a plain struct, a merge patch distinguishes between
+// a field being omitted (left unchanged) and being set to null (removed).
+type MergePatch json.RawMessage
+
+// DecodeMergePatch reads an application/merge-patch+json request body.
+func DecodeMergePatch(w http.ResponseWriter, r *http.Request) (MergePatch, error) {
+	data, err := readBody(w, r, "application/merge-patch+json")
+	if err != nil {
+		return nil, err
+	}
+
+	var raw json.RawMessage
+	if err := decodeJSON(data, &raw, nil); err != nil {
+		return nil, err
+	}
+	return MergePatch(raw), nil
+}
+
+// Apply applies the patch to a JSON document and returns the patched document.
+func (p MergePatch) Apply(doc []byte) ([]byte, error) {
+	target, err := unmarshalDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := unmarshalDocument(p)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergePatch(target, patch))
+}
+
+// ApplyTo applies the patch to the Go value pointed to by dst. The value is converted
+// to JSON, patched and decoded back using the same rules as DecodeJSONBody. Fields which
+// aren't encoded to JSON, such as unexported fields and fields tagged `json:"-"`, are kept.
+//
+// If the patched document doesn't fit dst, an *APIError with status 422 is returned.
+func (p MergePatch) ApplyTo(dst interface{}) error {
+	return applyTo(dst, p.Apply)
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// PatchOperation is a single operation in a JSON Patch document.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch is a JSON Patch document, as described in RFC 6902.
+type JSONPatch []PatchOperation
+
+// DecodeJSONPatch reads and validates an application/json-patch+json request body.
+// Invalid operations are reported as an *APIError with status 400,
+// with a FieldError pointing to the invalid member, such as /0/op.
+func DecodeJSONPatch(w http.ResponseWriter, r *http.Request) (JSONPatch, error) {
+	data, err := readBody(w, r, "application/json-patch+json")
+	if err != nil {
+		return nil, err
+	}
+
+	// RFC 6902 requires unknown members to be ignored, so we decode into
+	// maps rather than structs to avoid the unknown field checks.
+	var raw []map[string]json.RawMessage
+	if err := decodeJSON(data, &raw, nil); err != nil {
+		return nil, err
+	}
+
+	var fieldErrs []FieldError
+	addErr := func(i int, member, msg string) {
+		fieldErrs = append(fieldErrs, FieldError{Field: fmt.Sprintf("/%d/%s", i, member), Error: msg})
+	}
+	patch := make(JSONPatch, len(raw))
+
+	for i, obj := range raw {
+		op := PatchOperation{Value: obj["value"]}
+
+		for _, m := range []struct {
+			name string
+			dst  *string
+		}{{"op", &op.Op}, {"path", &op.Path}, {"from", &op.From}} {
+			v, ok := obj[m.name]
+			if !ok {
+				continue
+			}
+			if err := json.Unmarshal(v, m.dst); err != nil {
+				addErr(i, m.name, "must be a string")
+			}
+		}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				addErr(i, "value", "is required")
+			}
+		case "move", "copy":
+			if _, ok := obj["from"]; !ok {
+				addErr(i, "from", "is required")
+			} else if _, err := parseJSONPointer(op.From); err != nil {
+				addErr(i, "from", err.Error())
+			}
+		case "remove":
+		default:
+			addErr(i, "op", "must be one of: add, remove, replace, move, copy, test")
+		}
+
+		if _, ok := obj["path"]; !ok {
+			addErr(i, "path", "is required")
+		} else if _, err := parseJSONPointer(op.Path); err != nil {
+			addErr(i, "path", err.Error())
+		}
+
+		patch[i] = op
+	}
+
+	if len(fieldErrs) > 0 {
+		return nil, &APIError{
+			Err:    errors.New("request body contains an invalid JSON Patch"),
+			Status: http.StatusBadRequest,
+			Fields: fieldErrs,
+		}
+	}
+	return patch, nil
+}
+
+// errPatchTestFailed is returned when a test operation doesn't match the document.
+var errPatchTestFailed = errors.New("value does not match")
+
+// Apply applies the patch to a JSON document and returns the patched document.
+// Patches are atomic: if any operation fails the document is left unchanged.
+//
+// If a test operation fails, an *APIError with status 409 is returned. If an operation
+// can't be applied, such as removing a path which doesn't exist, an *APIError with status 422
+// is returned. In both cases the Fields contain the path of the failing operation.
+func (p JSONPatch) Apply(doc []byte) ([]byte, error) {
+	d, err := unmarshalDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range p {
+		d, err = applyOperation(d, op)
+		if err != nil {
+			status := http.StatusUnprocessableEntity
+			if err == errPatchTestFailed {
+				status = http.StatusConflict
+			}
+			return nil, &APIError{
+				Err:    fmt.Errorf("JSON Patch operation %d (%s) failed", i, op.Op),
+				Status: status,
+				Fields: []FieldError{{Field: op.Path, Error: err.Error()}},
+			}
+		}
+	}
+
+	return json.Marshal(d)
+}
+
+// ApplyTo applies the patch to the Go value pointed to by dst. The value is converted
+// to JSON, patched and decoded back using the same rules as DecodeJSONBody. Fields which
+// aren't encoded to JSON, such as unexported fields and fields tagged `json:"-"`, are kept.
+//
+// If the patched document doesn't fit dst, an *APIError with status 422 is returned.
+func (p JSONPatch) ApplyTo(dst interface{}) error {
+	return applyTo(dst, p.Apply)
+}
+
+func applyOperation(doc interface{}, op PatchOperation) (interface{}, error) {
+	path, err := parseJSONPointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		value, err := unmarshalDocument(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add":
+			return pointerAdd(doc, path, value)
+		case "replace":
+			if _, err := pointerGet(doc, path); err != nil {
+				return nil, err
+			}
+			if doc, _, err = pointerRemove(doc, path); err != nil {
+				return nil, err
+			}
+			return pointerAdd(doc, path, value)
+		default:
+			got, err := pointerGet(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !jsonEqual(got, value) {
+				return nil, errPatchTestFailed
+			}
+			return doc, nil
+		}
+
+	case "remove":
+		doc, _, err := pointerRemove(doc, path)
+		return doc, err
+
+	case "move", "copy":
+		from, err := parseJSONPointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			value, err := pointerGet(doc, from)
+			if err != nil {
+				return nil, fmt.Errorf("from %s", err)
+			}
+			return pointerAdd(doc, path, deepCopy(value))
+		}
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, errors.New("cannot move a value into one of its children")
+		}
+		doc, value, err := pointerRemove(doc, from)
+		if err != nil {
+			return nil, fmt.Errorf("from %s", err)
+		}
+		return pointerAdd(doc, path, value)
+	}
+
+	return nil, fmt.Errorf("unknown operation %q", op.Op)
+}
+
+// errPathNotFound is returned when a JSON Pointer doesn't refer to a value in the document.
+var errPathNotFound = errors.New("path does not exist")
+
+func pointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, tok := range path {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			v, ok := d[tok]
+			if !ok {
+				return nil, errPathNotFound
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(tok, len(d)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = d[i]
+		default:
+			return nil, errPathNotFound
+		}
+	}
+	return doc, nil
+}
+
+// updateParent finds the container holding the last token in path and replaces it
+// with the result of fn, returning the updated document.
+func updateParent(doc interface{}, path []string, fn func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		child, ok := d[path[0]]
+		if !ok {
+			return nil, errPathNotFound
+		}
+		updated, err := updateParent(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		d[path[0]] = updated
+		return d, nil
+	case []interface{}:
+		i, err := arrayIndex(path[0], len(d)-1)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := updateParent(d[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		d[i] = updated
+		return d, nil
+	default:
+		return nil, errPathNotFound
+	}
+}
+
+func pointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updateParent(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			p[key] = value
+			return p, nil
+		case []interface{}:
+			if key == "-" {
+				return append(p, value), nil
+			}
+			i, err := arrayIndex(key, len(p))
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = value
+			return p, nil
+		default:
+			return nil, errPathNotFound
+		}
+	})
+}
+
+func pointerRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+	var removed interface{}
+	doc, err := updateParent(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			v, ok := p[key]
+			if !ok {
+				return nil, errPathNotFound
+			}
+			removed = v
+			delete(p, key)
+			return p, nil
+		case []interface{}:
+			i, err := arrayIndex(key, len(p)-1)
+			if err != nil {
+				return nil, err
+			}
+			removed = p[i]
+			return append(p[:i], p[i+1:]...), nil
+		default:
+			return nil, errPathNotFound
+		}
+	})
+	return doc, removed, err
+}
+
+// arrayIndex parses an array index from a JSON Pointer token, which must be at most max.
+func arrayIndex(tok string, max int) (int, error) {
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') || strings.TrimLeft(tok, "0123456789") != "" {
+		return 0, errPathNotFound
+	}
+	i, err := strconv.Atoi(tok)
+	if err != nil || i > max {
+		return 0, errPathNotFound
+	}
+	return i, nil
+}
+
+// jsonEqual compares two decoded JSON values. Numbers are compared by value,
+// so 1 and 1.0 are equal.
+func jsonEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		ar, aok := new(big.Rat).SetString(av.String())
+		br, bok := new(big.Rat).SetString(bv.String())
+		return aok && bok && ar.Cmp(br) == 0
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			other, ok := bv[k]
+			if !ok || !jsonEqual(v, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
+
+func deepCopy(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[k] = deepCopy(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, v := range t {
+			s[i] = deepCopy(v)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+// unmarshalDocument decodes a JSON document, keeping numbers as json.Number so that
+// they aren't changed by the round trip through float64.
+func unmarshalDocument(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// applyTo marshals dst to JSON, applies the patch and decodes the result into a
+// copy of dst, which replaces dst if the patch succeeds.
+//
+// Before decoding, the fields of the copy which are encoded to JSON are reset to their zero
+// value, so that fields removed by the patch are cleared, and maps and slices only contain
+// the entries in the patched document. Fields which aren't encoded to JSON, such as
+// unexported fields and fields tagged `json:"-"`, keep their values.
+func applyTo(dst interface{}, apply func(doc []byte) ([]byte, error)) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("apio: patch destination must be a non-nil pointer, got %T", dst)
+	}
+
+	doc, err := json.Marshal(dst)
+	if err != nil {
+		return err
+	}
+
+	patched, err := apply(doc)
+	if err != nil {
+		return err
+	}
+
+	result := reflect.New(v.Elem().Type())
+	result.Elem().Set(v.Elem())
+	resetJSONFields(result.Elem())
+
+	err = decodeJSON(patched, result.Interface(), nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return &APIError{
+			Err:    errors.New("patch results in an invalid document"),
+			Status: http.StatusUnprocessableEntity,
+			Fields: apiErr.Fields,
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	v.Elem().Set(result.Elem())
+	return nil
+}
+
+// resetJSONFields sets the fields of v which are encoded to JSON to their zero value.
+// Structs which are decoded field by field are reset recursively, so that their fields
+// which aren't encoded keep their values. Other values are reset entirely.
+func resetJSONFields(v reflect.Value) {
+	if !decodedByField(v.Type()) {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Tag.Get("json") == "-" {
+			continue
+		}
+		// unexported fields aren't encoded, apart from the exported fields of embedded structs.
+		if sf.PkgPath != "" && !(sf.Anonymous && sf.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		resetJSONFields(v.Field(i))
+	}
+}
+
+// decodedByField reports whether encoding/json decodes values of type t field by field,
+// rather than with an unmarshaler.
+func decodedByField(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	p := reflect.PtrTo(t)
+	return !p.Implements(jsonUnmarshalerType) && !p.Implements(textUnmarshalerType)
+}
@@ -0,0 +1,183 @@
+package apio
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergePatchApply(t *testing.T) {
+	type testcase struct {
+		name      string
+		giveDoc   string
+		givePatch string
+		want      string
+	}
+
+	// examples from RFC 7396 appendix A.
+	testcases := []testcase{
+		{name: "replace", giveDoc: `{"a":"b"}`, givePatch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{name: "add", giveDoc: `{"a":"b"}`, givePatch: `{"b":"c"}`, want: `{"a":"b","b":"c"}`},
+		{name: "remove", giveDoc: `{"a":"b","b":"c"}`, givePatch: `{"a":null}`, want: `{"b":"c"}`},
+		{name: "nested", giveDoc: `{"a":{"b":"c"}}`, givePatch: `{"a":{"b":"d","c":null}}`, want: `{"a":{"b":"d"}}`},
+		{name: "array", giveDoc: `{"a":[{"b":"c"}]}`, givePatch: `{"a":[1]}`, want: `{"a":[1]}`},
+		{name: "non-object target", giveDoc: `{"a":"foo"}`, givePatch: `{"a":{"bb":{"ccc":null}}}`, want: `{"a":{"bb":{}}}`},
+		{name: "big numbers are preserved", giveDoc: `{"a":12345678901234567890}`, givePatch: `{"b":1}`, want: `{"a":12345678901234567890,"b":1}`},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := MergePatch(tc.givePatch).Apply([]byte(tc.giveDoc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.JSONEq(t, tc.want, string(got))
+		})
+	}
+}
+
+func TestJSONPatchApply(t *testing.T) {
+	type testcase struct {
+		name      string
+		giveDoc   string
+		givePatch string
+		want      string
+		wantErr   error
+	}
+
+	testcases := []testcase{
+		{name: "add", giveDoc: `{"foo":["bar","baz"]}`, givePatch: `[{"op":"add","path":"/foo/1","value":"qux"}]`, want: `{"foo":["bar","qux","baz"]}`},
+		{name: "append", giveDoc: `{"foo":[1]}`, givePatch: `[{"op":"add","path":"/foo/-","value":2}]`, want: `{"foo":[1,2]}`},
+		{name: "remove", giveDoc: `{"baz":"qux","foo":"bar"}`, givePatch: `[{"op":"remove","path":"/baz"}]`, want: `{"foo":"bar"}`},
+		{name: "replace", giveDoc: `{"baz":"qux"}`, givePatch: `[{"op":"replace","path":"/baz","value":"boo"}]`, want: `{"baz":"boo"}`},
+		{name: "move", giveDoc: `{"foo":{"bar":"baz"},"qux":{}}`, givePatch: `[{"op":"move","from":"/foo/bar","path":"/qux/thud"}]`, want: `{"foo":{},"qux":{"thud":"baz"}}`},
+		{name: "copy", giveDoc: `{"a":{"b":1}}`, givePatch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, want: `{"a":{"b":1},"c":{"b":2}}`},
+		{name: "test numbers", giveDoc: `{"a":1}`, givePatch: `[{"op":"test","path":"/a","value":1.0}]`, want: `{"a":1}`},
+		{name: "escaped path", giveDoc: `{"a/b":1}`, givePatch: `[{"op":"remove","path":"/a~1b"}]`, want: `{}`},
+		{
+			name:      "test fails",
+			giveDoc:   `{"a":1}`,
+			givePatch: `[{"op":"replace","path":"/a","value":3},{"op":"test","path":"/a","value":2}]`,
+			wantErr: &APIError{
+				Err:    errors.New("JSON Patch operation 1 (test) failed"),
+				Status: http.StatusConflict,
+				Fields: []FieldError{{Field: "/a", Error: "value does not match"}},
+			},
+		},
+		{
+			name:      "missing path",
+			giveDoc:   `{"a":[1]}`,
+			givePatch: `[{"op":"remove","path":"/a/1"}]`,
+			wantErr: &APIError{
+				Err:    errors.New("JSON Patch operation 0 (remove) failed"),
+				Status: http.StatusUnprocessableEntity,
+				Fields: []FieldError{{Field: "/a/1", Error: "path does not exist"}},
+			},
+		},
+		{
+			name:      "move into child",
+			giveDoc:   `{"a":{"b":1}}`,
+			givePatch: `[{"op":"move","from":"/a","path":"/a/c"}]`,
+			wantErr: &APIError{
+				Err:    errors.New("JSON Patch operation 0 (move) failed"),
+				Status: http.StatusUnprocessableEntity,
+				Fields: []FieldError{{Field: "/a/c", Error: "cannot move a value into one of its children"}},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(tc.givePatch))
+			r.Header.Set("Content-Type", "application/json-patch+json")
+			patch, err := DecodeJSONPatch(httptest.NewRecorder(), r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := patch.Apply([]byte(tc.giveDoc))
+			assert.Equal(t, tc.wantErr, err)
+			if tc.wantErr == nil {
+				assert.JSONEq(t, tc.want, string(got))
+			}
+		})
+	}
+}
+
+func TestDecodeJSONPatchInvalid(t *testing.T) {
+	body := `[{"op":"add","path":"/a"},{"op":"delete","path":"a"},{"op":"move","path":"/b","ignored":true}]`
+	r := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json-patch+json")
+
+	_, err := DecodeJSONPatch(httptest.NewRecorder(), r)
+	want := &APIError{
+		Err:    errors.New("request body contains an invalid JSON Patch"),
+		Status: http.StatusBadRequest,
+		Fields: []FieldError{
+			{Field: "/0/value", Error: "is required"},
+			{Field: "/1/op", Error: "must be one of: add, remove, replace, move, copy, test"},
+			{Field: "/1/path", Error: "must be a JSON Pointer starting with /"},
+			{Field: "/2/from", Error: "is required"},
+		},
+	}
+	assert.Equal(t, want, err)
+}
+
+func TestPatchApplyTo(t *testing.T) {
+	type user struct {
+		Name  string  `json:"name"`
+		Email *string `json:"email"`
+		Age   int     `json:"age"`
+	}
+	email := "alice@example.com"
+
+	u := user{Name: "alice", Email: &email, Age: 30}
+	err := MergePatch(`{"email":null,"age":31}`).ApplyTo(&u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user{Name: "alice", Age: 31}, u)
+
+	patch := JSONPatch{{Op: "replace", Path: "/age", Value: []byte(`"old"`)}}
+	err = patch.ApplyTo(&u)
+	want := &APIError{
+		Err:    errors.New("patch results in an invalid document"),
+		Status: http.StatusUnprocessableEntity,
+		Fields: []FieldError{{Field: "/age", Error: "cannot use string as integer (at line 1, column 8)"}},
+	}
+	assert.Equal(t, want, err)
+	assert.Equal(t, user{Name: "alice", Age: 31}, u)
+}
+
+func TestPatchApplyToKeepsHiddenFields(t *testing.T) {
+	type address struct {
+		City     string `json:"city"`
+		Verified bool   `json:"-"`
+	}
+	type doc struct {
+		Name    string            `json:"name"`
+		Secret  string            `json:"-"`
+		Tags    map[string]string `json:"tags"`
+		Address address           `json:"address"`
+		hidden  int
+	}
+
+	d := doc{Name: "a", Secret: "s3cret", Tags: map[string]string{"a": "1", "b": "2"}, Address: address{City: "Sydney", Verified: true}, hidden: 7}
+	err := MergePatch(`{"name":"b","tags":{"b":null},"address":null}`).ApplyTo(&d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, doc{Name: "b", Secret: "s3cret", Tags: map[string]string{"a": "1"}, Address: address{Verified: true}, hidden: 7}, d)
+}
+
+func TestDecodeMergePatch(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(`{"a":null}`))
+	r.Header.Set("Content-Type", "application/json")
+
+	_, err := DecodeMergePatch(httptest.NewRecorder(), r)
+	assert.Equal(t, &APIError{Err: errors.New("Content-Type header is not application/merge-patch+json"), Status: http.StatusUnsupportedMediaType}, err)
+}
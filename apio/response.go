@@ -3,7 +3,9 @@ package apio
 import (
 	"context"
 	"encoding/json"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/common-fate/apikit/errhandler"
 	"github.com/common-fate/apikit/logger"
@@ -30,6 +32,9 @@ func JSON(ctx context.Context, w http.ResponseWriter, data interface{}, statusCo
 		log.Errorw("marshalling JSON", zap.Error(err))
 	}
 
+	// Optional values which weren't provided are left out of the response.
+	jsonData = omitAbsent(data, jsonData)
+
 	// Set the content type and headers once we know marshaling has succeeded.
 	w.Header().Set("Content-Type", "application/json")
 
@@ -53,19 +58,47 @@ func JSON(ctx context.Context, w http.ResponseWriter, data interface{}, statusCo
 //
 //	{"error": "msg"}
 //
+// Observers registered with errhandler.Observe are notified of the error before the
+// response is sent. The logger, requestid, tracing and metrics packages register observers,
+// so that the request ID is included in the response as "requestId", the error is recorded
+// on the request's tracing span, and the error is counted and logged by its kind.
+//
 // If errhandler.Handler is set in the context, it will always be called with the error.
 // You can check the error type in your error handler to determine the status code of the error.
 func Error(ctx context.Context, w http.ResponseWriter, err error) {
+	reportError(ctx, err)
+
+	// let rate limited clients know when to retry.
+	if tmr, ok := errors.Cause(err).(serr.TooManyRequestsError); ok && tmr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(tmr.RetryAfter.Seconds()))))
+	}
+
+	er, status := errorResponse(err)
+
+	e := errhandler.Event{Err: err, Status: status, Kind: statusKind(status)}
+	errhandler.Notify(ctx, &e)
+
+	er.RequestID = e.RequestID
+	JSON(ctx, w, er, status)
+}
+
+// reportError logs the error and dispatches it to the error handler, if there is one in the context.
+func reportError(ctx context.Context, err error) {
 	// load the zap logger from context.
 	log := logger.Get(ctx)
 
 	// dispatch an error if we have an error handler we can send it to.
-	if h := errhandler.Get(ctx); h != nil {
+	if h, ok := errhandler.Get(ctx).(errhandler.ContextHandler); ok {
+		h.HandleErrorContext(ctx, err)
+	} else if h := errhandler.Get(ctx); h != nil {
 		h.HandleError(err)
 	}
 
 	log.Errorw("web handler error", zap.Error(err))
+}
 
+// errorResponse returns the response body and status code to send to the client for an error.
+func errorResponse(err error) (ErrorResponse, int) {
 	// If the error was of the type *Error, the handler has
 	// a specific status code and error to return.
 	if webErr, ok := errors.Cause(err).(*APIError); ok {
@@ -73,8 +106,7 @@ func Error(ctx context.Context, w http.ResponseWriter, err error) {
 			Error:  webErr.Err.Error(),
 			Fields: webErr.Fields,
 		}
-		JSON(ctx, w, er, webErr.Status)
-		return
+		return er, webErr.Status
 	}
 
 	// If the error was of the type *Error, the handler has
@@ -83,8 +115,7 @@ func Error(ctx context.Context, w http.ResponseWriter, err error) {
 		er := ErrorResponse{
 			Error: err.Error(),
 		}
-		JSON(ctx, w, er, http.StatusBadRequest)
-		return
+		return er, http.StatusBadRequest
 	}
 
 	// If the error was of the type *Error, the handler has
@@ -93,8 +124,7 @@ func Error(ctx context.Context, w http.ResponseWriter, err error) {
 		er := ErrorResponse{
 			Error: err.Error(),
 		}
-		JSON(ctx, w, er, http.StatusForbidden)
-		return
+		return er, http.StatusForbidden
 	}
 
 	// If the error was of the type *Error, the handler has
@@ -103,8 +133,7 @@ func Error(ctx context.Context, w http.ResponseWriter, err error) {
 		er := ErrorResponse{
 			Error: err.Error(),
 		}
-		JSON(ctx, w, er, http.StatusNotFound)
-		return
+		return er, http.StatusNotFound
 	}
 
 	// If the error was of the type *Error, the handler has
@@ -113,15 +142,23 @@ func Error(ctx context.Context, w http.ResponseWriter, err error) {
 		er := ErrorResponse{
 			Error: err.Error(),
 		}
-		JSON(ctx, w, er, http.StatusUnauthorized)
-		return
+		return er, http.StatusUnauthorized
+	}
+
+	// If the error was of the type *Error, the handler has
+	// a specific status code and error to return.
+	if serr.IsTooManyRequests(err) {
+		er := ErrorResponse{
+			Error: err.Error(),
+		}
+		return er, http.StatusTooManyRequests
 	}
 
 	// If not, the handler sent any arbitrary error value so use 500.
 	er := ErrorResponse{
 		Error: http.StatusText(http.StatusInternalServerError),
 	}
-	JSON(ctx, w, er, http.StatusInternalServerError)
+	return er, http.StatusInternalServerError
 }
 
 // ErrorString sends an error response designated status code and error message.
@@ -2,12 +2,20 @@ package apio
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/common-fate/apikit/errhandler"
+	"github.com/common-fate/apikit/logger"
+	"github.com/common-fate/apikit/requestid"
+	"github.com/common-fate/apikit/serr"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestErrorString(t *testing.T) {
@@ -43,3 +51,55 @@ func TestErrorString(t *testing.T) {
 		})
 	}
 }
+
+func TestErrorTooManyRequests(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	Error(context.Background(), rr, serr.TooManyRequests(1500*time.Millisecond))
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+	assert.Equal(t, `{"error":"Too Many Requests"}`, rr.Body.String())
+}
+
+type contextHandler struct {
+	requestID string
+}
+
+func (h *contextHandler) HandleError(err error) {}
+
+func (h *contextHandler) HandleErrorContext(ctx context.Context, err error) {
+	h.requestID = requestid.Get(ctx)
+}
+
+func TestErrorRequestID(t *testing.T) {
+	h := &contextHandler{}
+	ctx := requestid.Set(context.Background(), "req_123")
+	ctx = errhandler.Set(ctx, h)
+	rr := httptest.NewRecorder()
+
+	Error(ctx, rr, errors.New("something went wrong"))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, `{"error":"Internal Server Error","requestId":"req_123"}`, rr.Body.String())
+	assert.Equal(t, "req_123", h.requestID)
+}
+
+func TestErrorKind(t *testing.T) {
+	assert.Equal(t, "not_found", ErrorKind(serr.NotFound()))
+	assert.Equal(t, "conflict", ErrorKind(NewRequestError(errors.New("conflict"), http.StatusConflict)))
+	assert.Equal(t, "client_error", ErrorKind(NewRequestError(errors.New("teapot"), http.StatusTeapot)))
+	assert.Equal(t, "server_error", ErrorKind(NewRequestError(errors.New("bad gateway"), http.StatusBadGateway)))
+	assert.Equal(t, "internal", ErrorKind(errors.New("database unavailable")))
+}
+
+func TestErrorKindLogged(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	h := logger.Middleware(zap.New(core))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Error(r.Context(), w, serr.Forbidden())
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	served := logs.FilterMessage("Served").All()
+	assert.Contains(t, served[0].Context, zap.String("errorKind", "forbidden"))
+}
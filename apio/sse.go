@@ -0,0 +1,286 @@
+package apio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeartbeatInterval is how often a heartbeat comment is sent on an
+// idle event stream if SSEOptions.HeartbeatInterval isn't set.
+const DefaultHeartbeatInterval = 15 * time.Second
+
+// Event is a server-sent event.
+type Event struct {
+	// ID is sent to the client as the event ID. Clients send the ID of the last
+	// event they received in the Last-Event-ID header when reconnecting.
+	ID string
+	// Event is the event type. If empty, clients treat it as a "message" event.
+	Event string
+	// Data is encoded as JSON.
+	Data interface{}
+	// Retry tells the client how long to wait before reconnecting, if set.
+	Retry time.Duration
+}
+
+// ReplayBuffer provides events which a client missed while disconnected,
+// so that event streams can be resumed using the Last-Event-ID header.
+type ReplayBuffer interface {
+	// Since returns the events after the event with the given ID, in order.
+	Since(ctx context.Context, lastEventID string) ([]Event, error)
+}
+
+// MemoryReplayBuffer is a ReplayBuffer which holds the most recent events in memory.
+// It's safe for concurrent use.
+type MemoryReplayBuffer struct {
+	mu     sync.Mutex
+	size   int
+	events []Event
+}
+
+// NewMemoryReplayBuffer returns a MemoryReplayBuffer which retains up to size events.
+func NewMemoryReplayBuffer(size int) *MemoryReplayBuffer {
+	return &MemoryReplayBuffer{size: size}
+}
+
+// Add stores an event so it can be replayed. Events without an ID can't be
+// resumed from, but are still replayed to clients resuming from an earlier event.
+func (b *MemoryReplayBuffer) Add(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.events = append(b.events, e)
+	if len(b.events) > b.size {
+		b.events = append([]Event{}, b.events[len(b.events)-b.size:]...)
+	}
+}
+
+// Since returns the events after the event with the given ID. If the event
+// is no longer retained, all of the retained events are returned.
+func (b *MemoryReplayBuffer) Since(ctx context.Context, lastEventID string) ([]Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := len(b.events) - 1; i >= 0; i-- {
+		if b.events[i].ID == lastEventID {
+			return append([]Event{}, b.events[i+1:]...), nil
+		}
+	}
+	return append([]Event{}, b.events...), nil
+}
+
+// SSEOptions customise a server-sent event stream.
+type SSEOptions struct {
+	// HeartbeatInterval is how often a comment is sent to keep idle connections open.
+	// If zero, DefaultHeartbeatInterval is used. If negative, no heartbeats are sent.
+	HeartbeatInterval time.Duration
+	// Retry is sent to the client when the stream starts, to set its reconnection delay.
+	Retry time.Duration
+	// Replay is used to send missed events to clients which reconnect with a Last-Event-ID header.
+	Replay ReplayBuffer
+}
+
+// SSEWriter writes server-sent events (text/event-stream) to a client.
+//
+// It writes through the http.ResponseWriter it was created with, so logger.Middleware
+// records the status and number of bytes sent once the handler returns.
+type SSEWriter struct {
+	ctx context.Context
+	w   http.ResponseWriter
+	f   http.Flusher
+
+	mu     sync.Mutex
+	closed bool
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewSSEWriter starts a server-sent event stream. It sends the response headers, and
+// any events the client missed according to its Last-Event-ID header and SSEOptions.Replay.
+// Options may be nil to use the defaults.
+//
+// Close must be called before the handler returns. The stream stops when the
+// client disconnects, which can be waited for using Done:
+//
+//	sse, err := apio.NewSSEWriter(w, r, nil)
+//	if err != nil {
+//		apio.Error(ctx, w, err)
+//		return
+//	}
+//	defer sse.Close()
+//
+//	for {
+//		select {
+//		case p := <-progress:
+//			if err := sse.Send(apio.Event{Event: "progress", Data: p}); err != nil {
+//				return
+//			}
+//		case <-sse.Done():
+//			return
+//		}
+//	}
+func NewSSEWriter(w http.ResponseWriter, r *http.Request, options *SSEOptions) (*SSEWriter, error) {
+	var opts SSEOptions
+	if options != nil {
+		opts = *options
+	}
+	if opts.HeartbeatInterval == 0 {
+		opts.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("apio: response writer does not support flushing")
+	}
+
+	var replay []Event
+	if id := r.Header.Get("Last-Event-ID"); id != "" && opts.Replay != nil {
+		events, err := opts.Replay.Since(r.Context(), id)
+		if err != nil {
+			return nil, err
+		}
+		replay = events
+	}
+
+	s := &SSEWriter{
+		ctx:  r.Context(),
+		w:    w,
+		f:    f,
+		stop: make(chan struct{}),
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// disable response buffering in nginx.
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if opts.Retry > 0 {
+		if err := s.write([]byte(fmt.Sprintf("retry: %d\n\n", opts.Retry.Milliseconds()))); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range replay {
+		if err := s.Send(e); err != nil {
+			return nil, err
+		}
+	}
+	if len(replay) == 0 && opts.Retry == 0 {
+		// make sure the client sees the headers straight away.
+		s.f.Flush()
+	}
+
+	if opts.HeartbeatInterval > 0 {
+		s.wg.Add(1)
+		go s.heartbeat(opts.HeartbeatInterval)
+	}
+
+	return s, nil
+}
+
+// Send writes an event to the client. It returns the context error if
+// the client has disconnected.
+func (s *SSEWriter) Send(e Event) error {
+	data, err := encodeEvent(e)
+	if err != nil {
+		return err
+	}
+	return s.write(data)
+}
+
+// Done returns a channel which is closed when the client disconnects.
+func (s *SSEWriter) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Close stops sending heartbeats. No more events can be sent after Close is called.
+func (s *SSEWriter) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.stop)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *SSEWriter) heartbeat(interval time.Duration) {
+	defer s.wg.Done()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := s.write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+		case <-s.stop:
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *SSEWriter) write(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	if s.closed {
+		return errors.New("apio: event stream is closed")
+	}
+	if _, err := s.w.Write(data); err != nil {
+		return err
+	}
+	s.f.Flush()
+	return nil
+}
+
+// encodeEvent encodes an event in the text/event-stream format.
+func encodeEvent(e Event) ([]byte, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return nil, errors.New("apio: event ID must not contain newlines or NULL characters")
+	}
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return nil, errors.New("apio: event type must not contain newlines")
+	}
+
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return nil, err
+	}
+	data = omitAbsent(e.Data, data)
+
+	var b bytes.Buffer
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	// encoding/json never produces raw newlines, so the data fits on a single line.
+	b.WriteString("data: ")
+	b.Write(data)
+	b.WriteString("\n\n")
+	return b.Bytes(), nil
+}
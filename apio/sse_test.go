@@ -0,0 +1,141 @@
+package apio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/common-fate/apikit/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestEncodeEvent(t *testing.T) {
+	type testcase struct {
+		name    string
+		give    Event
+		want    string
+		wantErr bool
+	}
+
+	testcases := []testcase{
+		{name: "data only", give: Event{Data: testItem{ID: 1}}, want: "data: {\"id\":1}\n\n"},
+		{name: "all fields", give: Event{ID: "5", Event: "progress", Data: "half", Retry: 2 * time.Second}, want: "id: 5\nevent: progress\nretry: 2000\ndata: \"half\"\n\n"},
+		{name: "multiline string is escaped", give: Event{Data: "a\nb"}, want: "data: \"a\\nb\"\n\n"},
+		{name: "newline in ID", give: Event{ID: "1\n2"}, wantErr: true},
+		{name: "newline in event", give: Event{Event: "a\rb"}, wantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := encodeEvent(tc.give)
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.want, string(got))
+		})
+	}
+}
+
+func TestSSEWriter(t *testing.T) {
+	replay := NewMemoryReplayBuffer(2)
+	replay.Add(Event{ID: "1", Data: 1})
+	replay.Add(Event{ID: "2", Data: 2})
+	replay.Add(Event{ID: "3", Data: 3})
+
+	type testcase struct {
+		name        string
+		lastEventID string
+		options     *SSEOptions
+		want        string
+	}
+
+	testcases := []testcase{
+		{name: "no replay", lastEventID: "2", want: "data: 4\n\n"},
+		{name: "resume", lastEventID: "2", options: &SSEOptions{Replay: replay}, want: "id: 3\ndata: 3\n\ndata: 4\n\n"},
+		{name: "resume from expired event", lastEventID: "1", options: &SSEOptions{Replay: replay}, want: "id: 2\ndata: 2\n\nid: 3\ndata: 3\n\ndata: 4\n\n"},
+		{name: "retry", options: &SSEOptions{Retry: time.Second}, want: "retry: 1000\n\ndata: 4\n\n"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.lastEventID != "" {
+				r.Header.Set("Last-Event-ID", tc.lastEventID)
+			}
+			rr := httptest.NewRecorder()
+
+			sse, err := NewSSEWriter(rr, r, tc.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = sse.Send(Event{Data: 4})
+			sse.Close()
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, rr.Body.String())
+			assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+			assert.Equal(t, "no-cache", rr.Header().Get("Cache-Control"))
+			assert.True(t, rr.Flushed)
+		})
+	}
+}
+
+func TestSSEWriterHeartbeat(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+
+	sse, err := NewSSEWriter(rr, r, &SSEOptions{HeartbeatInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(35 * time.Millisecond)
+	sse.Close()
+
+	assert.Contains(t, rr.Body.String(), ": heartbeat\n\n")
+}
+
+func TestSSEWriterDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	sse, err := NewSSEWriter(rr, r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sse.Close()
+
+	cancel()
+	<-sse.Done()
+
+	err = sse.Send(Event{Data: 1})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "", rr.Body.String())
+}
+
+func TestSSEWriterLogsStatusAndSize(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+
+	h := logger.Middleware(zap.New(core))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sse, err := NewSSEWriter(w, r, nil)
+		if err != nil {
+			Error(r.Context(), w, err)
+			return
+		}
+		defer sse.Close()
+		_ = sse.Send(Event{Data: 1})
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, "data: 1\n\n", rr.Body.String())
+	entries := logs.FilterMessage("Served").All()
+	if assert.Len(t, entries, 1) {
+		fields := entries[0].ContextMap()
+		assert.Equal(t, int64(http.StatusOK), fields["status"])
+		assert.Equal(t, int64(len("data: 1\n\n")), fields["size"])
+	}
+}
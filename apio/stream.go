@@ -0,0 +1,293 @@
+package apio
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/common-fate/apikit/logger"
+	"go.uber.org/zap"
+)
+
+// DefaultFlushInterval is how often streamed responses are flushed if
+// StreamWriterOptions.FlushInterval isn't set.
+const DefaultFlushInterval = time.Second
+
+// Iterator returns the next item to stream. It returns false once there are no more items.
+// The context is cancelled if the client disconnects.
+type Iterator[T any] func(ctx context.Context) (item T, ok bool, err error)
+
+// ChannelIterator returns an Iterator which reads items from ch until it is closed.
+func ChannelIterator[T any](ch <-chan T) Iterator[T] {
+	return func(ctx context.Context) (T, bool, error) {
+		select {
+		case item, ok := <-ch:
+			return item, ok, nil
+		case <-ctx.Done():
+			var zero T
+			return zero, false, ctx.Err()
+		}
+	}
+}
+
+// SliceIterator returns an Iterator over the items in a slice.
+func SliceIterator[T any](items []T) Iterator[T] {
+	i := 0
+	return func(ctx context.Context) (T, bool, error) {
+		if i >= len(items) {
+			var zero T
+			return zero, false, nil
+		}
+		i++
+		return items[i-1], true, nil
+	}
+}
+
+// StreamWriterOptions customise how streamed responses are written.
+type StreamWriterOptions struct {
+	// FlushInterval is the longest time written items are buffered before being flushed
+	// to the client, even if the iterator is waiting for more items.
+	// If zero, DefaultFlushInterval is used.
+	FlushInterval time.Duration
+	// FlushEvery flushes the response after every FlushEvery items.
+	// If zero, the response is only flushed based on FlushInterval.
+	FlushEvery int
+}
+
+// StreamJSON writes the items from an Iterator to the client as a JSON array, encoding
+// each item as it is received rather than holding the whole response in memory.
+//
+// The status code and headers are only sent once the first item is ready, so if the
+// iterator fails before then the error is sent with apio.Error as usual. If it fails after
+// the response has started, the error is logged and the array is left unterminated, so that
+// clients get a parse error rather than silently receiving a truncated list.
+//
+// If the context is cancelled, such as when the client disconnects, StreamJSON stops
+// and returns the context error without writing anything further.
+func StreamJSON[T any](ctx context.Context, w http.ResponseWriter, items Iterator[T], statusCode int, options *StreamWriterOptions) error {
+	s := newStreamWriter(ctx, w, "application/json", statusCode, options)
+	defer s.stop()
+
+	for i := 0; ; i++ {
+		item, ok, err := nextItem(ctx, items)
+		if err != nil {
+			return s.fail(err, nil)
+		}
+		if !ok {
+			break
+		}
+
+		data, err := json.Marshal(item)
+		if err != nil {
+			return s.fail(err, nil)
+		}
+		prefix := ","
+		if i == 0 {
+			prefix = "["
+		}
+		if err := s.write([]byte(prefix), omitAbsent(item, data)); err != nil {
+			return err
+		}
+	}
+
+	end := "]"
+	if !s.started {
+		end = "[]"
+	}
+	if err := s.write([]byte(end)); err != nil {
+		return err
+	}
+	s.flush()
+	return nil
+}
+
+// StreamNDJSON writes the items from an Iterator to the client as newline-delimited
+// JSON (application/x-ndjson), encoding each item as it is received.
+//
+// The status code and headers are only sent once the first item is ready, so if the
+// iterator fails before then the error is sent with apio.Error as usual. If it fails
+// after the response has started, the error is logged and a final line is written in
+// the same form as apio.Error's response body:
+//
+//	{"error": "msg"}
+//
+// If the context is cancelled, such as when the client disconnects, StreamNDJSON stops
+// and returns the context error without writing anything further.
+func StreamNDJSON[T any](ctx context.Context, w http.ResponseWriter, items Iterator[T], statusCode int, options *StreamWriterOptions) error {
+	s := newStreamWriter(ctx, w, "application/x-ndjson", statusCode, options)
+	defer s.stop()
+
+	writeErrorLine := func(er ErrorResponse) error {
+		data, err := json.Marshal(er)
+		if err != nil {
+			return err
+		}
+		return s.write(data, []byte("\n"))
+	}
+
+	for {
+		item, ok, err := nextItem(ctx, items)
+		if err != nil {
+			return s.fail(err, writeErrorLine)
+		}
+		if !ok {
+			break
+		}
+
+		data, err := json.Marshal(item)
+		if err != nil {
+			return s.fail(err, writeErrorLine)
+		}
+		if err := s.write(omitAbsent(item, data), []byte("\n")); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	if !s.started {
+		s.start()
+	}
+	s.mu.Unlock()
+	s.flush()
+	return nil
+}
+
+// streamWriter holds the state shared by the streaming response writers.
+// Pending items are flushed from a timer while the iterator waits for more,
+// so the response is guarded by mu.
+type streamWriter struct {
+	ctx         context.Context
+	w           http.ResponseWriter
+	contentType string
+	statusCode  int
+	options     StreamWriterOptions
+
+	mu      sync.Mutex
+	started bool
+	pending int
+	timer   *time.Timer
+	stopped bool
+}
+
+func newStreamWriter(ctx context.Context, w http.ResponseWriter, contentType string, statusCode int, options *StreamWriterOptions) *streamWriter {
+	var opts StreamWriterOptions
+	if options != nil {
+		opts = *options
+	}
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	return &streamWriter{
+		ctx:         ctx,
+		w:           w,
+		contentType: contentType,
+		statusCode:  statusCode,
+		options:     opts,
+	}
+}
+
+// nextItem returns the next item, checking for cancellation first.
+func nextItem[T any](ctx context.Context, items Iterator[T]) (T, bool, error) {
+	if err := ctx.Err(); err != nil {
+		var zero T
+		return zero, false, err
+	}
+	return items(ctx)
+}
+
+func (s *streamWriter) start() {
+	s.started = true
+	s.w.Header().Set("Content-Type", s.contentType)
+	s.w.WriteHeader(s.statusCode)
+}
+
+// write writes the chunks to the response, starting it if necessary. It flushes once
+// FlushEvery items are pending, and otherwise makes sure they're flushed within FlushInterval.
+func (s *streamWriter) write(chunks ...[]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started {
+		s.start()
+	}
+	for _, c := range chunks {
+		if _, err := s.w.Write(c); err != nil {
+			logger.Get(s.ctx).Errorw("writing response", zap.Error(err))
+			return err
+		}
+	}
+
+	s.pending++
+	switch {
+	case s.options.FlushEvery > 0 && s.pending >= s.options.FlushEvery:
+		s.flushLocked()
+	case s.timer == nil:
+		s.timer = time.AfterFunc(s.options.FlushInterval, s.timedFlush)
+	}
+	return nil
+}
+
+// timedFlush flushes items which have been pending for FlushInterval.
+func (s *streamWriter) timedFlush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timer = nil
+	// the response can't be used once the handler has returned.
+	if !s.stopped && s.pending > 0 {
+		s.flushLocked()
+	}
+}
+
+func (s *streamWriter) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+}
+
+func (s *streamWriter) flushLocked() {
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	s.pending = 0
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+// stop prevents any further timed flushes, as the response is finished.
+func (s *streamWriter) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopped = true
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+// fail handles an error which occurred while streaming. If the response hasn't started
+// the error is sent with apio.Error. Otherwise it's reported, and onStarted is called to
+// let the client know if the format allows it. Context errors are returned without
+// writing anything, as the client has gone away.
+func (s *streamWriter) fail(err error, onStarted func(ErrorResponse) error) error {
+	if s.ctx.Err() != nil {
+		return err
+	}
+	if !s.started {
+		Error(s.ctx, s.w, err)
+		return err
+	}
+
+	reportError(s.ctx, err)
+	if onStarted != nil {
+		er, _ := errorResponse(err)
+		_ = onStarted(er)
+	}
+	s.flush()
+	return err
+}
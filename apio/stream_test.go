@@ -0,0 +1,142 @@
+package apio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testItem struct {
+	ID int `json:"id"`
+}
+
+// failingIterator returns n items and then fails.
+func failingIterator(n int, err error) Iterator[testItem] {
+	i := 0
+	return func(ctx context.Context) (testItem, bool, error) {
+		if i >= n {
+			return testItem{}, false, err
+		}
+		i++
+		return testItem{ID: i}, true, nil
+	}
+}
+
+func TestStreamJSON(t *testing.T) {
+	type testcase struct {
+		name       string
+		giveItems  Iterator[testItem]
+		wantStatus int
+		wantBody   string
+		wantErr    bool
+	}
+
+	testcases := []testcase{
+		{name: "ok", giveItems: SliceIterator([]testItem{{ID: 1}, {ID: 2}}), wantStatus: http.StatusOK, wantBody: `[{"id":1},{"id":2}]`},
+		{name: "empty", giveItems: SliceIterator([]testItem{}), wantStatus: http.StatusOK, wantBody: `[]`},
+		{name: "error before first item", giveItems: failingIterator(0, NewRequestError(errors.New("bad cursor"), http.StatusBadRequest)), wantStatus: http.StatusBadRequest, wantBody: `{"error":"bad cursor"}`, wantErr: true},
+		{name: "error mid stream", giveItems: failingIterator(2, errors.New("database error")), wantStatus: http.StatusOK, wantBody: `[{"id":1},{"id":2}`, wantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			err := StreamJSON(context.Background(), rr, tc.giveItems, http.StatusOK, &StreamWriterOptions{FlushEvery: 1})
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			assert.Equal(t, tc.wantBody, rr.Body.String())
+			assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestStreamNDJSON(t *testing.T) {
+	type testcase struct {
+		name       string
+		giveItems  Iterator[testItem]
+		wantStatus int
+		wantBody   string
+	}
+
+	testcases := []testcase{
+		{name: "ok", giveItems: SliceIterator([]testItem{{ID: 1}, {ID: 2}}), wantStatus: http.StatusOK, wantBody: "{\"id\":1}\n{\"id\":2}\n"},
+		{name: "error mid stream", giveItems: failingIterator(1, errors.New("database error")), wantStatus: http.StatusOK, wantBody: "{\"id\":1}\n{\"error\":\"Internal Server Error\"}\n"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			_ = StreamNDJSON(context.Background(), rr, tc.giveItems, http.StatusOK, nil)
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			assert.Equal(t, tc.wantBody, rr.Body.String())
+			assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+			assert.True(t, rr.Flushed)
+		})
+	}
+}
+
+func TestStreamCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan testItem, 1)
+	ch <- testItem{ID: 1}
+
+	rr := httptest.NewRecorder()
+	items := ChannelIterator(ch)
+	wrapped := func(ctx context.Context) (testItem, bool, error) {
+		item, ok, err := items(ctx)
+		// the client goes away after the first item.
+		cancel()
+		return item, ok, err
+	}
+
+	err := StreamJSON(ctx, rr, wrapped, http.StatusOK, nil)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, `[{"id":1}`, rr.Body.String())
+}
+
+// flushRecorder signals each flush, along with the body written so far.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	mu      sync.Mutex
+	flushed chan string
+}
+
+func (f *flushRecorder) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ResponseRecorder.Write(b)
+}
+
+func (f *flushRecorder) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flushed <- f.Body.String()
+}
+
+func TestStreamFlushesWhileWaiting(t *testing.T) {
+	ch := make(chan testItem)
+	rr := &flushRecorder{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan string, 10)}
+
+	done := make(chan error)
+	go func() {
+		done <- StreamNDJSON(context.Background(), rr, ChannelIterator(ch), http.StatusOK, &StreamWriterOptions{FlushInterval: 10 * time.Millisecond})
+	}()
+
+	// the item is flushed while the iterator waits for the next one.
+	ch <- testItem{ID: 1}
+	select {
+	case body := <-rr.flushed:
+		assert.Equal(t, "{\"id\":1}\n", body)
+	case <-time.After(time.Second):
+		t.Fatal("the pending item wasn't flushed")
+	}
+
+	close(ch)
+	assert.NoError(t, <-done)
+}
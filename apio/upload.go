@@ -0,0 +1,416 @@
+package apio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	// DefaultMaxFileSize is the largest file accepted in an upload if UploadOptions.MaxFileSize isn't set.
+	DefaultMaxFileSize = 10 << 20
+	// DefaultMaxUploadSize is the largest upload request body accepted if UploadOptions.MaxTotalSize isn't set.
+	DefaultMaxUploadSize = 32 << 20
+)
+
+// sniffLen is the number of bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// UploadOptions customise how multipart/form-data uploads are read.
+type UploadOptions struct {
+	// MaxFileSize is the largest file accepted in bytes.
+	// If zero, DefaultMaxFileSize is used.
+	MaxFileSize int64
+	// MaxTotalSize is the largest request body accepted in bytes.
+	// If zero, DefaultMaxUploadSize is used.
+	MaxTotalSize int64
+	// AllowedTypes restricts the content types of uploaded files. The content type is
+	// sniffed from the file contents with http.DetectContentType rather than trusting the
+	// client. Wildcards such as "image/*" are supported. If empty, any content type is accepted.
+	//
+	// http.DetectContentType reports JSON as text/plain, so a text file which starts like
+	// a JSON object or array is treated as application/json if the client sent that type.
+	AllowedTypes []string
+	// SpoolToDisk writes saved files to temporary files rather than holding them in memory.
+	SpoolToDisk bool
+	// TempDir is the directory temporary files are written to.
+	// If empty, the default directory from os.TempDir is used.
+	TempDir string
+	// JSON are the options used to decode JSON metadata parts.
+	JSON *DecodeOptions
+}
+
+// UploadReader streams the parts of a multipart/form-data request.
+type UploadReader struct {
+	mr      *multipart.Reader
+	options UploadOptions
+}
+
+// NewUploadReader returns a reader for the parts of a multipart/form-data request.
+// Options may be nil to use the defaults.
+//
+// Like DecodeJSONBody, the body is limited with http.MaxBytesReader, so the server closes
+// the connection if the client sends more than UploadOptions.MaxTotalSize.
+//
+// An *APIError with status 415 is returned if the request isn't multipart/form-data.
+func NewUploadReader(w http.ResponseWriter, r *http.Request, options *UploadOptions) (*UploadReader, error) {
+	var opts UploadOptions
+	if options != nil {
+		opts = *options
+	}
+	if opts.MaxFileSize == 0 {
+		opts.MaxFileSize = DefaultMaxFileSize
+	}
+	if opts.MaxTotalSize == 0 {
+		opts.MaxTotalSize = DefaultMaxUploadSize
+	}
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		err := errors.New("Content-Type header is not multipart/form-data")
+		return nil, NewRequestError(err, http.StatusUnsupportedMediaType)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, opts.MaxTotalSize)
+
+	return &UploadReader{
+		mr:      multipart.NewReader(r.Body, params["boundary"]),
+		options: opts,
+	}, nil
+}
+
+// NextPart returns the next part of the upload, or io.EOF when there are no more parts.
+// Any unread data in the previous part is discarded.
+//
+// For file parts, the content type is sniffed and checked against UploadOptions.AllowedTypes.
+func (u *UploadReader) NextPart() (*UploadPart, error) {
+	p, err := u.mr.NextPart()
+	if err != nil {
+		return nil, u.requestError(err)
+	}
+
+	part := &UploadPart{
+		FieldName: p.FormName(),
+		FileName:  p.FileName(),
+		upload:    u,
+	}
+
+	if !part.IsFile() {
+		part.r = bufio.NewReader(&limitedReader{r: p, n: maxBodyBytes, err: part.tooLargeError(maxBodyBytes)})
+		part.ContentType = p.Header.Get("Content-Type")
+		return part, nil
+	}
+
+	br := bufio.NewReaderSize(&limitedReader{r: p, n: u.options.MaxFileSize, err: part.tooLargeError(u.options.MaxFileSize)}, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, u.requestError(err)
+	}
+	part.ContentType = sniffContentType(head, p.Header.Get("Content-Type"))
+	part.r = br
+
+	if !typeAllowed(u.options.AllowedTypes, part.ContentType) {
+		return nil, &APIError{
+			Err:    errors.New("request contains a file type which is not allowed"),
+			Status: http.StatusUnsupportedMediaType,
+			Fields: []FieldError{{Field: part.FieldName, Error: fmt.Sprintf("file type %s is not allowed", mediaTypeOf(part.ContentType))}},
+		}
+	}
+
+	return part, nil
+}
+
+// requestError converts errors from reading the multipart body into client-friendly errors.
+func (u *UploadReader) requestError(err error) error {
+	var apiErr *APIError
+	switch {
+	case err == io.EOF:
+		return io.EOF
+	case errors.As(err, &apiErr):
+		return apiErr
+	// the error from http.MaxBytesReader may be wrapped by the multipart reader.
+	case strings.Contains(err.Error(), "http: request body too large"):
+		err := fmt.Errorf("request body must not be larger than %s", formatSize(u.options.MaxTotalSize))
+		return NewRequestError(err, http.StatusRequestEntityTooLarge)
+	default:
+		err := errors.New("request body contains a badly-formed multipart form")
+		return NewRequestError(err, http.StatusBadRequest)
+	}
+}
+
+// sniffContentType detects the content type of a file from its first bytes. JSON is
+// detected as text/plain, so the client's type is used for text which looks like JSON.
+func sniffContentType(head []byte, clientType string) string {
+	contentType := http.DetectContentType(head)
+	if mediaTypeOf(contentType) != "text/plain" || mediaTypeOf(clientType) != "application/json" {
+		return contentType
+	}
+	switch trimmed := bytes.TrimLeft(head, " \t\r\n"); {
+	case bytes.HasPrefix(trimmed, []byte("{")), bytes.HasPrefix(trimmed, []byte("[")):
+		return "application/json"
+	}
+	return contentType
+}
+
+// UploadPart is a single part of a multipart/form-data upload.
+type UploadPart struct {
+	// FieldName is the name of the form field.
+	FieldName string
+	// FileName is the file name provided by the client. It is empty for non-file parts.
+	FileName string
+	// ContentType is the sniffed content type for file parts, or the
+	// Content-Type header provided by the client for other parts.
+	ContentType string
+
+	r      io.Reader
+	upload *UploadReader
+}
+
+// IsFile reports whether the part is a file.
+func (p *UploadPart) IsFile() bool {
+	return p.FileName != ""
+}
+
+// Read reads the contents of the part. Once the size limit for the part is
+// exceeded an *APIError with status 413 is returned.
+func (p *UploadPart) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		err = p.upload.requestError(err)
+	}
+	return n, err
+}
+
+// DecodeJSON decodes the part as a JSON document using the same rules as DecodeJSONBody.
+// Field errors are reported with the form field name prefixed to the JSON Pointer, such as metadata/name.
+func (p *UploadPart) DecodeJSON(dst interface{}) error {
+	if p.ContentType != "" && mediaTypeOf(p.ContentType) != "application/json" {
+		return &APIError{
+			Err:    errors.New("request contains a part which is not application/json"),
+			Status: http.StatusUnsupportedMediaType,
+			Fields: []FieldError{{Field: p.FieldName, Error: "Content-Type is not application/json"}},
+		}
+	}
+
+	data, err := io.ReadAll(p)
+	if err != nil {
+		return err
+	}
+
+	err = decodeJSON(data, dst, p.upload.options.JSON)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		fields := []FieldError{{Field: p.FieldName, Error: apiErr.Err.Error()}}
+		if len(apiErr.Fields) > 0 {
+			fields = make([]FieldError, len(apiErr.Fields))
+			for i, f := range apiErr.Fields {
+				fields[i] = FieldError{Field: p.FieldName + f.Field, Error: f.Error}
+			}
+		}
+		return &APIError{
+			Err:    fmt.Errorf("request contains an invalid %s part", p.FieldName),
+			Status: apiErr.Status,
+			Fields: fields,
+		}
+	}
+	return err
+}
+
+// Save reads the part into memory, or into a temporary file if UploadOptions.SpoolToDisk is set.
+func (p *UploadPart) Save() (*UploadedFile, error) {
+	f := &UploadedFile{
+		FieldName:   p.FieldName,
+		FileName:    p.FileName,
+		ContentType: p.ContentType,
+	}
+
+	if !p.upload.options.SpoolToDisk {
+		data, err := io.ReadAll(p)
+		if err != nil {
+			return nil, err
+		}
+		f.data = data
+		f.Size = int64(len(data))
+		return f, nil
+	}
+
+	tmp, err := os.CreateTemp(p.upload.options.TempDir, "apio-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	f.path = tmp.Name()
+
+	f.Size, err = io.Copy(tmp, p)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(f.path)
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *UploadPart) tooLargeError(max int64) error {
+	return &APIError{
+		Err:    fmt.Errorf("request contains a %s part which is too large", p.FieldName),
+		Status: http.StatusRequestEntityTooLarge,
+		Fields: []FieldError{{Field: p.FieldName, Error: fmt.Sprintf("must not be larger than %s", formatSize(max))}},
+	}
+}
+
+// UploadedFile is a file from an upload which has been saved in memory or to disk.
+type UploadedFile struct {
+	FieldName   string
+	FileName    string
+	ContentType string
+	Size        int64
+
+	data []byte
+	path string
+}
+
+// Open returns a reader for the contents of the file.
+func (f *UploadedFile) Open() (io.ReadCloser, error) {
+	if f.path != "" {
+		return os.Open(f.path)
+	}
+	return io.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+// Path returns the path of the temporary file, if the file was spooled to disk.
+func (f *UploadedFile) Path() string {
+	return f.path
+}
+
+// Remove deletes the temporary file, if the file was spooled to disk.
+func (f *UploadedFile) Remove() error {
+	if f.path == "" {
+		return nil
+	}
+	return os.Remove(f.path)
+}
+
+// ReadUpload reads a whole multipart/form-data upload. Files are saved using UploadPart.Save,
+// and parts named in metadata are decoded into the corresponding destination using UploadPart.DecodeJSON:
+//
+//	var meta DocumentMetadata
+//	files, err := apio.ReadUpload(w, r, &apio.UploadOptions{AllowedTypes: []string{"application/pdf"}}, map[string]interface{}{
+//		"metadata": &meta,
+//	})
+//
+// Any other non-file parts result in an *APIError with status 400. If an error is returned,
+// any files which were spooled to disk have already been removed.
+func ReadUpload(w http.ResponseWriter, r *http.Request, options *UploadOptions, metadata map[string]interface{}) (files []*UploadedFile, err error) {
+	defer func() {
+		if err != nil {
+			for _, f := range files {
+				_ = f.Remove()
+			}
+			files = nil
+		}
+	}()
+
+	ur, err := NewUploadReader(w, r, options)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := ur.NextPart()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, err
+		}
+
+		if part.IsFile() {
+			f, err := part.Save()
+			if err != nil {
+				return files, err
+			}
+			files = append(files, f)
+			continue
+		}
+
+		dst, ok := metadata[part.FieldName]
+		if !ok {
+			return files, &APIError{
+				Err:    errors.New("request contains an unexpected form field"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: part.FieldName, Error: "unknown field"}},
+			}
+		}
+		if err := part.DecodeJSON(dst); err != nil {
+			return files, err
+		}
+	}
+}
+
+// limitedReader reads from r, returning err once more than n bytes have been read.
+type limitedReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (l *limitedReader) Read(b []byte) (int, error) {
+	if l.n < 0 {
+		return 0, l.err
+	}
+	// read one byte past the limit so we can tell whether it has been exceeded.
+	if int64(len(b)) > l.n+1 {
+		b = b[:l.n+1]
+	}
+	n, err := l.r.Read(b)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n + int(l.n), l.err
+	}
+	return n, err
+}
+
+func typeAllowed(allowed []string, contentType string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mt := mediaTypeOf(contentType)
+	for _, a := range allowed {
+		if a == mt {
+			return true
+		}
+		if strings.HasSuffix(a, "/*") && strings.HasPrefix(mt, strings.TrimSuffix(a, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaTypeOf strips any parameters from a content type.
+func mediaTypeOf(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return mt
+}
+
+// formatSize formats a size in bytes for error messages.
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", n>>10)
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
@@ -0,0 +1,195 @@
+package apio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPDF = []byte("%PDF-1.4\nhello world")
+
+type testPart struct {
+	name        string
+	filename    string
+	contentType string
+	body        []byte
+}
+
+func newUploadRequest(t *testing.T, parts []testPart) *http.Request {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		h := make(textproto.MIMEHeader)
+		disposition := `form-data; name="` + p.name + `"`
+		if p.filename != "" {
+			disposition += `; filename="` + p.filename + `"`
+		}
+		h.Set("Content-Disposition", disposition)
+		if p.contentType != "" {
+			h.Set("Content-Type", p.contentType)
+		}
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pw.Write(p.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestReadUpload(t *testing.T) {
+	type metadata struct {
+		Title string `json:"title"`
+	}
+
+	type testcase struct {
+		name        string
+		giveParts   []testPart
+		giveOptions *UploadOptions
+		wantMeta    metadata
+		wantFiles   int
+		wantErr     error
+	}
+
+	testcases := []testcase{
+		{
+			name: "ok",
+			giveParts: []testPart{
+				{name: "metadata", contentType: "application/json", body: []byte(`{"title":"report"}`)},
+				{name: "file", filename: "report.pdf", contentType: "image/png", body: testPDF},
+			},
+			giveOptions: &UploadOptions{AllowedTypes: []string{"application/pdf"}},
+			wantMeta:    metadata{Title: "report"},
+			wantFiles:   1,
+		},
+		{
+			name: "spool to disk",
+			giveParts: []testPart{
+				{name: "file", filename: "a.txt", body: []byte("hello")},
+				{name: "file", filename: "b.txt", body: []byte("world")},
+			},
+			giveOptions: &UploadOptions{SpoolToDisk: true, AllowedTypes: []string{"text/*"}},
+			wantFiles:   2,
+		},
+		{
+			name:        "json file",
+			giveParts:   []testPart{{name: "file", filename: "a.json", contentType: "application/json", body: []byte(` {"a":1}`)}},
+			giveOptions: &UploadOptions{AllowedTypes: []string{"application/json"}},
+			wantFiles:   1,
+		},
+		{
+			name:        "text claiming to be json",
+			giveParts:   []testPart{{name: "file", filename: "a.json", contentType: "application/json", body: []byte("hello")}},
+			giveOptions: &UploadOptions{AllowedTypes: []string{"application/json"}},
+			wantErr: &APIError{
+				Err:    errors.New("request contains a file type which is not allowed"),
+				Status: http.StatusUnsupportedMediaType,
+				Fields: []FieldError{{Field: "file", Error: "file type text/plain is not allowed"}},
+			},
+		},
+		{
+			name:        "type not allowed",
+			giveParts:   []testPart{{name: "file", filename: "a.txt", body: []byte("hello")}},
+			giveOptions: &UploadOptions{AllowedTypes: []string{"image/*"}},
+			wantErr: &APIError{
+				Err:    errors.New("request contains a file type which is not allowed"),
+				Status: http.StatusUnsupportedMediaType,
+				Fields: []FieldError{{Field: "file", Error: "file type text/plain is not allowed"}},
+			},
+		},
+		{
+			name:        "file too large",
+			giveParts:   []testPart{{name: "file", filename: "a.pdf", body: bytes.Repeat([]byte("a"), 2048)}},
+			giveOptions: &UploadOptions{MaxFileSize: 1024},
+			wantErr: &APIError{
+				Err:    errors.New("request contains a file part which is too large"),
+				Status: http.StatusRequestEntityTooLarge,
+				Fields: []FieldError{{Field: "file", Error: "must not be larger than 1KB"}},
+			},
+		},
+		{
+			name:        "total too large",
+			giveParts:   []testPart{{name: "file", filename: "a.pdf", body: bytes.Repeat([]byte("a"), 4096)}},
+			giveOptions: &UploadOptions{MaxTotalSize: 2048},
+			wantErr:     &APIError{Err: errors.New("request body must not be larger than 2KB"), Status: http.StatusRequestEntityTooLarge},
+		},
+		{
+			name:      "invalid metadata",
+			giveParts: []testPart{{name: "metadata", body: []byte(`{"title":1}`)}},
+			wantErr: &APIError{
+				Err:    errors.New("request contains an invalid metadata part"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "metadata/title", Error: "cannot use number as string (at line 1, column 10)"}},
+			},
+		},
+		{
+			name:      "unexpected field",
+			giveParts: []testPart{{name: "other", body: []byte("x")}},
+			wantErr: &APIError{
+				Err:    errors.New("request contains an unexpected form field"),
+				Status: http.StatusBadRequest,
+				Fields: []FieldError{{Field: "other", Error: "unknown field"}},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newUploadRequest(t, tc.giveParts)
+
+			var meta metadata
+			files, err := ReadUpload(httptest.NewRecorder(), r, tc.giveOptions, map[string]interface{}{"metadata": &meta})
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantMeta, meta)
+			assert.Len(t, files, tc.wantFiles)
+
+			for _, f := range files {
+				if tc.giveOptions != nil && tc.giveOptions.SpoolToDisk {
+					assert.FileExists(t, f.Path())
+				}
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, f.Size, int64(len(data)))
+
+				if err := f.Remove(); err != nil {
+					t.Fatal(err)
+				}
+				if f.Path() != "" {
+					_, err := os.Stat(f.Path())
+					assert.True(t, os.IsNotExist(err))
+				}
+			}
+		})
+	}
+}
+
+func TestNewUploadReaderContentType(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("Content-Type", "application/json")
+
+	_, err := NewUploadReader(httptest.NewRecorder(), r, nil)
+	assert.Equal(t, &APIError{Err: errors.New("Content-Type header is not multipart/form-data"), Status: http.StatusUnsupportedMediaType}, err)
+}
@@ -9,4 +9,8 @@
 //
 // When calling apio.Error(), if a Handler exists in the provided context, HandleError() will
 // be called.
+//
+// Packages can also register an Observer with errhandler.Observe, to be notified of every
+// error sent by apio.Error. The logger, requestid, tracing and metrics packages use this to
+// log, annotate, trace and count errors without apio depending on them.
 package errhandler
@@ -8,6 +8,15 @@ type Handler interface {
 	HandleError(err error)
 }
 
+// ContextHandler is a Handler which is also given the request context, so that it can
+// include details such as the request ID from requestid.Get with the error.
+// If a Handler implements ContextHandler, apio.Error() calls HandleErrorContext
+// instead of HandleError.
+type ContextHandler interface {
+	Handler
+	HandleErrorContext(ctx context.Context, err error)
+}
+
 var errHandlerKey = &contextKey{"errHandler"}
 
 type contextKey struct {
@@ -18,3 +18,23 @@ func TestContext(t *testing.T) {
 	got := Get(ctx)
 	assert.Equal(t, h, got)
 }
+
+func TestObserve(t *testing.T) {
+	type key struct{}
+	var got []Event
+	Observe(func(ctx context.Context, e *Event) {
+		if ctx.Value(key{}) != nil {
+			got = append(got, *e)
+		}
+	})
+	Observe(func(ctx context.Context, e *Event) {
+		e.RequestID = "req_1"
+	})
+
+	ctx := context.WithValue(context.Background(), key{}, true)
+	e := Event{Status: 404, Kind: "not_found"}
+	Notify(ctx, &e)
+
+	assert.Equal(t, []Event{{Status: 404, Kind: "not_found"}}, got)
+	assert.Equal(t, "req_1", e.RequestID)
+}
@@ -0,0 +1,46 @@
+package errhandler
+
+import (
+	"context"
+	"sync"
+)
+
+// Event describes an error response being sent by apio.Error.
+type Event struct {
+	Err error
+	// Status is the HTTP status code of the response.
+	Status int
+	// Kind is a short name for the kind of error, from apio.ErrorKind.
+	Kind string
+	// RequestID is included in the response body, so that clients can quote it when
+	// reporting problems. It's empty unless an Observer sets it.
+	RequestID string
+}
+
+// Observer is notified of every error response sent by apio.Error, before the
+// response is written. Observers must be safe for concurrent use.
+type Observer func(ctx context.Context, e *Event)
+
+var (
+	observersMu sync.RWMutex
+	observers   []Observer
+)
+
+// Observe registers an Observer for the errors sent by apio.Error. Packages such as
+// tracing and metrics register themselves when they're imported, so that apio
+// doesn't need to depend on them.
+func Observe(o Observer) {
+	observersMu.Lock()
+	defer observersMu.Unlock()
+	observers = append(observers, o)
+}
+
+// Notify calls each registered Observer with the event, in the order they were registered.
+// It's called by apio.Error.
+func Notify(ctx context.Context, e *Event) {
+	observersMu.RLock()
+	defer observersMu.RUnlock()
+	for _, o := range observers {
+		o(ctx, e)
+	}
+}
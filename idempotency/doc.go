@@ -0,0 +1,14 @@
+// Package idempotency provides middleware which makes retried requests safe, by
+// storing the response to the first request with a given Idempotency-Key header
+// and replaying it for any retries.
+//
+// Keys are scoped to the user ID from the userid package, so the middleware must be
+// added after any authentication middleware which calls userid.Set:
+//
+//	r.Use(logger.Middleware(log))
+//	r.Use(auth)
+//	r.Use(idempotency.Middleware(idempotency.NewMemoryStore(), nil))
+//
+// The in-memory store only works for a single instance of a service. Implement the
+// Store interface with a shared database to use idempotency keys across instances.
+package idempotency
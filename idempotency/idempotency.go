@@ -0,0 +1,301 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/common-fate/apikit/apio"
+	"github.com/common-fate/apikit/logger"
+	"github.com/common-fate/apikit/userid"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultHeader is the request header containing the idempotency key.
+	DefaultHeader = "Idempotency-Key"
+	// DefaultTTL is how long responses are stored if Options.TTL isn't set.
+	DefaultTTL = 24 * time.Hour
+	// DefaultLockTTL is how long a key is reserved while its request is being handled
+	// if Options.LockTTL isn't set.
+	DefaultLockTTL = time.Minute
+	// DefaultMaxBodySize is the largest request body accepted if Options.MaxBodySize isn't set.
+	DefaultMaxBodySize = 1 << 20
+	// DefaultMaxResponseSize is the largest response body stored if Options.MaxResponseSize isn't set.
+	DefaultMaxResponseSize = 1 << 20
+	// maxKeyLength is the longest idempotency key accepted.
+	maxKeyLength = 255
+)
+
+// ReplayedHeader is set to "true" on responses which have been replayed from the store.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// DefaultExcludedHeaders are the response headers which aren't replayed if
+// Options.ExcludedHeaders isn't set. It's the header set by requestid.Middleware.
+var DefaultExcludedHeaders = []string{"X-Request-Id"}
+
+// Options customise the idempotency middleware.
+type Options struct {
+	// Header is the request header containing the idempotency key.
+	// If empty, DefaultHeader is used.
+	Header string
+	// Methods are the request methods idempotency keys are used for.
+	// If nil, POST and PATCH requests are handled.
+	Methods []string
+	// TTL is how long responses are stored. If zero, DefaultTTL is used.
+	TTL time.Duration
+	// LockTTL is how long a key is reserved while the first request with it is being handled.
+	// It limits how long retries are rejected if the instance handling the request stops
+	// without releasing the key, so it should be a little longer than the slowest request.
+	// If zero, DefaultLockTTL is used.
+	LockTTL time.Duration
+	// MaxBodySize is the largest request body accepted in bytes when an idempotency key
+	// is provided, as the body is read into memory to fingerprint the request.
+	// If zero, DefaultMaxBodySize is used.
+	MaxBodySize int64
+	// MaxResponseSize is the largest response body stored in bytes. Larger responses are
+	// sent to the client as usual, but they aren't stored, so the request can be retried.
+	// If zero, DefaultMaxResponseSize is used.
+	MaxResponseSize int64
+	// ExcludedHeaders are response headers which are never replayed, such as the request ID
+	// header. If nil, DefaultExcludedHeaders is used.
+	ExcludedHeaders []string
+}
+
+// Middleware returns middleware which stores the response to the first request with
+// each idempotency key, and replays it for later requests with the same key.
+// Options may be nil to use the defaults.
+//
+// Keys are scoped to the user ID from userid.Get. The stored record also includes a
+// fingerprint of the request method, URL and body, so that:
+//
+//   - a retry of a request which is still being handled receives 409 Conflict.
+//   - a key reused for a different request receives 422 Unprocessable Entity.
+//
+// Responses with a 5xx status code or a body larger than MaxResponseSize aren't stored,
+// so the request can be retried.
+// Requests without an idempotency key are passed through unchanged.
+//
+// Replayed responses keep the headers already set by earlier middleware rather than the
+// values stored with the first response, and never include the ExcludedHeaders.
+func Middleware(store Store, options *Options) func(next http.Handler) http.Handler {
+	var opts Options
+	if options != nil {
+		opts = *options
+	}
+	if opts.Header == "" {
+		opts.Header = DefaultHeader
+	}
+	if opts.Methods == nil {
+		opts.Methods = []string{http.MethodPost, http.MethodPatch}
+	}
+	if opts.TTL == 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.LockTTL == 0 {
+		opts.LockTTL = DefaultLockTTL
+	}
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
+	if opts.MaxResponseSize == 0 {
+		opts.MaxResponseSize = DefaultMaxResponseSize
+	}
+	if opts.ExcludedHeaders == nil {
+		opts.ExcludedHeaders = DefaultExcludedHeaders
+	}
+
+	methods := make(map[string]bool, len(opts.Methods))
+	for _, m := range opts.Methods {
+		methods[m] = true
+	}
+	excluded := make(map[string]bool, len(opts.ExcludedHeaders))
+	for _, h := range opts.ExcludedHeaders {
+		excluded[http.CanonicalHeaderKey(h)] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(opts.Header)
+			if key == "" || !methods[r.Method] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+
+			if len(key) > maxKeyLength {
+				err := fmt.Errorf("%s header must not be longer than %d characters", opts.Header, maxKeyLength)
+				apio.Error(ctx, w, apio.NewRequestError(err, http.StatusBadRequest))
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, opts.MaxBodySize))
+			if err != nil {
+				if err.Error() == "http: request body too large" {
+					err = fmt.Errorf("request body must not be larger than %d bytes", opts.MaxBodySize)
+					err = apio.NewRequestError(err, http.StatusRequestEntityTooLarge)
+				}
+				apio.Error(ctx, w, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			storeKey := scopedKey(userid.Get(ctx), key)
+			fp := fingerprint(r, body)
+			token, err := newToken()
+			if err != nil {
+				apio.Error(ctx, w, err)
+				return
+			}
+
+			rec, err := store.Begin(ctx, storeKey, fp, token, opts.LockTTL)
+			if err != nil {
+				apio.Error(ctx, w, err)
+				return
+			}
+
+			if rec != nil {
+				switch {
+				case rec.Fingerprint != fp:
+					err := fmt.Errorf("%s has already been used for a different request", opts.Header)
+					apio.Error(ctx, w, apio.NewRequestError(err, http.StatusUnprocessableEntity))
+				case rec.Response == nil:
+					err := fmt.Errorf("a request with this %s is already in progress", opts.Header)
+					apio.Error(ctx, w, apio.NewRequestError(err, http.StatusConflict))
+				default:
+					replay(ctx, w, rec.Response, excluded)
+				}
+				return
+			}
+
+			rw := &recorder{ResponseWriter: w, max: opts.MaxResponseSize}
+
+			// the response is stored even if the client disconnects, so
+			// the store isn't called with the request context.
+			completed := false
+			defer func() {
+				if completed {
+					return
+				}
+				if err := store.Release(context.Background(), storeKey, token); err != nil {
+					logger.Get(ctx).Errorw("releasing idempotency key", zap.Error(err))
+				}
+			}()
+
+			next.ServeHTTP(rw, r)
+
+			resp := rw.response()
+			if resp.Status >= http.StatusInternalServerError {
+				return
+			}
+			if rw.tooLarge {
+				logger.Get(ctx).Warnw("response is too large to store for idempotency key", zap.Int64("maxResponseSize", opts.MaxResponseSize))
+				return
+			}
+			if err := store.Complete(context.Background(), storeKey, token, resp, opts.TTL); err != nil {
+				logger.Get(ctx).Errorw("storing idempotent response", zap.Error(err))
+				return
+			}
+			completed = true
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// scopedKey combines the user ID and idempotency key into the key used in the store.
+func scopedKey(uid, key string) string {
+	sum := sha256.Sum256([]byte(uid + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken returns a random token identifying a reservation made with Store.Begin.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// fingerprint identifies a request by its method, URL and body.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replay writes a stored response. Headers which are already set are kept, as they
+// were set for this request, and excluded headers are never sent.
+func replay(ctx context.Context, w http.ResponseWriter, resp *Response, excluded map[string]bool) {
+	for k, v := range resp.Header {
+		if _, ok := w.Header()[k]; ok || excluded[k] {
+			continue
+		}
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(resp.Status)
+	if _, err := w.Write(resp.Body); err != nil {
+		logger.Get(ctx).Errorw("writing response", zap.Error(err))
+	}
+}
+
+// recorder captures the response written by a handler while passing it through to the client.
+// It stops capturing the body once it's larger than max.
+type recorder struct {
+	http.ResponseWriter
+	max      int64
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	tooLarge bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+		r.header = r.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if !r.tooLarge {
+		if int64(r.body.Len()+len(b)) > r.max {
+			r.tooLarge = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, so streaming responses work through the middleware.
+func (r *recorder) Flush() {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *recorder) response() Response {
+	if r.status == 0 {
+		// the handler didn't write anything, which net/http sends as 200 OK.
+		return Response{Status: http.StatusOK, Header: r.Header().Clone()}
+	}
+	return Response{Status: r.status, Header: r.header, Body: r.body.Bytes()}
+}
@@ -0,0 +1,301 @@
+package idempotency
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/common-fate/apikit/requestid"
+	"github.com/common-fate/apikit/userid"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingHandler creates a resource, returning the number of times it has been called.
+func countingHandler(calls *int32, status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/things/"+strconv.Itoa(int(n)))
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"call":` + strconv.Itoa(int(n)) + `,"body":` + string(body) + `}`))
+	})
+}
+
+func newRequest(method, key, uid, body string) *http.Request {
+	r := httptest.NewRequest(method, "/things", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(DefaultHeader, key)
+	}
+	if uid != "" {
+		r = r.WithContext(userid.Set(r.Context(), uid))
+	}
+	return r
+}
+
+func TestMiddlewareReplays(t *testing.T) {
+	var calls int32
+	h := Middleware(NewMemoryStore(), nil)(countingHandler(&calls, http.StatusCreated))
+
+	first := httptest.NewRecorder()
+	h.ServeHTTP(first, newRequest(http.MethodPost, "abc", "usr_1", `{"a":1}`))
+
+	retry := httptest.NewRecorder()
+	h.ServeHTTP(retry, newRequest(http.MethodPost, "abc", "usr_1", `{"a":1}`))
+
+	assert.Equal(t, int32(1), calls)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "/things/1", retry.Header().Get("Location"))
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+	assert.Empty(t, first.Header().Get(ReplayedHeader))
+}
+
+func TestMiddlewareReplayKeepsRequestID(t *testing.T) {
+	var calls int32
+	h := requestid.Middleware(nil)(Middleware(NewMemoryStore(), nil)(countingHandler(&calls, http.StatusCreated)))
+
+	first := httptest.NewRecorder()
+	h.ServeHTTP(first, newRequest(http.MethodPost, "abc", "", `{}`))
+
+	retry := httptest.NewRecorder()
+	h.ServeHTTP(retry, newRequest(http.MethodPost, "abc", "", `{}`))
+
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+	assert.NotEmpty(t, retry.Header().Get(requestid.DefaultHeader))
+	assert.NotEqual(t, first.Header().Get(requestid.DefaultHeader), retry.Header().Get(requestid.DefaultHeader))
+	assert.Equal(t, []string{"/things/1"}, retry.Header().Values("Location"))
+}
+
+func TestMiddlewareFlush(t *testing.T) {
+	h := Middleware(NewMemoryStore(), nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("a"))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("b"))
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodPost, "abc", "", `{}`))
+	assert.True(t, rr.Flushed)
+
+	retry := httptest.NewRecorder()
+	h.ServeHTTP(retry, newRequest(http.MethodPost, "abc", "", `{}`))
+	assert.Equal(t, "ab", retry.Body.String())
+}
+
+func TestMiddlewareLockTTL(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := Middleware(store, &Options{LockTTL: time.Minute})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodPost, "abc", "", `{}`))
+		close(done)
+	}()
+	<-started
+
+	// the key is only reserved for the lock TTL while the request is in progress.
+	store.mu.Lock()
+	assert.Equal(t, now.Add(time.Minute), store.records[scopedKey("", "abc")].expires)
+	store.mu.Unlock()
+
+	close(release)
+	<-done
+
+	store.mu.Lock()
+	assert.Equal(t, now.Add(DefaultTTL), store.records[scopedKey("", "abc")].expires)
+	store.mu.Unlock()
+}
+
+func TestMiddlewareScoping(t *testing.T) {
+	type testcase struct {
+		name      string
+		second    *http.Request
+		wantCalls int32
+		wantCode  int
+	}
+
+	testcases := []testcase{
+		{name: "no key", second: newRequest(http.MethodPost, "", "usr_1", `{"a":1}`), wantCalls: 2, wantCode: http.StatusCreated},
+		{name: "different user", second: newRequest(http.MethodPost, "abc", "usr_2", `{"a":1}`), wantCalls: 2, wantCode: http.StatusCreated},
+		{name: "different key", second: newRequest(http.MethodPost, "def", "usr_1", `{"a":1}`), wantCalls: 2, wantCode: http.StatusCreated},
+		{name: "different body", second: newRequest(http.MethodPost, "abc", "usr_1", `{"a":2}`), wantCalls: 1, wantCode: http.StatusUnprocessableEntity},
+		{name: "method not handled", second: newRequest(http.MethodPut, "abc", "usr_1", `{"a":1}`), wantCalls: 2, wantCode: http.StatusCreated},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int32
+			h := Middleware(NewMemoryStore(), nil)(countingHandler(&calls, http.StatusCreated))
+
+			h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodPost, "abc", "usr_1", `{"a":1}`))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, tc.second)
+
+			assert.Equal(t, tc.wantCalls, calls)
+			assert.Equal(t, tc.wantCode, rr.Code)
+		})
+	}
+}
+
+func TestMiddlewareInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := Middleware(NewMemoryStore(), nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodPost, "abc", "", `{}`))
+		close(done)
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodPost, "abc", "", `{}`))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.JSONEq(t, `{"error":"a request with this Idempotency-Key is already in progress"}`, rr.Body.String())
+
+	close(release)
+	<-done
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodPost, "abc", "", `{}`))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+}
+
+func TestMiddlewareServerErrorsAreNotStored(t *testing.T) {
+	var calls int32
+	h := Middleware(NewMemoryStore(), nil)(countingHandler(&calls, http.StatusInternalServerError))
+
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodPost, "abc", "", `{}`))
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodPost, "abc", "", `{}`))
+
+	assert.Equal(t, int32(2), calls)
+}
+
+func TestMiddlewarePanicReleasesKey(t *testing.T) {
+	store := NewMemoryStore()
+	h := Middleware(store, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	assert.Panics(t, func() {
+		h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodPost, "abc", "", `{}`))
+	})
+
+	rec, err := store.Begin(context.Background(), scopedKey("", "abc"), "fp", "token", time.Hour)
+	assert.NoError(t, err)
+	assert.Nil(t, rec)
+}
+
+func TestMiddlewareLimits(t *testing.T) {
+	var calls int32
+	h := Middleware(NewMemoryStore(), &Options{MaxBodySize: 4})(countingHandler(&calls, http.StatusCreated))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodPost, "abc", "", `{"a":1}`))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodPost, strings.Repeat("k", 256), "", `{}`))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	assert.Equal(t, int32(0), calls)
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	rec, _ := s.Begin(ctx, "k", "fp", "t1", time.Minute)
+	assert.Nil(t, rec)
+
+	// the response is kept for the full TTL, rather than the lock's.
+	now = now.Add(30 * time.Second)
+	_ = s.Complete(ctx, "k", "t1", Response{Status: http.StatusOK}, time.Hour)
+	now = now.Add(time.Minute)
+
+	rec, _ = s.Begin(ctx, "k", "fp", "t2", time.Hour)
+	assert.Equal(t, &Record{Fingerprint: "fp", Response: &Response{Status: http.StatusOK}}, rec)
+
+	now = now.Add(time.Hour)
+	rec, _ = s.Begin(ctx, "k", "fp2", "t3", time.Minute)
+	assert.Nil(t, rec)
+	assert.Len(t, s.records, 1)
+}
+
+func TestMemoryStoreExpiredReservation(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, _ = s.Begin(ctx, "k", "fp", "t1", time.Minute)
+
+	// the first reservation expires and a retry reserves the key again.
+	now = now.Add(time.Minute)
+	rec, _ := s.Begin(ctx, "k", "fp", "t2", time.Minute)
+	assert.Nil(t, rec)
+
+	// the first request finishing doesn't affect the retry's reservation.
+	_ = s.Complete(ctx, "k", "t1", Response{Status: http.StatusOK}, time.Hour)
+	_ = s.Release(ctx, "k", "t1")
+	rec, _ = s.Begin(ctx, "k", "fp", "t3", time.Minute)
+	assert.Equal(t, &Record{Fingerprint: "fp"}, rec)
+
+	_ = s.Complete(ctx, "k", "t2", Response{Status: http.StatusCreated}, time.Hour)
+	rec, _ = s.Begin(ctx, "k", "fp", "t3", time.Minute)
+	assert.Equal(t, &Record{Fingerprint: "fp", Response: &Response{Status: http.StatusCreated}}, rec)
+}
+
+func TestMiddlewareLargeResponsesAreNotStored(t *testing.T) {
+	var calls int32
+	h := Middleware(NewMemoryStore(), &Options{MaxResponseSize: 16})(countingHandler(&calls, http.StatusCreated))
+
+	first := httptest.NewRecorder()
+	h.ServeHTTP(first, newRequest(http.MethodPost, "abc", "", `{"a":"long enough"}`))
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.JSONEq(t, `{"call":1,"body":{"a":"long enough"}}`, first.Body.String())
+
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodPost, "abc", "", `{"a":"long enough"}`))
+	assert.Equal(t, int32(2), calls)
+}
+
+func TestMiddlewareExcludedHeaders(t *testing.T) {
+	var calls int32
+	h := requestid.Middleware(&requestid.Options{Header: "X-Trace"})(
+		Middleware(NewMemoryStore(), &Options{ExcludedHeaders: []string{"x-trace", "Location"}})(countingHandler(&calls, http.StatusCreated)),
+	)
+
+	first := httptest.NewRecorder()
+	h.ServeHTTP(first, newRequest(http.MethodPost, "abc", "", `{}`))
+
+	retry := httptest.NewRecorder()
+	h.ServeHTTP(retry, newRequest(http.MethodPost, "abc", "", `{}`))
+
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+	assert.NotEqual(t, first.Header().Get("X-Trace"), retry.Header().Get("X-Trace"))
+	assert.Empty(t, retry.Header().Get("Location"))
+	assert.Equal(t, "application/json", retry.Header().Get("Content-Type"))
+}
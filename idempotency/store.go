@@ -0,0 +1,116 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Response is a stored response to a request.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Record is the state of a request with an idempotency key.
+type Record struct {
+	// Fingerprint identifies the request the key was first used with.
+	Fingerprint string
+	// Response is nil while the first request is still being handled.
+	Response *Response
+}
+
+// Store holds idempotency records. Implementations must be safe for concurrent use,
+// and Begin must be atomic so that only one request can reserve a key.
+//
+// Each reservation has a unique token. A reservation can expire while its request is still
+// being handled and the key be reserved again, so Complete and Release must do nothing
+// unless the key is still reserved with the token they're given.
+type Store interface {
+	// Begin reserves a key for a request with the given fingerprint and token. If the key
+	// hasn't been used, it stores an in-progress record which expires after ttl and returns nil.
+	// If it has, the existing record is returned.
+	Begin(ctx context.Context, key, fingerprint, token string, ttl time.Duration) (*Record, error)
+	// Complete stores the response for a key reserved with Begin, which expires after ttl.
+	Complete(ctx context.Context, key, token string, resp Response, ttl time.Duration) error
+	// Release removes a key reserved with Begin, so that the request can be retried.
+	Release(ctx context.Context, key, token string) error
+}
+
+// sweepInterval is how often MemoryStore removes expired records.
+const sweepInterval = time.Minute
+
+// MemoryStore is a Store which holds records in memory.
+type MemoryStore struct {
+	mu        sync.Mutex
+	records   map[string]*memoryRecord
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryRecord struct {
+	Record
+	token   string
+	expires time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: map[string]*memoryRecord{},
+		now:     time.Now,
+	}
+}
+
+// Begin implements Store.
+func (s *MemoryStore) Begin(ctx context.Context, key, fingerprint, token string, ttl time.Duration) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, r := range s.records {
+			if !now.Before(r.expires) {
+				delete(s.records, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if r, ok := s.records[key]; ok && now.Before(r.expires) {
+		rec := r.Record
+		return &rec, nil
+	}
+
+	s.records[key] = &memoryRecord{
+		Record:  Record{Fingerprint: fingerprint},
+		token:   token,
+		expires: now.Add(ttl),
+	}
+	return nil, nil
+}
+
+// Complete implements Store.
+func (s *MemoryStore) Complete(ctx context.Context, key, token string, resp Response, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.records[key]; ok && r.token == token && r.Response == nil {
+		r.Response = &resp
+		r.expires = s.now().Add(ttl)
+	}
+	return nil
+}
+
+// Release implements Store.
+func (s *MemoryStore) Release(ctx context.Context, key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.records[key]; ok && r.token == token {
+		delete(s.records, key)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+// Package loadshed provides middleware which limits the number of requests handled at
+// once, so that a service under excess load rejects some requests quickly rather than
+// slowing down for everyone.
+//
+// Each Limiter has its own limit. Use one Limiter for the whole router to bound the
+// total number of in-flight requests, and others on route groups for expensive routes:
+//
+//	global := loadshed.New(loadshed.Options{
+//		MaxInFlight: 200,
+//		MaxQueue:    50,
+//		Priority:    loadshed.Paths(loadshed.Critical, "/health"),
+//	})
+//	exports := loadshed.New(loadshed.Options{MaxInFlight: 4})
+//
+//	r.Use(global.Middleware)
+//	r.With(exports.Middleware).Post("/exports", createExport)
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/common-fate/apikit/apio"
+)
+
+const (
+	// DefaultQueueTimeout is how long requests wait for a slot if Options.QueueTimeout isn't set.
+	DefaultQueueTimeout = 250 * time.Millisecond
+	// DefaultRetryAfter is the Retry-After sent with shed requests if Options.RetryAfter isn't set.
+	DefaultRetryAfter = time.Second
+)
+
+// Priority decides the order queued requests are handled in.
+type Priority int
+
+const (
+	// Low priority requests are only handled once no other requests are waiting.
+	Low Priority = iota
+	// Normal is the priority of requests unless Options.Priority says otherwise.
+	Normal
+	// High priority requests are handled before any other waiting requests.
+	High
+	// Critical requests, such as health checks, are never limited or shed.
+	Critical
+)
+
+// PriorityFunc returns the priority of a request.
+type PriorityFunc func(r *http.Request) Priority
+
+// Paths returns a PriorityFunc which gives requests for the listed URL paths priority p,
+// and all other requests Normal priority.
+func Paths(p Priority, paths ...string) PriorityFunc {
+	set := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		set[path] = true
+	}
+	return func(r *http.Request) Priority {
+		if set[r.URL.Path] {
+			return p
+		}
+		return Normal
+	}
+}
+
+// Options customise a Limiter.
+type Options struct {
+	// MaxInFlight is the most requests handled at once.
+	MaxInFlight int
+	// MaxQueue is the most requests which wait for a slot when MaxInFlight requests are
+	// being handled. If zero, excess requests are shed immediately.
+	MaxQueue int
+	// QueueTimeout is the longest a request waits for a slot before being shed.
+	// If zero, DefaultQueueTimeout is used.
+	QueueTimeout time.Duration
+	// RetryAfter is sent to clients whose requests are shed. If zero, DefaultRetryAfter is used.
+	RetryAfter time.Duration
+	// Priority returns the priority of a request. If nil, all requests have Normal priority.
+	Priority PriorityFunc
+}
+
+// Stats describe the current load on a Limiter.
+type Stats struct {
+	// InFlight is the number of requests being handled, not including Critical requests.
+	InFlight int
+	// Queued is the number of requests waiting for a slot.
+	Queued      int
+	MaxInFlight int
+	MaxQueue    int
+	// Shed is the total number of requests which have been rejected.
+	Shed uint64
+	// Cancelled is the total number of requests whose context was done, usually because
+	// the client disconnected, while they were waiting for a slot. They aren't counted as shed.
+	Cancelled uint64
+}
+
+// Utilisation returns the fraction of the in-flight limit in use, from 0 to 1.
+func (s Stats) Utilisation() float64 {
+	if s.MaxInFlight == 0 {
+		return 0
+	}
+	return float64(s.InFlight) / float64(s.MaxInFlight)
+}
+
+// Limiter limits the number of requests handled at once. It's safe for concurrent use.
+type Limiter struct {
+	opts Options
+
+	mu       sync.Mutex
+	inFlight int
+	// queues hold the waiting requests for each priority below Critical, oldest first.
+	queues    [Critical][]*waiter
+	queued    int
+	shed      uint64
+	cancelled uint64
+}
+
+// waiter is a request waiting for a slot.
+type waiter struct {
+	ready chan struct{}
+	// granted is set, under the Limiter's lock, when the waiter has been given a slot.
+	granted bool
+}
+
+// New returns a Limiter. It panics if opts.MaxInFlight isn't positive.
+func New(opts Options) *Limiter {
+	if opts.MaxInFlight <= 0 {
+		panic("loadshed: MaxInFlight must be positive")
+	}
+	if opts.QueueTimeout == 0 {
+		opts.QueueTimeout = DefaultQueueTimeout
+	}
+	if opts.RetryAfter == 0 {
+		opts.RetryAfter = DefaultRetryAfter
+	}
+	if opts.Priority == nil {
+		opts.Priority = func(r *http.Request) Priority { return Normal }
+	}
+	return &Limiter{opts: opts}
+}
+
+// Middleware limits the requests handled by next. Requests which can't be handled
+// within the queue timeout are shed with a 503 response through apio.Error,
+// with a Retry-After header. If a request's context is done while it's waiting,
+// nothing is written, since the client has usually gone away.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		p := l.opts.Priority(r)
+		if p >= Critical {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if p < Low {
+			p = Low
+		}
+
+		switch err := l.acquire(r.Context(), p); {
+		case err == errShed:
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(l.opts.RetryAfter.Seconds()))))
+			err := errors.New("the server is overloaded, please try again later")
+			apio.Error(r.Context(), w, apio.NewRequestError(err, http.StatusServiceUnavailable))
+			return
+		case err != nil:
+			// the request was cancelled while queued, so there's no one to respond to.
+			return
+		}
+		defer l.release()
+
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// Stats returns the current load on the Limiter, for use in metrics.
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return Stats{
+		InFlight:    l.inFlight,
+		Queued:      l.queued,
+		MaxInFlight: l.opts.MaxInFlight,
+		MaxQueue:    l.opts.MaxQueue,
+		Shed:        l.shed,
+		Cancelled:   l.cancelled,
+	}
+}
+
+// errShed is returned by acquire if the request should be shed.
+var errShed = errors.New("loadshed: request shed")
+
+// acquire waits for a slot. It returns errShed if the request should be shed, or the
+// context's error if the context is done before a slot is available.
+func (l *Limiter) acquire(ctx context.Context, p Priority) error {
+	l.mu.Lock()
+	if l.inFlight < l.opts.MaxInFlight && l.queued == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	if l.queued >= l.opts.MaxQueue {
+		l.shed++
+		l.mu.Unlock()
+		return errShed
+	}
+	w := &waiter{ready: make(chan struct{})}
+	l.queues[p] = append(l.queues[p], w)
+	l.queued++
+	l.mu.Unlock()
+
+	t := time.NewTimer(l.opts.QueueTimeout)
+	defer t.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+		return nil
+	case <-t.C:
+		err = errShed
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w.granted {
+		// a slot was handed over at the same time as the timeout.
+		return nil
+	}
+	q := l.queues[p]
+	for i := range q {
+		if q[i] == w {
+			l.queues[p] = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+	l.queued--
+	if err == errShed {
+		l.shed++
+	} else {
+		l.cancelled++
+	}
+	return err
+}
+
+// release frees a slot, handing it to the highest priority waiting request if there is one.
+func (l *Limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for p := High; p >= Low; p-- {
+		if len(l.queues[p]) == 0 {
+			continue
+		}
+		w := l.queues[p][0]
+		l.queues[p] = l.queues[p][1:]
+		l.queued--
+		w.granted = true
+		close(w.ready)
+		return
+	}
+	l.inFlight--
+}
@@ -0,0 +1,182 @@
+package loadshed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingHandler blocks each request until release is closed, signalling
+// on started when a request starts.
+type blockingHandler struct {
+	started chan string
+	release chan struct{}
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{started: make(chan string, 10), release: make(chan struct{})}
+}
+
+func (h *blockingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.started <- r.URL.Path
+	<-h.release
+}
+
+// serve sends a request in the background, returning a channel which receives the response.
+func serve(h http.Handler, path string) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		done <- rr
+	}()
+	return done
+}
+
+// waitForQueue waits until the limiter has n queued requests.
+func waitForQueue(t *testing.T, l *Limiter, n int) {
+	deadline := time.Now().Add(time.Second)
+	for l.Stats().Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d queued requests", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShedsWithoutQueue(t *testing.T) {
+	l := New(Options{MaxInFlight: 1, RetryAfter: 1500 * time.Millisecond})
+	bh := newBlockingHandler()
+	h := l.Middleware(bh)
+
+	first := serve(h, "/a")
+	<-bh.started
+
+	rr := <-serve(h, "/b")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error":"the server is overloaded, please try again later"}`, rr.Body.String())
+
+	close(bh.release)
+	assert.Equal(t, http.StatusOK, (<-first).Code)
+	assert.Equal(t, Stats{MaxInFlight: 1, Shed: 1}, l.Stats())
+}
+
+func TestQueues(t *testing.T) {
+	l := New(Options{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: time.Second})
+	bh := newBlockingHandler()
+	h := l.Middleware(bh)
+
+	first := serve(h, "/a")
+	<-bh.started
+	second := serve(h, "/b")
+	waitForQueue(t, l, 1)
+
+	// the queue is full.
+	assert.Equal(t, http.StatusServiceUnavailable, (<-serve(h, "/c")).Code)
+
+	stats := l.Stats()
+	assert.Equal(t, Stats{InFlight: 1, Queued: 1, MaxInFlight: 1, MaxQueue: 1, Shed: 1}, stats)
+	assert.Equal(t, 1.0, stats.Utilisation())
+
+	close(bh.release)
+	assert.Equal(t, http.StatusOK, (<-first).Code)
+	assert.Equal(t, http.StatusOK, (<-second).Code)
+	assert.Equal(t, "/b", <-bh.started)
+	assert.Equal(t, 0, l.Stats().InFlight)
+}
+
+func TestQueueTimeout(t *testing.T) {
+	l := New(Options{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 10 * time.Millisecond})
+	bh := newBlockingHandler()
+	h := l.Middleware(bh)
+
+	first := serve(h, "/a")
+	<-bh.started
+
+	assert.Equal(t, http.StatusServiceUnavailable, (<-serve(h, "/b")).Code)
+	assert.Equal(t, 0, l.Stats().Queued)
+
+	close(bh.release)
+	<-first
+}
+
+func TestCancelledWhileQueued(t *testing.T) {
+	l := New(Options{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: time.Second})
+	bh := newBlockingHandler()
+	h := l.Middleware(bh)
+
+	first := serve(h, "/a")
+	<-bh.started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/b", nil).WithContext(ctx))
+		done <- rr
+	}()
+	waitForQueue(t, l, 1)
+	cancel()
+
+	// nothing is written for a request which was cancelled.
+	rr := <-done
+	assert.False(t, rr.Flushed)
+	assert.Equal(t, "", rr.Header().Get("Retry-After"))
+	assert.Equal(t, "", rr.Body.String())
+	assert.Equal(t, Stats{InFlight: 1, MaxInFlight: 1, MaxQueue: 1, Cancelled: 1}, l.Stats())
+
+	close(bh.release)
+	<-first
+}
+
+func TestPriority(t *testing.T) {
+	priorities := map[string]Priority{"/low": Low, "/high": High, "/health": Critical}
+	l := New(Options{
+		MaxInFlight:  1,
+		MaxQueue:     2,
+		QueueTimeout: time.Second,
+		Priority:     func(r *http.Request) Priority { return priorities[r.URL.Path] },
+	})
+	bh := newBlockingHandler()
+	h := l.Middleware(bh)
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	for i, path := range []string{"/first", "/low", "/high"} {
+		done := serve(h, path)
+		go func() {
+			<-done
+			wg.Done()
+		}()
+		if i == 0 {
+			<-bh.started
+		} else {
+			waitForQueue(t, l, i)
+		}
+	}
+
+	// critical requests aren't limited.
+	health := serve(h, "/health")
+	assert.Equal(t, "/health", <-bh.started)
+
+	close(bh.release)
+	<-health
+	wg.Wait()
+
+	// the high priority request was handled before the low priority request that arrived first.
+	assert.Equal(t, "/high", <-bh.started)
+	assert.Equal(t, "/low", <-bh.started)
+}
+
+func TestPaths(t *testing.T) {
+	p := Paths(Critical, "/health", "/ready")
+
+	assert.Equal(t, Critical, p(httptest.NewRequest(http.MethodGet, "/health", nil)))
+	assert.Equal(t, Normal, p(httptest.NewRequest(http.MethodGet, "/users", nil)))
+}
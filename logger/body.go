@@ -0,0 +1,351 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultMaxBodySize is the most bytes of each body logged if BodyOptions.MaxSize isn't set.
+const DefaultMaxBodySize = 4096
+
+// DefaultBodyContentTypes are the media types of bodies logged if BodyOptions.ContentTypes isn't set.
+// They're the types which can be redacted.
+var DefaultBodyContentTypes = []string{
+	"application/json",
+	"application/*+json",
+	"application/x-www-form-urlencoded",
+}
+
+// DefaultRedactedFields are the fields redacted if BodyOptions.RedactedFields isn't set.
+var DefaultRedactedFields = []string{
+	"password",
+	"secret",
+	"client_secret",
+	"token",
+	"access_token",
+	"refresh_token",
+	"id_token",
+	"api_key",
+	"apiKey",
+	"authorization",
+}
+
+// DefaultRedactedHeaders are the headers redacted if BodyOptions.RedactedHeaders isn't set.
+var DefaultRedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+}
+
+// BodyOptions customise the logging of request and response bodies.
+//
+// Bodies are logged in the requestBody and responseBody fields. JSON bodies are redacted
+// field by field; JSON bodies over MaxSize can't be redacted, so only their size is logged.
+// Form bodies have fields matching the single segment RedactedFields patterns redacted.
+type BodyOptions struct {
+	// Request logs request bodies. Only the part of the body read by the handler is logged.
+	Request bool
+	// Response logs response bodies.
+	Response bool
+	// Headers logs the request and response headers, in the requestHeaders and responseHeaders fields.
+	Headers bool
+	// MaxSize is the most bytes of each body captured. If zero, DefaultMaxBodySize is used.
+	MaxSize int
+	// ContentTypes are the media types of bodies which are logged, such as "application/json".
+	// A "*" can be used for the subtype, as in "text/*", or before a suffix, as in "application/*+json".
+	// If nil, DefaultBodyContentTypes is used.
+	ContentTypes []string
+	// RedactedFields are patterns matching fields whose values are replaced with "REDACTED".
+	// A pattern with a single segment, such as "password", matches fields with that name at any
+	// depth. A dotted pattern, such as "user.password", matches a path from the top of the
+	// document, where "*" matches any field name and array indexes are skipped.
+	// Names are compared case-insensitively. If nil, DefaultRedactedFields is used.
+	RedactedFields []string
+	// RedactedHeaders are the headers whose values are replaced with "REDACTED".
+	// If nil, DefaultRedactedHeaders is used.
+	RedactedHeaders []string
+}
+
+// bodyLogger captures and redacts bodies according to BodyOptions.
+type bodyLogger struct {
+	opts BodyOptions
+	// patterns are the RedactedFields patterns, lowercased and split into segments.
+	patterns [][]string
+	// formFields are the single segment RedactedFields patterns, for redacting forms.
+	formFields map[string]bool
+	headers    map[string]bool
+}
+
+func newBodyLogger(options *BodyOptions) *bodyLogger {
+	if options == nil {
+		return nil
+	}
+	opts := *options
+	if opts.MaxSize == 0 {
+		opts.MaxSize = DefaultMaxBodySize
+	}
+	if opts.ContentTypes == nil {
+		opts.ContentTypes = DefaultBodyContentTypes
+	}
+	if opts.RedactedFields == nil {
+		opts.RedactedFields = DefaultRedactedFields
+	}
+	if opts.RedactedHeaders == nil {
+		opts.RedactedHeaders = DefaultRedactedHeaders
+	}
+
+	bl := &bodyLogger{opts: opts, formFields: map[string]bool{}, headers: map[string]bool{}}
+	for _, f := range opts.RedactedFields {
+		segments := strings.Split(strings.ToLower(f), ".")
+		bl.patterns = append(bl.patterns, segments)
+		if len(segments) == 1 {
+			bl.formFields[segments[0]] = true
+		}
+	}
+	for _, h := range opts.RedactedHeaders {
+		bl.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	return bl
+}
+
+// bodyCapture holds the bodies captured for a request.
+type bodyCapture struct {
+	request  *limitedBuffer
+	response *limitedBuffer
+}
+
+// capture starts capturing the bodies of a request. It replaces the request body
+// so the body is captured as the handler reads it.
+func (bl *bodyLogger) capture(r *http.Request, ww middleware.WrapResponseWriter) *bodyCapture {
+	bc := &bodyCapture{}
+	if bl.opts.Request && r.Body != nil && r.Body != http.NoBody && bl.loggable(r.Header.Get("Content-Type")) {
+		bc.request = &limitedBuffer{max: bl.opts.MaxSize}
+		r.Body = &captureReader{ReadCloser: r.Body, buf: bc.request}
+	}
+	if bl.opts.Response {
+		// the response content type isn't known yet, so it's checked once the response is written.
+		bc.response = &limitedBuffer{max: bl.opts.MaxSize}
+		ww.Tee(bc.response)
+	}
+	return bc
+}
+
+// fields returns the log fields for the captured bodies and the headers.
+func (bl *bodyLogger) fields(bc *bodyCapture, r *http.Request, responseHeader http.Header) []zapcore.Field {
+	var fields []zapcore.Field
+	if bl.opts.Headers {
+		fields = append(fields,
+			zap.Any("requestHeaders", bl.redactHeaders(r.Header)),
+			zap.Any("responseHeaders", bl.redactHeaders(responseHeader)),
+		)
+	}
+	if bc.request != nil {
+		fields = append(fields, bl.bodyFields("requestBody", bc.request, r.Header.Get("Content-Type"))...)
+	}
+	if bc.response != nil && bl.loggable(responseHeader.Get("Content-Type")) {
+		fields = append(fields, bl.bodyFields("responseBody", bc.response, responseHeader.Get("Content-Type"))...)
+	}
+	return fields
+}
+
+func (bl *bodyLogger) bodyFields(name string, buf *limitedBuffer, contentType string) []zapcore.Field {
+	// the handler may still be writing to the buffer, such as when it has timed out.
+	data, size, truncated := buf.snapshot()
+	if len(data) == 0 {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	if mediaType == "application/x-www-form-urlencoded" {
+		body := redactQuery(string(data), bl.formFields)
+		return truncatedFields(name, body, truncated)
+	}
+
+	if isJSON(mediaType) {
+		if truncated {
+			// a partial JSON document can't be parsed, so it can't be safely redacted.
+			return []zapcore.Field{zap.Int(name+"Size", size), zap.Bool(name+"Truncated", true)}
+		}
+		body, err := bl.redactJSON(data)
+		if err != nil {
+			return []zapcore.Field{zap.String(name, "invalid JSON")}
+		}
+		return []zapcore.Field{zap.String(name, body)}
+	}
+
+	return truncatedFields(name, string(data), truncated)
+}
+
+func truncatedFields(name string, body string, truncated bool) []zapcore.Field {
+	fields := []zapcore.Field{zap.String(name, body)}
+	if truncated {
+		fields = append(fields, zap.Bool(name+"Truncated", true))
+	}
+	return fields
+}
+
+// loggable reports whether bodies with the content type should be logged.
+func (bl *bodyLogger) loggable(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range bl.opts.ContentTypes {
+		if matchMediaType(strings.ToLower(pattern), mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMediaType matches a media type against a pattern such as "application/json",
+// "text/*" or "application/*+json".
+func matchMediaType(pattern, mediaType string) bool {
+	if pattern == mediaType {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	}
+	if i := strings.Index(pattern, "/*+"); i >= 0 {
+		return strings.HasPrefix(mediaType, pattern[:i+1]) && strings.HasSuffix(mediaType, pattern[i+2:])
+	}
+	return false
+}
+
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// redactJSON replaces the values of redacted fields in a JSON document, returning it compacted.
+func (bl *bodyLogger) redactJSON(data []byte) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	v = bl.redactValue(v, nil)
+	out, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (bl *bodyLogger) redactValue(v interface{}, path []string) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			childPath := append(path[:len(path):len(path)], strings.ToLower(k))
+			if bl.redactedField(childPath) {
+				val[k] = redacted
+				continue
+			}
+			val[k] = bl.redactValue(child, childPath)
+		}
+	case []interface{}:
+		// array indexes aren't part of the path.
+		for i, child := range val {
+			val[i] = bl.redactValue(child, path)
+		}
+	}
+	return v
+}
+
+// redactedField reports whether the field at path, with lowercase names, matches a pattern.
+func (bl *bodyLogger) redactedField(path []string) bool {
+	for _, pattern := range bl.patterns {
+		if len(pattern) == 1 {
+			if pattern[0] == "*" || pattern[0] == path[len(path)-1] {
+				return true
+			}
+			continue
+		}
+		if len(pattern) != len(path) {
+			continue
+		}
+		match := true
+		for i := range pattern {
+			if pattern[i] != "*" && pattern[i] != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHeaders returns the headers, with multiple values joined, and redacted headers replaced.
+func (bl *bodyLogger) redactHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		if bl.headers[http.CanonicalHeaderKey(k)] {
+			out[k] = redacted
+			continue
+		}
+		out[k] = strings.Join(v, ", ")
+	}
+	return out
+}
+
+// limitedBuffer holds up to max bytes written to it, recording whether more were written.
+// Writes always succeed so that it can be used with io.TeeReader and WrapResponseWriter.Tee.
+// It's safe for concurrent use, as the handler can keep writing after the request is logged.
+type limitedBuffer struct {
+	max int
+
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	size      int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.size += len(p)
+	remaining := b.max - b.buf.Len()
+	if len(p) > remaining {
+		b.buf.Write(p[:remaining])
+		b.truncated = true
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// snapshot returns a copy of the bytes held, the total number of bytes
+// written and whether any were discarded.
+func (b *limitedBuffer) snapshot() ([]byte, int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...), b.size, b.truncated
+}
+
+// captureReader copies what's read from the request body into a buffer.
+type captureReader struct {
+	io.ReadCloser
+	buf *limitedBuffer
+}
+
+func (c *captureReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	_, _ = c.buf.Write(p[:n])
+	return n, err
+}
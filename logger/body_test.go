@@ -0,0 +1,189 @@
+package logger
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestBodyLogging(t *testing.T) {
+	type testcase struct {
+		name         string
+		options      *BodyOptions
+		requestType  string
+		requestBody  string
+		responseType string
+		responseBody string
+		want         map[string]interface{}
+		notWant      []string
+	}
+
+	testcases := []testcase{
+		{
+			name:         "json bodies are redacted",
+			options:      &BodyOptions{Request: true, Response: true},
+			requestType:  "application/json",
+			requestBody:  `{"email":"a@example.com","password":"hunter2","nested":{"Token":"abc"},"items":[{"secret":"s"}]}`,
+			responseType: "application/json; charset=utf-8",
+			responseBody: `{"id":"usr_1","access_token":"xyz"}`,
+			want: map[string]interface{}{
+				"requestBody":  `{"email":"a@example.com","items":[{"secret":"REDACTED"}],"nested":{"Token":"REDACTED"},"password":"REDACTED"}`,
+				"responseBody": `{"access_token":"REDACTED","id":"usr_1"}`,
+			},
+		},
+		{
+			name:         "path patterns",
+			options:      &BodyOptions{Request: true, RedactedFields: []string{"user.name", "items.*"}},
+			requestType:  "application/json",
+			requestBody:  `{"name":"top","user":{"name":"nested","password":"p"},"items":[{"a":1,"b":2}]}`,
+			responseType: "application/json",
+			want: map[string]interface{}{
+				"requestBody": `{"items":[{"a":"REDACTED","b":"REDACTED"}],"name":"top","user":{"name":"REDACTED","password":"p"}}`,
+			},
+		},
+		{
+			name:        "form bodies are redacted",
+			options:     &BodyOptions{Request: true},
+			requestType: "application/x-www-form-urlencoded",
+			requestBody: "username=alice&password=hunter2",
+			want:        map[string]interface{}{"requestBody": "username=alice&password=REDACTED"},
+		},
+		{
+			name:        "truncated json isn't logged",
+			options:     &BodyOptions{Request: true, MaxSize: 10},
+			requestType: "application/json",
+			requestBody: `{"password":"hunter2"}`,
+			want:        map[string]interface{}{"requestBodySize": int64(22), "requestBodyTruncated": true},
+			notWant:     []string{"requestBody"},
+		},
+		{
+			name:        "truncated text",
+			options:     &BodyOptions{Request: true, MaxSize: 5, ContentTypes: []string{"text/*"}},
+			requestType: "text/plain",
+			requestBody: "hello world",
+			want:        map[string]interface{}{"requestBody": "hello", "requestBodyTruncated": true},
+		},
+		{
+			name:         "content types are filtered",
+			options:      &BodyOptions{Request: true, Response: true},
+			requestType:  "application/octet-stream",
+			requestBody:  "binary",
+			responseType: "text/html",
+			responseBody: "<html></html>",
+			notWant:      []string{"requestBody", "responseBody"},
+		},
+		{
+			name:         "json suffix types",
+			options:      &BodyOptions{Response: true},
+			responseType: "application/problem+json",
+			responseBody: `{"title":"error"}`,
+			want:         map[string]interface{}{"responseBody": `{"title":"error"}`},
+		},
+		{
+			name:        "invalid json",
+			options:     &BodyOptions{Request: true},
+			requestType: "application/json",
+			requestBody: `{"password":`,
+			want:        map[string]interface{}{"requestBody": "invalid JSON"},
+		},
+		{
+			name:         "not logged by default",
+			requestType:  "application/json",
+			requestBody:  `{"email":"a@example.com"}`,
+			responseType: "application/json",
+			responseBody: `{}`,
+			notWant:      []string{"requestBody", "responseBody", "requestHeaders"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			observed, logs := observer.New(zapcore.InfoLevel)
+
+			h := MiddlewareWithOptions(zap.New(observed), &Options{Bodies: tc.options})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = ioutil.ReadAll(r.Body)
+				if tc.responseType != "" {
+					w.Header().Set("Content-Type", tc.responseType)
+				}
+				_, _ = w.Write([]byte(tc.responseBody))
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.requestBody))
+			req.Header.Set("Content-Type", tc.requestType)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			got := logs.All()[0].ContextMap()
+			for k, v := range tc.want {
+				assert.Equal(t, v, got[k], k)
+			}
+			for _, k := range tc.notWant {
+				assert.NotContains(t, got, k)
+			}
+		})
+	}
+}
+
+func TestBodyLoggingWhileHandlerRuns(t *testing.T) {
+	observed, logs := observer.New(zapcore.InfoLevel)
+
+	// the handler keeps reading the body after the middleware has returned,
+	// as it can behind timeout.Middleware. This is checked by the race detector.
+	done := make(chan struct{})
+	h := MiddlewareWithOptions(zap.New(observed), &Options{Bodies: &BodyOptions{Request: true}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 1)
+		_, _ = r.Body.Read(buf)
+		go func() {
+			defer close(done)
+			_, _ = ioutil.ReadAll(r.Body)
+		}()
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"a":"`+strings.Repeat("a", 1000)+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	<-done
+
+	assert.Equal(t, 1, logs.Len())
+}
+
+func TestHeaderLogging(t *testing.T) {
+	observed, logs := observer.New(zapcore.InfoLevel)
+
+	h := MiddlewareWithOptions(zap.New(observed), &Options{Bodies: &BodyOptions{Headers: true}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=abc")
+		w.Header().Add("Vary", "Accept")
+		w.Header().Add("Vary", "Origin")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer abc")
+	req.Header.Set("cookie", "session=abc")
+	req.Header.Set("Accept", "application/json")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	got := logs.All()[0].ContextMap()
+	assert.Equal(t, map[string]string{
+		"Authorization": "REDACTED",
+		"Cookie":        "REDACTED",
+		"Accept":        "application/json",
+	}, got["requestHeaders"])
+	assert.Equal(t, map[string]string{
+		"Set-Cookie": "REDACTED",
+		"Vary":       "Accept, Origin",
+	}, got["responseHeaders"])
+}
+
+func TestMatchMediaType(t *testing.T) {
+	assert.True(t, matchMediaType("application/json", "application/json"))
+	assert.True(t, matchMediaType("text/*", "text/plain"))
+	assert.True(t, matchMediaType("application/*+json", "application/vnd.api+json"))
+	assert.False(t, matchMediaType("application/*+json", "application/json"))
+	assert.False(t, matchMediaType("text/*", "application/text"))
+}
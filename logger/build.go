@@ -1,20 +1,273 @@
 package logger
 
-import "go.uber.org/zap"
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
 
 // Build builds a logger with the specified log level.
+// It's a shortcut for DefaultConfig with the level set.
 func Build(level string) (*zap.SugaredLogger, error) {
-	cfg := zap.NewProductionConfig()
-	err := cfg.Level.UnmarshalText([]byte(level))
+	cfg := DefaultConfig()
+	cfg.Level = level
+	return cfg.Build()
+}
+
+// Encodings which a Config can use.
+const (
+	EncodingJSON    = "json"
+	EncodingConsole = "console"
+)
+
+// Time encodings which a Config can use.
+const (
+	TimeEpoch       = "epoch"
+	TimeMillis      = "millis"
+	TimeNanos       = "nanos"
+	TimeISO8601     = "iso8601"
+	TimeRFC3339     = "rfc3339"
+	TimeRFC3339Nano = "rfc3339nano"
+)
+
+// SamplingConfig limits the number of entries logged each second. The first Initial
+// entries with the same level and message are logged, then every Thereafter-th entry.
+type SamplingConfig struct {
+	Initial    int
+	Thereafter int
+}
+
+// Config describes a logger. The zero value logs unsampled JSON at the info level to stderr.
+type Config struct {
+	// Level is the minimum level logged, such as "debug" or "warn". If empty, "info" is used.
+	Level string
+	// Encoding is the format of entries, EncodingJSON or EncodingConsole. If empty,
+	// EncodingJSON is used, or EncodingConsole in development mode.
+	Encoding string
+	// OutputPaths are the files or URLs logs are written to, where "stdout" and "stderr"
+	// are the standard streams. If empty, logs are written to stderr.
+	OutputPaths []string
+	// ErrorOutputPaths are where errors within the logger itself are written.
+	// If empty, they're written to stderr.
+	ErrorOutputPaths []string
+	// Sampling limits the number of entries logged. If nil, all entries are logged.
+	Sampling *SamplingConfig
+	// StacktraceLevel is the level at and above which stacktraces are logged.
+	// If empty, stacktraces aren't logged.
+	StacktraceLevel string
+	// Fields are added to every entry, such as the service, version and environment.
+	Fields map[string]string
+	// Development makes DPanic entries panic, and logs in colour in the console format.
+	Development bool
+	// TimeEncoding is the format of timestamps, such as TimeRFC3339. If empty,
+	// TimeEpoch is used, or TimeISO8601 in development mode.
+	TimeEncoding string
+}
+
+// DefaultConfig returns the config used by Build: JSON logged to stderr at the info level,
+// with sampling and without stacktraces.
+func DefaultConfig() Config {
+	return Config{
+		Level:        "info",
+		Encoding:     EncodingJSON,
+		OutputPaths:  []string{"stderr"},
+		Sampling:     &SamplingConfig{Initial: 100, Thereafter: 100},
+		TimeEncoding: TimeEpoch,
+	}
+}
+
+// Build builds a logger from the config.
+func (c Config) Build() (*zap.SugaredLogger, error) {
+	zc := zap.NewProductionConfig()
+	if c.Development {
+		zc = zap.NewDevelopmentConfig()
+	}
+
+	level := c.Level
+	if level == "" {
+		level = "info"
+	}
+	if err := zc.Level.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+
+	zc.Encoding = c.Encoding
+	if zc.Encoding == "" {
+		zc.Encoding = EncodingJSON
+		if c.Development {
+			zc.Encoding = EncodingConsole
+		}
+	}
+	if zc.Encoding != EncodingJSON && zc.Encoding != EncodingConsole {
+		return nil, fmt.Errorf("unknown log encoding %q", c.Encoding)
+	}
+	if c.Development && zc.Encoding == EncodingConsole {
+		zc.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+
+	timeEncoding := c.TimeEncoding
+	if timeEncoding == "" {
+		timeEncoding = TimeEpoch
+		if c.Development {
+			timeEncoding = TimeISO8601
+		}
+	}
+	switch timeEncoding {
+	case TimeEpoch, TimeMillis, TimeNanos, TimeISO8601, TimeRFC3339, TimeRFC3339Nano:
+		if err := zc.EncoderConfig.EncodeTime.UnmarshalText([]byte(timeEncoding)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown log time encoding %q", c.TimeEncoding)
+	}
+
+	zc.OutputPaths = []string{"stderr"}
+	if len(c.OutputPaths) > 0 {
+		zc.OutputPaths = c.OutputPaths
+	}
+	zc.ErrorOutputPaths = []string{"stderr"}
+	if len(c.ErrorOutputPaths) > 0 {
+		zc.ErrorOutputPaths = c.ErrorOutputPaths
+	}
+
+	zc.Sampling = nil
+	if c.Sampling != nil {
+		zc.Sampling = &zap.SamplingConfig{Initial: c.Sampling.Initial, Thereafter: c.Sampling.Thereafter}
+	}
+
+	var opts []zap.Option
+	zc.DisableStacktrace = true
+	if c.StacktraceLevel != "" {
+		var l zapcore.Level
+		if err := l.UnmarshalText([]byte(c.StacktraceLevel)); err != nil {
+			return nil, err
+		}
+		opts = append(opts, zap.AddStacktrace(l))
+	}
+
+	if len(c.Fields) > 0 {
+		zc.InitialFields = make(map[string]interface{}, len(c.Fields))
+		for k, v := range c.Fields {
+			zc.InitialFields[k] = v
+		}
+	}
+
+	zc.Development = c.Development
+
+	log, err := zc.Build(opts...)
 	if err != nil {
 		return nil, err
 	}
-	cfg.DisableStacktrace = true
+	return log.Sugar(), nil
+}
+
+// ConfigFromEnv returns DefaultConfig, updated from the following environment variables
+// if they're set, where each name is preceded by prefix, such as "MYAPP_":
+//
+//	LOG_LEVEL             the minimum level logged, such as "debug"
+//	LOG_FORMAT            "json" or "console"
+//	LOG_OUTPUT            comma separated output paths
+//	LOG_ERROR_OUTPUT      comma separated error output paths
+//	LOG_SAMPLING          "initial,thereafter", such as "100,100", or "off"
+//	LOG_STACKTRACE_LEVEL  the level stacktraces are logged at, or "off"
+//	LOG_FIELDS            comma separated static fields, such as "service=api,version=1.2.0"
+//	LOG_DEVELOPMENT       "true" to enable development mode
+//	LOG_TIME_ENCODING     the timestamp format, such as "rfc3339"
+func ConfigFromEnv(prefix string) (Config, error) {
+	c := DefaultConfig()
+	env := func(name string) (string, bool) {
+		v, ok := os.LookupEnv(prefix + name)
+		return strings.TrimSpace(v), ok
+	}
+
+	if v, ok := env("LOG_LEVEL"); ok {
+		c.Level = v
+	}
+	if v, ok := env("LOG_FORMAT"); ok {
+		c.Encoding = v
+	}
+	if v, ok := env("LOG_OUTPUT"); ok {
+		c.OutputPaths = splitList(v)
+	}
+	if v, ok := env("LOG_ERROR_OUTPUT"); ok {
+		c.ErrorOutputPaths = splitList(v)
+	}
+	if v, ok := env("LOG_SAMPLING"); ok {
+		s, err := parseSampling(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("parsing %sLOG_SAMPLING: %w", prefix, err)
+		}
+		c.Sampling = s
+	}
+	if v, ok := env("LOG_STACKTRACE_LEVEL"); ok {
+		if v == "off" {
+			v = ""
+		}
+		c.StacktraceLevel = v
+	}
+	if v, ok := env("LOG_FIELDS"); ok {
+		c.Fields = map[string]string{}
+		for _, f := range splitList(v) {
+			k, val, found := strings.Cut(f, "=")
+			if !found || strings.TrimSpace(k) == "" {
+				return Config{}, fmt.Errorf("parsing %sLOG_FIELDS: field %q must be in the form key=value", prefix, f)
+			}
+			c.Fields[strings.TrimSpace(k)] = strings.TrimSpace(val)
+		}
+	}
+	if v, ok := env("LOG_DEVELOPMENT"); ok {
+		dev, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("parsing %sLOG_DEVELOPMENT: %w", prefix, err)
+		}
+		c.Development = dev
+		if dev {
+			// leave the format and time encoding to the development defaults,
+			// unless they're set explicitly.
+			if _, ok := env("LOG_FORMAT"); !ok {
+				c.Encoding = ""
+			}
+			if _, ok := env("LOG_TIME_ENCODING"); !ok {
+				c.TimeEncoding = ""
+			}
+		}
+	}
+	if v, ok := env("LOG_TIME_ENCODING"); ok {
+		c.TimeEncoding = v
+	}
+	return c, nil
+}
+
+// splitList splits a comma separated list, ignoring empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
 
-	logProd, err := cfg.Build()
+func parseSampling(s string) (*SamplingConfig, error) {
+	if s == "off" {
+		return nil, nil
+	}
+	initial, thereafter, found := strings.Cut(s, ",")
+	if !found {
+		return nil, fmt.Errorf("%q must be in the form initial,thereafter or off", s)
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(initial))
+	if err != nil {
+		return nil, err
+	}
+	t, err := strconv.Atoi(strings.TrimSpace(thereafter))
 	if err != nil {
 		return nil, err
 	}
-	log := logProd.Sugar()
-	return log, nil
+	return &SamplingConfig{Initial: i, Thereafter: t}, nil
 }
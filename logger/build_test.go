@@ -0,0 +1,155 @@
+package logger
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildAndLog builds a logger writing to a temporary file, logs an entry at each level,
+// and returns the lines written.
+func buildAndLog(t *testing.T, cfg Config) []string {
+	path := filepath.Join(t.TempDir(), "log")
+	cfg.OutputPaths = []string{path}
+
+	log, err := cfg.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Debug("debug entry")
+	log.Info("info entry")
+	log.Error("error entry")
+	_ = log.Sync()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestBuild(t *testing.T) {
+	log, err := Build("debug")
+	assert.NoError(t, err)
+	assert.True(t, log.Desugar().Core().Enabled(-1))
+
+	_, err = Build("verbose")
+	assert.Error(t, err)
+}
+
+func TestConfigBuild(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Fields = map[string]string{"service": "api", "environment": "test"}
+	cfg.StacktraceLevel = "error"
+	cfg.TimeEncoding = TimeRFC3339
+
+	lines := buildAndLog(t, cfg)
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+
+	var info, errEntry map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &info))
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &errEntry))
+
+	assert.Equal(t, "info entry", info["msg"])
+	assert.Equal(t, "api", info["service"])
+	assert.Equal(t, "test", info["environment"])
+	assert.Regexp(t, `^\d{4}-\d{2}-\d{2}T`, info["ts"])
+	assert.NotContains(t, info, "stacktrace")
+	assert.Contains(t, errEntry, "stacktrace")
+}
+
+func TestConfigBuildConsole(t *testing.T) {
+	lines := buildAndLog(t, Config{Level: "debug", Encoding: EncodingConsole})
+	if !assert.Len(t, lines, 3) {
+		return
+	}
+	assert.Contains(t, lines[0], "\tdebug\t")
+	assert.True(t, strings.HasSuffix(lines[0], "\tdebug entry"))
+}
+
+func TestConfigBuildErrors(t *testing.T) {
+	type testcase struct {
+		name string
+		cfg  Config
+	}
+
+	testcases := []testcase{
+		{name: "level", cfg: Config{Level: "verbose"}},
+		{name: "encoding", cfg: Config{Encoding: "xml"}},
+		{name: "time encoding", cfg: Config{TimeEncoding: "unix"}},
+		{name: "stacktrace level", cfg: Config{StacktraceLevel: "sometimes"}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.cfg.Build()
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	type testcase struct {
+		name    string
+		env     map[string]string
+		want    Config
+		wantErr bool
+	}
+
+	testcases := []testcase{
+		{name: "defaults", want: DefaultConfig()},
+		{
+			name: "all settings",
+			env: map[string]string{
+				"TEST_LOG_LEVEL":            "debug",
+				"TEST_LOG_FORMAT":           "console",
+				"TEST_LOG_OUTPUT":           "stdout, /var/log/api.log",
+				"TEST_LOG_ERROR_OUTPUT":     "stdout",
+				"TEST_LOG_SAMPLING":         "10,5",
+				"TEST_LOG_STACKTRACE_LEVEL": "error",
+				"TEST_LOG_FIELDS":           "service=api, version=1.2.0",
+				"TEST_LOG_TIME_ENCODING":    "rfc3339",
+			},
+			want: Config{
+				Level:            "debug",
+				Encoding:         EncodingConsole,
+				OutputPaths:      []string{"stdout", "/var/log/api.log"},
+				ErrorOutputPaths: []string{"stdout"},
+				Sampling:         &SamplingConfig{Initial: 10, Thereafter: 5},
+				StacktraceLevel:  "error",
+				Fields:           map[string]string{"service": "api", "version": "1.2.0"},
+				TimeEncoding:     TimeRFC3339,
+			},
+		},
+		{
+			name: "development",
+			env:  map[string]string{"TEST_LOG_DEVELOPMENT": "true", "TEST_LOG_SAMPLING": "off"},
+			want: Config{Level: "info", OutputPaths: []string{"stderr"}, Development: true},
+		},
+		{name: "invalid sampling", env: map[string]string{"TEST_LOG_SAMPLING": "10"}, wantErr: true},
+		{name: "invalid fields", env: map[string]string{"TEST_LOG_FIELDS": "service"}, wantErr: true},
+		{name: "invalid development", env: map[string]string{"TEST_LOG_DEVELOPMENT": "maybe"}, wantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			got, err := ConfigFromEnv("TEST_")
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// combinedTimeFormat is the timestamp format used by the Apache combined log format.
+const combinedTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// writeCombined writes a line in the Apache combined log format:
+//
+//	host ident user [time] "request line" status size "referer" "user agent"
+//
+// The query and referer are passed in with sensitive parameters already redacted.
+func writeCombined(w io.Writer, r *http.Request, query, referer string, start time.Time, status int, size int, uid string) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	target := r.URL.EscapedPath()
+	if query != "" {
+		target += "?" + query
+	}
+
+	bytes := "-"
+	if size > 0 {
+		bytes = strconv.Itoa(size)
+	}
+
+	var b strings.Builder
+	b.WriteString(orDash(host))
+	b.WriteString(" - ")
+	b.WriteString(orDash(uid))
+	b.WriteString(" [" + start.Format(combinedTimeFormat) + "] ")
+	b.WriteString(`"` + escapeQuoted(r.Method+" "+target+" "+r.Proto) + `" `)
+	b.WriteString(strconv.Itoa(status) + " " + bytes + " ")
+	b.WriteString(`"` + escapeQuoted(orDash(referer)) + `" `)
+	b.WriteString(`"` + escapeQuoted(orDash(r.UserAgent())) + `"`)
+	b.WriteString("\n")
+
+	// errors aren't returned, as there's nowhere to report them.
+	_, _ = io.WriteString(w, b.String())
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+var quotedEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+
+// escapeQuoted escapes a value so it can't break out of a quoted field.
+func escapeQuoted(s string) string {
+	return quotedEscaper.Replace(s)
+}
@@ -0,0 +1,103 @@
+package logger
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Fields which can be included in the "Served" entry logged by Middleware.
+const (
+	FieldProto  = "proto"
+	FieldRemote = "remote"
+	// FieldRequest is the request path and query string, with sensitive parameters redacted.
+	FieldRequest = "request"
+	FieldPath    = "path"
+	// FieldQuery is the query string, with sensitive parameters redacted.
+	FieldQuery  = "query"
+	FieldMethod = "method"
+	// FieldRoute is the chi route pattern which matched the request, such as "/users/{id}".
+	FieldRoute     = "route"
+	FieldTook      = "took"
+	FieldStatus    = "status"
+	FieldSize      = "size"
+	FieldRequestID = "reqId"
+	FieldUserID    = "userId"
+	FieldUserAgent = "userAgent"
+	FieldReferer   = "referer"
+	// FieldContentLength is the length of the request body, or -1 if it's unknown.
+	FieldContentLength = "contentLength"
+	// FieldErrorKind is the kind of error sent by apio.Error, such as "not_found", if there was one.
+	FieldErrorKind = "errorKind"
+)
+
+// DefaultFields are the fields logged if Options.Fields isn't set.
+var DefaultFields = []string{
+	FieldProto,
+	FieldRemote,
+	FieldRequest,
+	FieldMethod,
+	FieldRoute,
+	FieldTook,
+	FieldStatus,
+	FieldSize,
+	FieldRequestID,
+	FieldUserID,
+	FieldUserAgent,
+	FieldReferer,
+	FieldContentLength,
+	FieldErrorKind,
+}
+
+// DefaultRedactedParams are the query parameters redacted if Options.RedactedParams isn't set.
+var DefaultRedactedParams = []string{
+	"access_token",
+	"api_key",
+	"apikey",
+	"code",
+	"key",
+	"password",
+	"secret",
+	"signature",
+	"token",
+}
+
+// redacted replaces the values of redacted query parameters.
+const redacted = "REDACTED"
+
+// redactQuery replaces the values of the parameters in a raw query string, keeping the order
+// of the parameters. Parameter names are compared case-insensitively.
+func redactQuery(rawQuery string, params map[string]bool) string {
+	if rawQuery == "" || len(params) == 0 {
+		return rawQuery
+	}
+
+	parts := strings.Split(rawQuery, "&")
+	for i, part := range parts {
+		key := part
+		if j := strings.IndexByte(part, '='); j >= 0 {
+			key = part[:j]
+		}
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if params[strings.ToLower(name)] {
+			parts[i] = key + "=" + redacted
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// redactURL redacts the query string of a URL, such as a Referer header, with redactQuery.
+func redactURL(rawURL string, params map[string]bool) string {
+	before, query, ok := strings.Cut(rawURL, "?")
+	if !ok {
+		return rawURL
+	}
+	query, fragment, hasFragment := strings.Cut(query, "#")
+	redactedURL := before + "?" + redactQuery(query, params)
+	if hasFragment {
+		redactedURL += "#" + fragment
+	}
+	return redactedURL
+}
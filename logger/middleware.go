@@ -2,10 +2,18 @@ package logger
 
 import (
 	"context"
+	"io"
+	"math/rand"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/common-fate/apikit/errhandler"
+	"github.com/common-fate/apikit/requestid"
 	"github.com/common-fate/apikit/userid"
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -13,14 +21,130 @@ import (
 
 var logCtxKey = &contextKey{"log"}
 
+// accessLogKey holds the *accessLog for the request, which is
+// filled in while the request is handled.
+var accessLogKey = &contextKey{"accessLog"}
+
 type contextKey struct {
 	name string
 }
 
+// accessLog holds details of the request which are only known once it has been handled.
+// It's guarded by a mutex as handlers may outlive the request, such as after a timeout.
+type accessLog struct {
+	mu        sync.Mutex
+	errorKind string
+}
+
+// Format is the style of the entries logged by Middleware.
+type Format int
+
+const (
+	// FormatStructured logs a "Served" entry with the logger, with a field for each detail of the request.
+	FormatStructured Format = iota
+	// FormatCombined writes a line in the Apache combined log format to Options.Writer,
+	// for tools which expect web server access logs.
+	FormatCombined
+)
+
+// DefaultMessage is the message of entries logged by Middleware if Options.Message isn't set.
+const DefaultMessage = "Served"
+
+// Options customise the logging middleware.
+type Options struct {
+	// Fields are the fields included in the "Served" entry, such as FieldRoute.
+	// If nil, DefaultFields is used.
+	Fields []string
+	// RedactedParams are query parameters whose values are replaced with "REDACTED"
+	// in logs, both in the request and in the Referer header. If nil, DefaultRedactedParams is used.
+	RedactedParams []string
+
+	// SkipPaths are URL paths which aren't logged, such as health checks.
+	SkipPaths []string
+	// Skip returns true for requests which shouldn't be logged.
+	// It's called after the request has been handled.
+	Skip func(r *http.Request) bool
+	// SampleRates are the fraction of requests logged for each chi route pattern, from 0 to 1.
+	// Routes which aren't listed are always logged. Only requests logged at the info level
+	// or below are sampled, so errors and slow requests are always logged.
+	SampleRates map[string]float64
+
+	// Level returns the level to log a request at, given the response status.
+	// If nil, all requests are logged at the info level. StatusLevel logs client
+	// errors as warnings and server errors as errors.
+	Level func(status int) zapcore.Level
+	// SlowThreshold is the duration after which requests are logged at the warn
+	// level or above, with a "slow" field. If zero, requests aren't considered slow.
+	SlowThreshold time.Duration
+	// Message is the message of each entry. If empty, DefaultMessage is used.
+	Message string
+
+	// Bodies enables logging of request and response bodies and headers. If nil,
+	// they aren't logged. Bodies are only logged in FormatStructured.
+	Bodies *BodyOptions
+
+	// Format is the style of the entries. Fields, Bodies, Level and Message only apply to FormatStructured.
+	Format Format
+	// Writer receives the lines written in FormatCombined, and must be safe for concurrent
+	// use. If nil, os.Stdout is used.
+	Writer io.Writer
+}
+
+// StatusLevel returns the error level for 5xx statuses, the warn level
+// for 4xx statuses and the info level otherwise.
+func StatusLevel(status int) zapcore.Level {
+	switch {
+	case status >= 500:
+		return zapcore.ErrorLevel
+	case status >= 400:
+		return zapcore.WarnLevel
+	}
+	return zapcore.InfoLevel
+}
+
 // Middleware is a middleware that logs the start and end of each request, along
 // with some useful data about what was requested, what the response status was,
 // and how long it took to return.
+//
+// It logs DefaultFields. Use MiddlewareWithOptions to choose the fields.
 func Middleware(l *zap.Logger) func(next http.Handler) http.Handler {
+	return MiddlewareWithOptions(l, nil)
+}
+
+// MiddlewareWithOptions is Middleware with options to customise the logged fields.
+// Options may be nil to use the defaults.
+func MiddlewareWithOptions(l *zap.Logger, options *Options) func(next http.Handler) http.Handler {
+	var opts Options
+	if options != nil {
+		opts = *options
+	}
+	if opts.Fields == nil {
+		opts.Fields = DefaultFields
+	}
+	if opts.RedactedParams == nil {
+		opts.RedactedParams = DefaultRedactedParams
+	}
+
+	if opts.Level == nil {
+		opts.Level = func(status int) zapcore.Level { return zapcore.InfoLevel }
+	}
+	if opts.Message == "" {
+		opts.Message = DefaultMessage
+	}
+	if opts.Writer == nil {
+		opts.Writer = os.Stdout
+	}
+
+	redactedParams := make(map[string]bool, len(opts.RedactedParams))
+	for _, p := range opts.RedactedParams {
+		redactedParams[strings.ToLower(p)] = true
+	}
+	bodies := newBodyLogger(opts.Bodies)
+	skipPaths := make(map[string]bool, len(opts.SkipPaths))
+	for _, p := range opts.SkipPaths {
+		skipPaths[p] = true
+	}
+
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
@@ -28,7 +152,8 @@ func Middleware(l *zap.Logger) func(next http.Handler) http.Handler {
 			t1 := time.Now()
 
 			ctx := r.Context()
-			reqID := middleware.GetReqID(ctx)
+			// requestid.Get falls back to the ID from chi's middleware.RequestID.
+			reqID := requestid.Get(ctx)
 
 			// add the logger to context, so that logger.Get() can be used to retrieve it in
 			// API endpoints.
@@ -38,29 +163,125 @@ func Middleware(l *zap.Logger) func(next http.Handler) http.Handler {
 			// children middleware further down the stack can write the user ID to it.
 			ctx = userid.Init(ctx)
 
+			al := &accessLog{}
+			ctx = context.WithValue(ctx, accessLogKey, al)
+
 			r = r.WithContext(ctx)
 
+			var captured *bodyCapture
+			if bodies != nil && opts.Format == FormatStructured {
+				captured = bodies.capture(r, ww)
+			}
+
 			defer func() {
-				fields := []zapcore.Field{
-					zap.String("proto", r.Proto),
-					zap.String("remote", r.RemoteAddr),
-					zap.String("request", r.RequestURI),
-					zap.String("method", r.Method),
-					zap.Duration("took", time.Since(t1)),
-					zap.Int("status", ww.Status()),
-					zap.Int("size", ww.BytesWritten()),
-					zap.String("reqId", reqID),
+				took := time.Since(t1)
+				if skipPaths[r.URL.Path] || (opts.Skip != nil && opts.Skip(r)) {
+					return
+				}
+
+				status := ww.Status()
+				if status == 0 {
+					// the handler didn't write anything, so the server sends a 200 response.
+					status = http.StatusOK
+				}
+
+				// chi fills in the route pattern as the request is routed.
+				var route string
+				if rctx := chi.RouteContext(ctx); rctx != nil {
+					route = rctx.RoutePattern()
+				}
+
+				level := opts.Level(status)
+				slow := opts.SlowThreshold > 0 && took > opts.SlowThreshold
+				if slow && level < zapcore.WarnLevel {
+					level = zapcore.WarnLevel
+				}
+				if rate, ok := opts.SampleRates[route]; ok && level <= zapcore.InfoLevel && rand.Float64() >= rate {
+					return
+				}
+
+				query := redactQuery(r.URL.RawQuery, redactedParams)
+				referer := redactURL(r.Referer(), redactedParams)
+
+				if opts.Format == FormatCombined {
+					writeCombined(opts.Writer, r, query, referer, t1, status, ww.BytesWritten(), userid.Get(ctx))
+					return
 				}
 
-				// get the user ID from the request context.
-				// Authentication middleware may run *after* our logging
-				// middleware, so we call it after next.ServeHTTP is complete.
-				uid := userid.Get(ctx)
-				if uid != "" {
-					fields = append(fields, zap.String("userId", uid))
+				ce := l.Check(level, opts.Message)
+				if ce == nil {
+					return
 				}
 
-				l.Info("Served", fields...)
+				fields := make([]zapcore.Field, 0, len(opts.Fields)+1)
+
+				for _, f := range opts.Fields {
+					switch f {
+					case FieldProto:
+						fields = append(fields, zap.String(f, r.Proto))
+					case FieldRemote:
+						fields = append(fields, zap.String(f, r.RemoteAddr))
+					case FieldRequest:
+						request := r.URL.EscapedPath()
+						if query != "" {
+							request += "?" + query
+						}
+						fields = append(fields, zap.String(f, request))
+					case FieldPath:
+						fields = append(fields, zap.String(f, r.URL.Path))
+					case FieldQuery:
+						if query != "" {
+							fields = append(fields, zap.String(f, query))
+						}
+					case FieldMethod:
+						fields = append(fields, zap.String(f, r.Method))
+					case FieldRoute:
+						if route != "" {
+							fields = append(fields, zap.String(f, route))
+						}
+					case FieldTook:
+						fields = append(fields, zap.Duration(f, took))
+					case FieldStatus:
+						fields = append(fields, zap.Int(f, status))
+					case FieldSize:
+						fields = append(fields, zap.Int(f, ww.BytesWritten()))
+					case FieldRequestID:
+						fields = append(fields, zap.String(f, reqID))
+					case FieldUserID:
+						// get the user ID from the request context.
+						// Authentication middleware may run *after* our logging
+						// middleware, so we call it after next.ServeHTTP is complete.
+						if uid := userid.Get(ctx); uid != "" {
+							fields = append(fields, zap.String(f, uid))
+						}
+					case FieldUserAgent:
+						if ua := r.UserAgent(); ua != "" {
+							fields = append(fields, zap.String(f, ua))
+						}
+					case FieldReferer:
+						if referer != "" {
+							fields = append(fields, zap.String(f, referer))
+						}
+					case FieldContentLength:
+						fields = append(fields, zap.Int64(f, r.ContentLength))
+					case FieldErrorKind:
+						al.mu.Lock()
+						kind := al.errorKind
+						al.mu.Unlock()
+						if kind != "" {
+							fields = append(fields, zap.String(f, kind))
+						}
+					}
+				}
+
+				if slow {
+					fields = append(fields, zap.Bool("slow", true))
+				}
+				if captured != nil {
+					fields = append(fields, bodies.fields(captured, r, ww.Header())...)
+				}
+
+				ce.Write(fields...)
 			}()
 
 			next.ServeHTTP(ww, r)
@@ -69,6 +290,23 @@ func Middleware(l *zap.Logger) func(next http.Handler) http.Handler {
 	}
 }
 
+func init() {
+	errhandler.Observe(func(ctx context.Context, e *errhandler.Event) {
+		SetErrorKind(ctx, e.Kind)
+	})
+}
+
+// SetErrorKind records the kind of error sent in response to the request, to be logged
+// by Middleware. It's registered with errhandler.Observe, so it's called by apio.Error.
+// It does nothing if Middleware isn't in use.
+func SetErrorKind(ctx context.Context, kind string) {
+	if al, ok := ctx.Value(accessLogKey).(*accessLog); ok {
+		al.mu.Lock()
+		defer al.mu.Unlock()
+		al.errorKind = kind
+	}
+}
+
 // Get returns the logger in context, if there is one.
 // If there isn't, it returns the global logger.
 func Get(ctx context.Context) *zap.SugaredLogger {
@@ -1,12 +1,16 @@
 package logger
 
 import (
+	"bytes"
 	"context"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/common-fate/apikit/requestid"
 	"github.com/common-fate/apikit/userid"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -58,6 +62,264 @@ func TestMiddlewareUserID(t *testing.T) {
 	}
 }
 
+func TestMiddlewareRequestID(t *testing.T) {
+	observed, logs := observer.New(zapcore.InfoLevel)
+
+	r := chi.NewRouter()
+	r.Use(requestid.Middleware(nil))
+	r.Use(Middleware(zap.New(observed)))
+
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(requestid.DefaultHeader, "req-456")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Contains(t, logs.All()[0].Context, zap.String("reqId", "req-456"))
+}
+
+func TestMiddlewareFields(t *testing.T) {
+	type testcase struct {
+		name    string
+		options *Options
+		target  string
+		want    []zapcore.Field
+		notWant []string
+	}
+
+	testcases := []testcase{
+		{
+			name:   "default fields",
+			target: "/users/usr_1?token=abc&page=2",
+			want: []zapcore.Field{
+				zap.String("request", "/users/usr_1?token=REDACTED&page=2"),
+				zap.String("route", "/users/{id}"),
+				zap.String("userAgent", "test-agent"),
+				zap.String("referer", "https://example.com/callback?code=REDACTED&state=1"),
+				zap.Int64("contentLength", 4),
+				zap.String("errorKind", "not_found"),
+				zap.Int("status", http.StatusNotFound),
+			},
+			notWant: []string{"path", "query"},
+		},
+		{
+			name:    "chosen fields",
+			options: &Options{Fields: []string{FieldPath, FieldQuery, FieldRoute}},
+			target:  "/users/usr_1?token=abc&page=2",
+			want: []zapcore.Field{
+				zap.String("path", "/users/usr_1"),
+				zap.String("query", "token=REDACTED&page=2"),
+				zap.String("route", "/users/{id}"),
+			},
+			notWant: []string{"request", "status", "userAgent", "errorKind"},
+		},
+		{
+			name:    "custom redaction",
+			options: &Options{Fields: []string{FieldRequest}, RedactedParams: []string{"Page"}},
+			target:  "/users/usr_1?token=abc&page=2",
+			want:    []zapcore.Field{zap.String("request", "/users/usr_1?token=abc&page=REDACTED")},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			observed, logs := observer.New(zapcore.InfoLevel)
+
+			r := chi.NewRouter()
+			r.Use(MiddlewareWithOptions(zap.New(observed), tc.options))
+			r.Post("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+				SetErrorKind(r.Context(), "not_found")
+				w.WriteHeader(http.StatusNotFound)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader("body"))
+			req.Header.Set("User-Agent", "test-agent")
+			req.Header.Set("Referer", "https://example.com/callback?code=abc&state=1")
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			entry := logs.All()[0]
+			for _, w := range tc.want {
+				assert.Contains(t, entry.Context, w)
+			}
+			got := entry.ContextMap()
+			for _, key := range tc.notWant {
+				assert.NotContains(t, got, key)
+			}
+		})
+	}
+}
+
+func TestMiddlewareOptions(t *testing.T) {
+	type testcase struct {
+		name      string
+		options   *Options
+		path      string
+		wantLogs  int
+		wantLevel zapcore.Level
+		wantMsg   string
+		wantSlow  bool
+	}
+
+	testcases := []testcase{
+		{name: "defaults", path: "/users/usr_1", wantLogs: 1, wantLevel: zapcore.InfoLevel, wantMsg: "Served"},
+		{name: "errors logged at info by default", path: "/broken", wantLogs: 1, wantLevel: zapcore.InfoLevel, wantMsg: "Served"},
+		{name: "skip paths", options: &Options{SkipPaths: []string{"/health"}}, path: "/health", wantLogs: 0},
+		{
+			name:     "skip predicate",
+			options:  &Options{Skip: func(r *http.Request) bool { return r.Method == http.MethodGet }},
+			path:     "/users/usr_1",
+			wantLogs: 0,
+		},
+		{name: "custom message", options: &Options{Message: "request"}, path: "/users/usr_1", wantLogs: 1, wantLevel: zapcore.InfoLevel, wantMsg: "request"},
+		{name: "server error level", options: &Options{Level: StatusLevel}, path: "/broken", wantLogs: 1, wantLevel: zapcore.ErrorLevel, wantMsg: "Served"},
+		{name: "client error level", options: &Options{Level: StatusLevel}, path: "/missing", wantLogs: 1, wantLevel: zapcore.WarnLevel, wantMsg: "Served"},
+		{name: "level below logger level", options: &Options{Level: func(int) zapcore.Level { return zapcore.DebugLevel }}, path: "/users/usr_1", wantLogs: 0},
+		{name: "sampled out", options: &Options{SampleRates: map[string]float64{"/users/{id}": 0}}, path: "/users/usr_1", wantLogs: 0},
+		{name: "sampled in", options: &Options{SampleRates: map[string]float64{"/users/{id}": 1}}, path: "/users/usr_1", wantLogs: 1, wantLevel: zapcore.InfoLevel, wantMsg: "Served"},
+		{name: "other routes aren't sampled", options: &Options{SampleRates: map[string]float64{"/users/{id}": 0}}, path: "/health", wantLogs: 1, wantLevel: zapcore.InfoLevel, wantMsg: "Served"},
+		{
+			name:      "errors aren't sampled",
+			options:   &Options{Level: StatusLevel, SampleRates: map[string]float64{"/broken": 0}},
+			path:      "/broken",
+			wantLogs:  1,
+			wantLevel: zapcore.ErrorLevel,
+			wantMsg:   "Served",
+		},
+		{
+			name:      "slow request",
+			options:   &Options{SlowThreshold: time.Millisecond, SampleRates: map[string]float64{"/slow": 0}},
+			path:      "/slow",
+			wantLogs:  1,
+			wantLevel: zapcore.WarnLevel,
+			wantMsg:   "Served",
+			wantSlow:  true,
+		},
+		{
+			name:      "slow request doesn't lower level",
+			options:   &Options{Level: func(int) zapcore.Level { return zapcore.ErrorLevel }, SlowThreshold: time.Millisecond},
+			path:      "/slow",
+			wantLogs:  1,
+			wantLevel: zapcore.ErrorLevel,
+			wantMsg:   "Served",
+			wantSlow:  true,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			observed, logs := observer.New(zapcore.InfoLevel)
+
+			r := chi.NewRouter()
+			r.Use(MiddlewareWithOptions(zap.New(observed), tc.options))
+			r.Get("/health", func(w http.ResponseWriter, r *http.Request) {})
+			r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+			r.Get("/missing", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			})
+			r.Get("/broken", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			})
+			r.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(5 * time.Millisecond)
+			})
+
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if !assert.Equal(t, tc.wantLogs, logs.Len()) || tc.wantLogs == 0 {
+				return
+			}
+			entry := logs.All()[0]
+			assert.Equal(t, tc.wantLevel, entry.Level)
+			assert.Equal(t, tc.wantMsg, entry.Message)
+			_, slow := entry.ContextMap()["slow"]
+			assert.Equal(t, tc.wantSlow, slow)
+		})
+	}
+}
+
+func TestMiddlewareCombinedFormat(t *testing.T) {
+	observed, logs := observer.New(zapcore.InfoLevel)
+	var buf bytes.Buffer
+
+	r := chi.NewRouter()
+	r.Use(MiddlewareWithOptions(zap.New(observed), &Options{Format: FormatCombined, Writer: &buf}))
+	r.With(testUserID).Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	})
+	r.Get("/empty", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/usr_1?token=abc", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("Referer", "https://example.com/?token=abc")
+	req.Header.Set("User-Agent", `agent "quoted"`)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/empty", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+	assert.Regexp(t, `^192\.0\.2\.1 - usr-123 \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /users/usr_1\?token=REDACTED HTTP/1\.1" 200 5 "https://example\.com/\?token=REDACTED" "agent \\"quoted\\""$`, lines[0])
+	assert.Regexp(t, `^192\.0\.2\.1 - - \[.+\] "GET /empty HTTP/1\.1" 200 - "-" "-"$`, lines[1])
+
+	// nothing is logged with the zap logger.
+	assert.Equal(t, 0, logs.Len())
+}
+
+func TestRedactQuery(t *testing.T) {
+	type testcase struct {
+		name string
+		give string
+		want string
+	}
+
+	params := map[string]bool{"token": true, "api_key": true}
+
+	testcases := []testcase{
+		{name: "empty", give: "", want: ""},
+		{name: "no sensitive params", give: "page=2&sort=name", want: "page=2&sort=name"},
+		{name: "sensitive param", give: "token=abc&page=2", want: "token=REDACTED&page=2"},
+		{name: "case insensitive", give: "TOKEN=abc", want: "TOKEN=REDACTED"},
+		{name: "escaped name", give: "api%5Fkey=abc", want: "api%5Fkey=REDACTED"},
+		{name: "no value", give: "token&page=2", want: "token=REDACTED&page=2"},
+		{name: "repeated", give: "token=a&token=b", want: "token=REDACTED&token=REDACTED"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, redactQuery(tc.give, params))
+		})
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	type testcase struct {
+		name string
+		give string
+		want string
+	}
+
+	params := map[string]bool{"token": true}
+
+	testcases := []testcase{
+		{name: "empty", give: "", want: ""},
+		{name: "no query", give: "https://example.com/a", want: "https://example.com/a"},
+		{name: "query", give: "https://example.com/a?token=abc&page=2", want: "https://example.com/a?token=REDACTED&page=2"},
+		{name: "fragment", give: "https://example.com/a?token=abc#top", want: "https://example.com/a?token=REDACTED#top"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, redactURL(tc.give, params))
+		})
+	}
+}
+
 func testRequestInfo(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
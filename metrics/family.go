@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// family is a metric and all of its series, one for each combination of label values.
+type family struct {
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	// value is the value of counters and gauges.
+	value float64
+	// counts hold the number of observations in each histogram bucket, not cumulatively.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newFamily(name, help string, typ metricType, labels []string, buckets []float64) *family {
+	return &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+}
+
+// get returns the series for the label values, creating it if it doesn't exist.
+// It must be called with the lock held.
+func (f *family) get(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: labelValues}
+		if f.typ == histogramType {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// add adds v to a counter or gauge.
+func (f *family) add(v float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(labelValues).value += v
+}
+
+// observe records v in a histogram.
+func (f *family) observe(v float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.get(labelValues)
+	s.sum += v
+	s.count++
+	for i, b := range f.buckets {
+		if v <= b {
+			s.counts[i]++
+			break
+		}
+	}
+}
+
+// write writes the family in the Prometheus text exposition format.
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.WriteString("# HELP " + f.name + " " + f.help + "\n")
+	w.WriteString("# TYPE " + f.name + " " + string(f.typ) + "\n")
+
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := f.series[k]
+		if f.typ != histogramType {
+			writeSample(w, f.name, f.labels, s.labelValues, s.value)
+			continue
+		}
+
+		labels := append(append([]string(nil), f.labels...), "le")
+		values := append(append([]string(nil), s.labelValues...), "")
+		var cumulative uint64
+		for i, b := range f.buckets {
+			cumulative += s.counts[i]
+			values[len(values)-1] = formatFloat(b)
+			writeSample(w, f.name+"_bucket", labels, values, float64(cumulative))
+		}
+		values[len(values)-1] = "+Inf"
+		writeSample(w, f.name+"_bucket", labels, values, float64(s.count))
+		writeSample(w, f.name+"_sum", f.labels, s.labelValues, s.sum)
+		writeSample(w, f.name+"_count", f.labels, s.labelValues, float64(s.count))
+	}
+}
+
+// writeSample writes a line of the exposition format.
+func writeSample(w *bufio.Writer, name string, labels, values []string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l + `="` + escapeLabel(values[i]) + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeFamilies writes the families to w, returning any error from writing.
+func writeFamilies(w io.Writer, families []*family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFamilies(t *testing.T) {
+	counter := newFamily("requests_total", "Total requests.", counterType, []string{"path"}, nil)
+	counter.add(1, "/b")
+	counter.add(2, `/a"\`+"\n")
+
+	gauge := newFamily("in_flight", "Requests in flight.", gaugeType, nil, nil)
+	gauge.add(1)
+	gauge.add(-1)
+
+	histogram := newFamily("latency_seconds", "Latency.", histogramType, []string{"path"}, []float64{0.1, 1})
+	histogram.observe(0.05, "/a")
+	histogram.observe(0.5, "/a")
+	histogram.observe(5, "/a")
+
+	var buf bytes.Buffer
+	err := writeFamilies(&buf, []*family{counter, gauge, histogram})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP requests_total Total requests.
+# TYPE requests_total counter
+requests_total{path="/a\"\\\n"} 2
+requests_total{path="/b"} 1
+# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 0
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{path="/a",le="0.1"} 1
+latency_seconds_bucket{path="/a",le="1"} 2
+latency_seconds_bucket{path="/a",le="+Inf"} 3
+latency_seconds_sum{path="/a"} 5.55
+latency_seconds_count{path="/a"} 3
+`
+	assert.Equal(t, want, buf.String())
+}
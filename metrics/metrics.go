@@ -0,0 +1,217 @@
+// Package metrics provides middleware which records request metrics, and an http.Handler
+// which serves them in the Prometheus text exposition format.
+//
+// The following metrics are recorded:
+//
+//	http_requests_total            counter   requests by method, route and status class
+//	http_request_duration_seconds  histogram request latency by method, route and status class
+//	http_requests_in_flight        gauge     requests being handled by method and route
+//	http_errors_total              counter   errors sent by apio.Error, by kind
+//
+// Routes are labelled with the chi route pattern, such as "/users/{id}", rather than the
+// request path, so that the number of series doesn't grow with the number of users.
+// Requests which don't match a route are labelled "unmatched".
+//
+//	m := metrics.New(nil)
+//	r.Use(m.Middleware)
+//	r.Method(http.MethodGet, "/metrics", m.Handler())
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/common-fate/apikit/errhandler"
+	"github.com/common-fate/apikit/logger"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultMaxRoutes is the most route labels recorded if Options.MaxRoutes isn't set.
+	DefaultMaxRoutes = 500
+	// UnmatchedRoute is the route label of requests which don't match a route.
+	UnmatchedRoute = "unmatched"
+	// OtherLabel is used in place of non-standard methods, and routes over the MaxRoutes limit.
+	OtherLabel = "other"
+)
+
+// DefaultBuckets are the latency histogram buckets, in seconds, used if Options.Buckets isn't set.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var metricsKey = &contextKey{"metrics"}
+
+type contextKey struct {
+	name string
+}
+
+// methods are the request methods used as labels. Other methods are labelled OtherLabel,
+// as clients can send requests with any method.
+var methods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// Options customise the metrics.
+type Options struct {
+	// Namespace is prefixed to metric names, such as "myapi" for "myapi_http_requests_total".
+	Namespace string
+	// Buckets are the upper bounds of the latency histogram buckets, in seconds, in increasing
+	// order. If nil, DefaultBuckets is used.
+	Buckets []float64
+	// MaxRoutes is the most route labels recorded. Requests for further routes are
+	// labelled OtherLabel. If zero, DefaultMaxRoutes is used.
+	MaxRoutes int
+}
+
+// Metrics records request metrics. It's safe for concurrent use.
+type Metrics struct {
+	opts Options
+
+	requests *family
+	duration *family
+	inFlight *family
+	errors   *family
+
+	mu     sync.Mutex
+	routes map[string]bool
+}
+
+// New returns Metrics with no recorded values. Options may be nil to use the defaults.
+func New(options *Options) *Metrics {
+	var opts Options
+	if options != nil {
+		opts = *options
+	}
+	if opts.Buckets == nil {
+		opts.Buckets = DefaultBuckets
+	}
+	if opts.MaxRoutes == 0 {
+		opts.MaxRoutes = DefaultMaxRoutes
+	}
+
+	prefix := ""
+	if opts.Namespace != "" {
+		prefix = opts.Namespace + "_"
+	}
+
+	return &Metrics{
+		opts:     opts,
+		requests: newFamily(prefix+"http_requests_total", "Total number of HTTP requests.", counterType, []string{"method", "route", "status"}, nil),
+		duration: newFamily(prefix+"http_request_duration_seconds", "HTTP request latency in seconds.", histogramType, []string{"method", "route", "status"}, opts.Buckets),
+		inFlight: newFamily(prefix+"http_requests_in_flight", "Number of HTTP requests being handled.", gaugeType, []string{"method", "route"}, nil),
+		errors:   newFamily(prefix+"http_errors_total", "Total number of errors sent by apio.Error.", counterType, []string{"kind"}, nil),
+		routes:   map[string]bool{},
+	}
+}
+
+// Middleware records metrics for each request. It should be added to the router
+// with Use, so that it can find the route pattern for each request.
+//
+// Errors sent by apio.Error are only counted if they're sent after this middleware runs.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		method := r.Method
+		if !methods[method] {
+			method = OtherLabel
+		}
+		route := m.route(r)
+
+		m.inFlight.add(1, method, route)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		defer func() {
+			p := recover()
+
+			status := ww.Status()
+			if p != nil {
+				status = http.StatusInternalServerError
+			} else if status == 0 {
+				// the handler didn't write anything, so the server sends a 200 response.
+				status = http.StatusOK
+			}
+			class := strconv.Itoa(status/100) + "xx"
+
+			m.inFlight.add(-1, method, route)
+			m.requests.add(1, method, route, class)
+			m.duration.observe(time.Since(start).Seconds(), method, route, class)
+
+			if p != nil {
+				panic(p)
+			}
+		}()
+
+		ctx := context.WithValue(r.Context(), metricsKey, m)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// route returns the route label for a request. The route is found before the request is
+// handled, rather than from the route context afterwards, so that it can label the in-flight gauge.
+func (m *Metrics) route(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return UnmatchedRoute
+	}
+
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+	match := chi.NewRouteContext()
+	if !rctx.Routes.Match(match, r.Method, path) {
+		return UnmatchedRoute
+	}
+	pattern := match.RoutePattern()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.routes[pattern] {
+		return pattern
+	}
+	if len(m.routes) >= m.opts.MaxRoutes {
+		return OtherLabel
+	}
+	m.routes[pattern] = true
+	return pattern
+}
+
+// Handler returns a handler which serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) Handler() http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		err := writeFamilies(w, []*family{m.requests, m.duration, m.inFlight, m.errors})
+		if err != nil {
+			logger.Get(r.Context()).Errorw("writing metrics", zap.Error(err))
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+func init() {
+	errhandler.Observe(func(ctx context.Context, e *errhandler.Event) {
+		RecordError(ctx, e.Kind)
+	})
+}
+
+// RecordError counts an error against the Metrics in the context, if there are any.
+// It's registered with errhandler.Observe, so apio.Error calls it with apio.ErrorKind
+// for every error it sends.
+func RecordError(ctx context.Context, kind string) {
+	if m, ok := ctx.Value(metricsKey).(*Metrics); ok {
+		m.errors.add(1, kind)
+	}
+}
@@ -0,0 +1,110 @@
+package metrics_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/common-fate/apikit/apio"
+	"github.com/common-fate/apikit/metrics"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRouter returns a router with metrics and some test routes.
+func newRouter(m *metrics.Metrics) http.Handler {
+	r := chi.NewRouter()
+	r.Use(m.Middleware)
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Route("/admin", func(r chi.Router) {
+		r.Post("/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		})
+	})
+	r.Get("/missing/{id}", func(w http.ResponseWriter, r *http.Request) {
+		apio.ErrorString(r.Context(), w, "not found", http.StatusNotFound)
+	})
+	r.Get("/broken", func(w http.ResponseWriter, r *http.Request) {
+		apio.Error(r.Context(), w, errors.New("database unavailable"))
+	})
+	r.Method(http.MethodGet, "/metrics", m.Handler())
+	return r
+}
+
+func send(h http.Handler, method, path string) {
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+}
+
+func scrape(t *testing.T, h http.Handler) string {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rr.Header().Get("Content-Type"))
+	body, err := ioutil.ReadAll(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMiddleware(t *testing.T) {
+	m := metrics.New(&metrics.Options{Buckets: []float64{60}})
+	h := newRouter(m)
+
+	send(h, http.MethodGet, "/users/usr_1")
+	send(h, http.MethodGet, "/users/usr_2")
+	send(h, http.MethodPost, "/admin/jobs/1")
+	send(h, http.MethodGet, "/missing/1")
+	send(h, http.MethodGet, "/broken")
+	send(h, http.MethodGet, "/not-a-route")
+	send(h, "BREW", "/users/usr_1")
+
+	got := scrape(t, h)
+
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/users/{id}",status="2xx"} 2`,
+		`http_requests_total{method="POST",route="/admin/jobs/{id}",status="2xx"} 1`,
+		`http_requests_total{method="GET",route="/missing/{id}",status="4xx"} 1`,
+		`http_requests_total{method="GET",route="/broken",status="5xx"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="4xx"} 1`,
+		`http_requests_total{method="other",route="unmatched",status="4xx"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/users/{id}",status="2xx",le="60"} 2`,
+		`http_request_duration_seconds_count{method="GET",route="/users/{id}",status="2xx"} 2`,
+		`http_requests_in_flight{method="GET",route="/users/{id}"} 0`,
+		// the scrape is in flight while the metrics are written.
+		`http_requests_in_flight{method="GET",route="/metrics"} 1`,
+		`http_errors_total{kind="not_found"} 1`,
+		`http_errors_total{kind="internal"} 1`,
+	} {
+		assert.Contains(t, got, line+"\n")
+	}
+}
+
+func TestMaxRoutes(t *testing.T) {
+	m := metrics.New(&metrics.Options{MaxRoutes: 1, Namespace: "test"})
+	h := newRouter(m)
+
+	send(h, http.MethodGet, "/users/usr_1")
+	send(h, http.MethodGet, "/broken")
+
+	got := scrape(t, h)
+	assert.Contains(t, got, `test_http_requests_total{method="GET",route="/users/{id}",status="2xx"} 1`+"\n")
+	assert.Contains(t, got, `test_http_requests_total{method="GET",route="other",status="5xx"} 1`+"\n")
+}
+
+func TestPanic(t *testing.T) {
+	m := metrics.New(nil)
+	r := chi.NewRouter()
+	r.Use(m.Middleware)
+	r.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	assert.Panics(t, func() { send(r, http.MethodGet, "/panic") })
+
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rr.Body.String(), `http_requests_total{method="GET",route="/panic",status="5xx"} 1`+"\n")
+	assert.Contains(t, rr.Body.String(), `http_requests_in_flight{method="GET",route="/panic"} 0`+"\n")
+}
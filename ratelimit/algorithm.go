@@ -0,0 +1,184 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// Limit is the number of requests allowed in a period.
+type Limit struct {
+	// Requests is the number of requests allowed per Period.
+	Requests int
+	// Period is the length of time the requests are allowed in.
+	Period time.Duration
+	// Burst is the most requests the TokenBucket algorithm allows at once.
+	// If zero, Requests is used. It isn't used by SlidingWindow.
+	Burst int
+}
+
+// PerSecond returns a Limit of n requests per second.
+func PerSecond(n int) Limit {
+	return Limit{Requests: n, Period: time.Second}
+}
+
+// PerMinute returns a Limit of n requests per minute.
+func PerMinute(n int) Limit {
+	return Limit{Requests: n, Period: time.Minute}
+}
+
+// PerHour returns a Limit of n requests per hour.
+func PerHour(n int) Limit {
+	return Limit{Requests: n, Period: time.Hour}
+}
+
+// Result is the outcome of checking a request against a limit.
+type Result struct {
+	// Allowed is true if the request may proceed.
+	Allowed bool
+	// Limit is the number of requests allowed at once.
+	Limit int
+	// Remaining is the number of requests which can be made immediately after this one.
+	Remaining int
+	// Reset is how long until the full limit is available again.
+	Reset time.Duration
+	// RetryAfter is how long until another request will be allowed, if this one wasn't.
+	RetryAfter time.Duration
+}
+
+// Algorithm decides whether a request is allowed. Algorithms are stateless: the state for
+// each key is held by a Store, so the same algorithm can be used with any Store.
+type Algorithm interface {
+	// Allow checks a request against the limit, given the key's state from the store,
+	// which is nil for a new key. It returns the new state, and how long the state
+	// needs to be kept for.
+	Allow(state []byte, limit Limit, now time.Time) (newState []byte, ttl time.Duration, result Result, err error)
+}
+
+// TokenBucket is an Algorithm which refills a bucket of Burst tokens at a rate of Requests
+// per Period. Each request takes a token, so clients can make bursts of requests
+// after being idle while being held to the average rate over time.
+type TokenBucket struct{}
+
+type tokenBucketState struct {
+	Tokens float64 `json:"t"`
+	// Updated is when Tokens was calculated, in Unix nanoseconds.
+	Updated int64 `json:"u"`
+}
+
+// Allow implements Algorithm.
+func (TokenBucket) Allow(state []byte, limit Limit, now time.Time) ([]byte, time.Duration, Result, error) {
+	capacity := float64(limit.Burst)
+	if limit.Burst == 0 {
+		capacity = float64(limit.Requests)
+	}
+	// tokens per nanosecond.
+	rate := float64(limit.Requests) / float64(limit.Period)
+
+	s := tokenBucketState{Tokens: capacity, Updated: now.UnixNano()}
+	if state != nil {
+		if err := json.Unmarshal(state, &s); err != nil {
+			return nil, 0, Result{}, err
+		}
+		elapsed := float64(now.UnixNano() - s.Updated)
+		if elapsed > 0 {
+			s.Tokens = math.Min(capacity, s.Tokens+elapsed*rate)
+		}
+		s.Updated = now.UnixNano()
+	}
+
+	res := Result{Limit: int(capacity)}
+	if s.Tokens >= 1 {
+		s.Tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration(math.Ceil((1 - s.Tokens) / rate))
+	}
+	res.Remaining = int(math.Floor(s.Tokens))
+	res.Reset = time.Duration(math.Ceil((capacity - s.Tokens) / rate))
+
+	newState, err := json.Marshal(s)
+	if err != nil {
+		return nil, 0, Result{}, err
+	}
+	// once the bucket is full, the state is the same as a new key.
+	return newState, res.Reset, res, nil
+}
+
+// SlidingWindow is an Algorithm which allows Requests per Period, counting requests
+// over a window which slides with the current time rather than resetting at fixed intervals.
+//
+// The count is approximated from the number of requests in the current and previous
+// fixed windows, weighting the previous window by how much of it overlaps the sliding
+// window, so only two counters are stored per key.
+type SlidingWindow struct{}
+
+type slidingWindowState struct {
+	// Start is when the current fixed window started, in Unix nanoseconds.
+	Start    int64 `json:"s"`
+	Current  int   `json:"c"`
+	Previous int   `json:"p"`
+}
+
+// Allow implements Algorithm.
+func (SlidingWindow) Allow(state []byte, limit Limit, now time.Time) ([]byte, time.Duration, Result, error) {
+	period := int64(limit.Period)
+	nowNano := now.UnixNano()
+	start := nowNano - nowNano%period
+
+	var s slidingWindowState
+	if state != nil {
+		if err := json.Unmarshal(state, &s); err != nil {
+			return nil, 0, Result{}, err
+		}
+	}
+	switch {
+	case s.Start == start:
+	case s.Start == start-period:
+		s.Previous, s.Current = s.Current, 0
+	default:
+		s.Previous, s.Current = 0, 0
+	}
+	s.Start = start
+
+	// the share of the previous window which overlaps the sliding window.
+	elapsed := nowNano - start
+	weight := 1 - float64(elapsed)/float64(period)
+	count := float64(s.Previous)*weight + float64(s.Current)
+
+	res := Result{Limit: limit.Requests}
+	if count+1 <= float64(limit.Requests) {
+		s.Current++
+		count++
+		res.Allowed = true
+	} else {
+		res.RetryAfter = s.retryAfter(limit, elapsed)
+	}
+	res.Remaining = int(math.Max(0, math.Floor(float64(limit.Requests)-count)))
+	res.Reset = time.Duration(period - elapsed)
+	if s.Previous > 0 || s.Current > 0 {
+		// requests in this window still count until the end of the next one.
+		res.Reset += limit.Period
+	}
+
+	newState, err := json.Marshal(s)
+	if err != nil {
+		return nil, 0, Result{}, err
+	}
+	return newState, time.Duration(2*period - elapsed), res, nil
+}
+
+// retryAfter estimates how long until the weighted count has fallen enough for another request.
+func (s slidingWindowState) retryAfter(limit Limit, elapsed int64) time.Duration {
+	period := float64(limit.Period)
+	if s.Previous > 0 && s.Current+1 <= limit.Requests {
+		// the weight at which previous*weight + current + 1 = requests.
+		weight := float64(limit.Requests-s.Current-1) / float64(s.Previous)
+		wait := (1-weight)*period - float64(elapsed)
+		if wait > 0 {
+			return time.Duration(math.Ceil(wait))
+		}
+	}
+	// wait for the next window, when the current window's requests start to expire.
+	return time.Duration(int64(period) - elapsed)
+}
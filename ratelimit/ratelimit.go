@@ -0,0 +1,163 @@
+// Package ratelimit provides rate limiting middleware.
+//
+// Each Middleware has its own limit, so different limits can be applied to different
+// routes by adding middleware to route groups:
+//
+//	store := ratelimit.NewMemoryStore()
+//
+//	r.Use(ratelimit.Middleware(ratelimit.Options{Limit: ratelimit.PerMinute(600), Store: store}))
+//	r.With(ratelimit.Middleware(ratelimit.Options{Limit: ratelimit.PerMinute(10), Store: store})).Post("/exports", createExport)
+//
+// Responses include RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+// Requests over the limit receive a 429 response through apio.Error, with a Retry-After header.
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/common-fate/apikit/apio"
+	"github.com/common-fate/apikit/logger"
+	"github.com/common-fate/apikit/serr"
+	"github.com/common-fate/apikit/userid"
+	"go.uber.org/zap"
+)
+
+// KeyFunc returns the key a request is rate limited by. Requests with
+// the same key share a limit. If the key is empty, the request isn't limited.
+type KeyFunc func(r *http.Request) string
+
+// ByUserID limits requests by the user ID from userid.Get. The rate limiting middleware
+// must be added after any authentication middleware which calls userid.Set.
+func ByUserID(r *http.Request) string {
+	return userid.Get(r.Context())
+}
+
+// ByIP limits requests by the client IP address, from http.Request.RemoteAddr. If the service
+// is behind a proxy, use middleware such as chi's middleware.RealIP to set the client address.
+func ByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ByHeader limits requests by the value of a header, such as an API key.
+// Values are hashed so they aren't held in the store.
+func ByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		v := r.Header.Get(name)
+		if v == "" {
+			return ""
+		}
+		sum := sha256.Sum256([]byte(v))
+		return hex.EncodeToString(sum[:])
+	}
+}
+
+// ByUserIDOrIP limits requests by user ID, or by IP address for unauthenticated requests.
+func ByUserIDOrIP(r *http.Request) string {
+	if uid := ByUserID(r); uid != "" {
+		return "user:" + uid
+	}
+	return "ip:" + ByIP(r)
+}
+
+// Options customise the rate limiting middleware.
+type Options struct {
+	// Limit is the rate requests are limited to.
+	Limit Limit
+	// Algorithm decides whether requests are allowed. If nil, TokenBucket is used.
+	Algorithm Algorithm
+	// Store holds the state for each key. If nil, a new MemoryStore is used.
+	Store Store
+	// Key returns the key requests are limited by. If nil, ByUserIDOrIP is used.
+	Key KeyFunc
+	// Name identifies the limit in the store. Middleware with the same name and store
+	// share their counts. If empty, the middleware doesn't share counts with any other.
+	Name string
+}
+
+// instances is used to give each Middleware without a Name its own keys.
+var instances int64
+
+// Middleware returns middleware which limits the rate of requests.
+//
+// If the store returns an error, it's logged and the request is allowed, so that
+// an unavailable store doesn't take the service down with it.
+func Middleware(opts Options) func(next http.Handler) http.Handler {
+	if opts.Limit.Requests <= 0 || opts.Limit.Period <= 0 {
+		panic("ratelimit: Limit must have a positive number of requests and period")
+	}
+	if opts.Algorithm == nil {
+		opts.Algorithm = TokenBucket{}
+	}
+	if opts.Store == nil {
+		opts.Store = NewMemoryStore()
+	}
+	if opts.Key == nil {
+		opts.Key = ByUserIDOrIP
+	}
+	if opts.Name == "" {
+		opts.Name = "limit" + strconv.FormatInt(atomic.AddInt64(&instances, 1), 10)
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := opts.Key(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			res, err := allow(ctx, opts, opts.Name+":"+key)
+			if err != nil {
+				logger.Get(ctx).Errorw("checking rate limit", zap.Error(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			setHeaders(w, opts.Limit, res)
+			if !res.Allowed {
+				apio.Error(ctx, w, serr.TooManyRequests(res.RetryAfter))
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func allow(ctx context.Context, opts Options, key string) (Result, error) {
+	var res Result
+	err := opts.Store.Update(ctx, key, func(state []byte) ([]byte, time.Duration, error) {
+		newState, ttl, r, err := opts.Algorithm.Allow(state, opts.Limit, time.Now())
+		res = r
+		return newState, ttl, err
+	})
+	return res, err
+}
+
+// setHeaders sets the RateLimit-* headers from the IETF RateLimit header fields draft.
+func setHeaders(w http.ResponseWriter, limit Limit, res Result) {
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, seconds(limit.Period)))
+}
+
+// seconds rounds a duration up to whole seconds, as used in rate limiting headers.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
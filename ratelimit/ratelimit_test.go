@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/common-fate/apikit/userid"
+	"github.com/stretchr/testify/assert"
+)
+
+// run sends requests to an algorithm at the given offsets from a fixed start time,
+// returning the results.
+func run(t *testing.T, alg Algorithm, limit Limit, offsets ...time.Duration) []Result {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	var state []byte
+	var results []Result
+	for _, o := range offsets {
+		newState, ttl, res, err := alg.Allow(state, limit, start.Add(o))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Greater(t, ttl, time.Duration(0))
+		state = newState
+		results = append(results, res)
+	}
+	return results
+}
+
+func TestTokenBucket(t *testing.T) {
+	limit := Limit{Requests: 2, Period: time.Second, Burst: 3}
+	got := run(t, TokenBucket{}, limit, 0, 0, 0, 0, 500*time.Millisecond, 500*time.Millisecond, 2*time.Second)
+
+	assert.Equal(t, []Result{
+		{Allowed: true, Limit: 3, Remaining: 2, Reset: 500 * time.Millisecond},
+		{Allowed: true, Limit: 3, Remaining: 1, Reset: time.Second},
+		{Allowed: true, Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond},
+		{Allowed: false, Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond},
+		// a token has been added after 500ms.
+		{Allowed: true, Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond},
+		{Allowed: false, Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond},
+		// the bucket is full again.
+		{Allowed: true, Limit: 3, Remaining: 2, Reset: 500 * time.Millisecond},
+	}, got)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	limit := PerMinute(4)
+	got := run(t, SlidingWindow{}, limit,
+		30*time.Second, 30*time.Second, 40*time.Second, 50*time.Second, 55*time.Second,
+		// 75% of the previous window overlaps, so 3 requests are counted.
+		75*time.Second,
+		// 50% of the previous window overlaps, so 2 + 1 requests are counted.
+		90*time.Second,
+		// 4 * 25/60 + 2 requests are counted, which leaves no room.
+		95*time.Second,
+		150*time.Second,
+	)
+
+	assert.Equal(t, []Result{
+		{Allowed: true, Limit: 4, Remaining: 3, Reset: 90 * time.Second},
+		{Allowed: true, Limit: 4, Remaining: 2, Reset: 90 * time.Second},
+		{Allowed: true, Limit: 4, Remaining: 1, Reset: 80 * time.Second},
+		{Allowed: true, Limit: 4, Remaining: 0, Reset: 70 * time.Second},
+		{Allowed: false, Limit: 4, Remaining: 0, Reset: 65 * time.Second, RetryAfter: 5 * time.Second},
+		{Allowed: true, Limit: 4, Remaining: 0, Reset: 105 * time.Second},
+		{Allowed: true, Limit: 4, Remaining: 0, Reset: 90 * time.Second},
+		{Allowed: false, Limit: 4, Remaining: 0, Reset: 85 * time.Second, RetryAfter: 10 * time.Second},
+		{Allowed: true, Limit: 4, Remaining: 2, Reset: 90 * time.Second},
+	}, got)
+}
+
+func TestMiddleware(t *testing.T) {
+	h := Middleware(Options{Limit: PerMinute(2), Key: ByIP})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	send := func(ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = ip + ":1234"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+
+	rr := send("10.0.0.1")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", rr.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=60", rr.Header().Get("RateLimit-Policy"))
+
+	assert.Equal(t, http.StatusNoContent, send("10.0.0.1").Code)
+
+	rr = send("10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error":"Too Many Requests"}`, rr.Body.String())
+
+	// other clients have their own limit.
+	assert.Equal(t, http.StatusNoContent, send("10.0.0.2").Code)
+}
+
+func TestMiddlewareSharedStore(t *testing.T) {
+	store := NewMemoryStore()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	a := Middleware(Options{Limit: PerMinute(1), Store: store, Key: ByIP})(ok)
+	b := Middleware(Options{Limit: PerMinute(1), Store: store, Key: ByIP})(ok)
+	shared := Middleware(Options{Limit: PerMinute(1), Store: store, Key: ByIP, Name: "a"})(ok)
+	sharedToo := Middleware(Options{Limit: PerMinute(1), Store: store, Key: ByIP, Name: "a"})(ok)
+
+	send := func(h http.Handler) int {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send(a))
+	assert.Equal(t, http.StatusOK, send(b))
+	assert.Equal(t, http.StatusOK, send(shared))
+	assert.Equal(t, http.StatusTooManyRequests, send(sharedToo))
+}
+
+type failingStore struct{}
+
+func (failingStore) Update(ctx context.Context, key string, fn func([]byte) ([]byte, time.Duration, error)) error {
+	return errors.New("store unavailable")
+}
+
+func TestMiddlewareStoreErrorAllowsRequest(t *testing.T) {
+	h := Middleware(Options{Limit: PerMinute(1), Store: failingStore{}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("RateLimit-Limit"))
+}
+
+func TestKeyFuncs(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	assert.Equal(t, "192.0.2.1", ByIP(r))
+	assert.Equal(t, "", ByUserID(r))
+	assert.Equal(t, "ip:192.0.2.1", ByUserIDOrIP(r))
+	assert.Equal(t, "", ByHeader("X-API-Key")(r))
+
+	r.Header.Set("X-API-Key", "secret")
+	assert.Len(t, ByHeader("X-API-Key")(r), 64)
+	assert.NotContains(t, ByHeader("X-API-Key")(r), "secret")
+
+	r = r.WithContext(userid.Set(r.Context(), "usr_1"))
+	assert.Equal(t, "usr_1", ByUserID(r))
+	assert.Equal(t, "user:usr_1", ByUserIDOrIP(r))
+}
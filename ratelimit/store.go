@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store holds the rate limiting state for each key. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Update atomically reads the state for a key, which is nil if there is none, calls fn
+	// with it and saves the state fn returns, which should expire after the returned ttl.
+	// If fn returns an error, the state is left unchanged and the error is returned.
+	Update(ctx context.Context, key string, fn func(state []byte) (newState []byte, ttl time.Duration, err error)) error
+}
+
+// sweepInterval is how often MemoryStore removes expired state.
+const sweepInterval = time.Minute
+
+// MemoryStore is a Store which holds state in memory.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryEntry struct {
+	state   []byte
+	expires time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: map[string]memoryEntry{},
+		now:     time.Now,
+	}
+}
+
+// Update implements Store.
+func (s *MemoryStore) Update(ctx context.Context, key string, fn func(state []byte) ([]byte, time.Duration, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, e := range s.entries {
+			if !now.Before(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	var state []byte
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		state = e.state
+	}
+
+	newState, ttl, err := fn(state)
+	if err != nil {
+		return err
+	}
+	s.entries[key] = memoryEntry{state: newState, expires: now.Add(ttl)}
+	return nil
+}
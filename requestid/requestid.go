@@ -0,0 +1,217 @@
+// Package requestid provides middleware which gives each request an ID, and propagates
+// W3C Trace Context (https://www.w3.org/TR/trace-context/) headers.
+//
+// The request ID is taken from the request header if the client sent a valid one, and
+// generated otherwise. It's echoed in the response header, included in logs written by
+// logger.Middleware, and included in error responses written by apio.Error.
+//
+// The middleware should be added before logger.Middleware:
+//
+//	r.Use(requestid.Middleware(nil))
+//	r.Use(logger.Middleware(log))
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/common-fate/apikit/errhandler"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	// DefaultHeader is the header the request ID is read from and written to if Options.Header isn't set.
+	DefaultHeader = "X-Request-Id"
+	// MaxLength is the longest incoming request ID accepted by Valid.
+	MaxLength = 128
+)
+
+var requestIDKey = &contextKey{"requestID"}
+
+type contextKey struct {
+	name string
+}
+
+// requestID is stored in the context, along with the header
+// it was received in so that Propagate can use the same one.
+type requestID struct {
+	id     string
+	header string
+}
+
+func init() {
+	// include the request ID in error responses so clients can quote it when reporting problems.
+	errhandler.Observe(func(ctx context.Context, e *errhandler.Event) {
+		e.RequestID = Get(ctx)
+	})
+}
+
+// Get returns the request ID from the context. If the requestid middleware hasn't been
+// used, it returns the ID set by chi's middleware.RequestID, or an empty string.
+func Get(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if rid, ok := ctx.Value(requestIDKey).(requestID); ok {
+		return rid.id
+	}
+	return middleware.GetReqID(ctx)
+}
+
+// Set the request ID in the context. It's also set for chi's middleware.GetReqID.
+func Set(ctx context.Context, id string) context.Context {
+	return set(ctx, id, DefaultHeader)
+}
+
+func set(ctx context.Context, id string, header string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, requestID{id: id, header: header})
+	return context.WithValue(ctx, middleware.RequestIDKey, id)
+}
+
+// Propagate sets the request ID and trace context headers from the context on an outgoing request,
+// so that downstream services can correlate their logs and traces with this request.
+func Propagate(ctx context.Context, h http.Header) {
+	if rid, ok := ctx.Value(requestIDKey).(requestID); ok {
+		h.Set(rid.header, rid.id)
+	}
+	if tc, ok := GetTrace(ctx); ok {
+		h.Set(TraceparentHeader, tc.Traceparent())
+		if tc.State != "" {
+			h.Set(TracestateHeader, tc.State)
+		}
+	}
+}
+
+// Generator returns a new request ID.
+type Generator func() string
+
+// ULID returns a new ULID (https://github.com/ulid/spec), a 26 character ID
+// which sorts by the time it was generated.
+func ULID() string {
+	var b [16]byte
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(b[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:], uint32(ms))
+	randomBytes(b[6:])
+	return encodeCrockford(b)
+}
+
+// UUID returns a new random (version 4) UUID.
+func UUID() string {
+	var b [16]byte
+	randomBytes(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], b[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], b[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], b[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], b[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], b[10:])
+	return string(buf[:])
+}
+
+func randomBytes(b []byte) {
+	// crypto/rand only fails if the OS can't provide randomness,
+	// in which case there's nothing sensible to fall back to.
+	if _, err := rand.Read(b); err != nil {
+		panic("requestid: reading random bytes: " + err.Error())
+	}
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// encodeCrockford encodes 128 bits as 26 characters of Crockford's base32,
+// with the 2 leading bits of the encoding always zero.
+func encodeCrockford(b [16]byte) string {
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// Valid reports whether id can be used as a request ID. It must be between 1 and MaxLength
+// characters, containing only ASCII letters, digits, '-', '_', '.' and ':'.
+func Valid(id string) bool {
+	if len(id) == 0 || len(id) > MaxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Options customise the request ID middleware.
+type Options struct {
+	// Header is the header the request ID is read from and written to. If empty, DefaultHeader is used.
+	Header string
+	// Generator returns new request IDs. If nil, ULID is used.
+	Generator Generator
+	// Validate reports whether an incoming request ID should be used. Invalid IDs are replaced
+	// with a generated one. If nil, Valid is used.
+	Validate func(id string) bool
+	// IgnoreIncoming generates a new ID for every request, ignoring any ID sent by the client.
+	IgnoreIncoming bool
+}
+
+// Middleware returns middleware which sets the request ID and trace context in the
+// request context, and sets the request ID header on the response.
+// Options may be nil to use the defaults.
+//
+// A traceparent header which doesn't follow the W3C Trace Context format is ignored,
+// along with any tracestate header sent with it.
+func Middleware(options *Options) func(next http.Handler) http.Handler {
+	var opts Options
+	if options != nil {
+		opts = *options
+	}
+	if opts.Header == "" {
+		opts.Header = DefaultHeader
+	}
+	if opts.Generator == nil {
+		opts.Generator = ULID
+	}
+	if opts.Validate == nil {
+		opts.Validate = Valid
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(opts.Header)
+			if opts.IgnoreIncoming || !opts.Validate(id) {
+				id = opts.Generator()
+			}
+
+			ctx := set(r.Context(), id, opts.Header)
+			if tc, ok := Extract(r.Header); ok {
+				ctx = SetTrace(ctx, tc)
+			}
+
+			w.Header().Set(opts.Header, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
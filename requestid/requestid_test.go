@@ -0,0 +1,119 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	type testcase struct {
+		name       string
+		options    *Options
+		incoming   string
+		wantID     string
+		wantHeader string
+	}
+
+	generate := func() string { return "generated" }
+
+	testcases := []testcase{
+		{name: "generated", options: &Options{Generator: generate}, wantID: "generated"},
+		{name: "incoming", options: &Options{Generator: generate}, incoming: "abc-123", wantID: "abc-123"},
+		{name: "invalid incoming", options: &Options{Generator: generate}, incoming: "abc 123", wantID: "generated"},
+		{name: "ignore incoming", options: &Options{Generator: generate, IgnoreIncoming: true}, incoming: "abc-123", wantID: "generated"},
+		{
+			name:     "custom validation",
+			options:  &Options{Generator: generate, Validate: func(id string) bool { return len(id) == 3 }},
+			incoming: "abc-123",
+			wantID:   "generated",
+		},
+		{
+			name:       "custom header",
+			options:    &Options{Generator: generate, Header: "X-Correlation-Id"},
+			incoming:   "abc-123",
+			wantID:     "abc-123",
+			wantHeader: "X-Correlation-Id",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := tc.wantHeader
+			if header == "" {
+				header = DefaultHeader
+			}
+
+			var gotID, gotChiID string
+			h := Middleware(tc.options)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotID = Get(r.Context())
+				gotChiID = middleware.GetReqID(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.incoming != "" {
+				r.Header.Set(header, tc.incoming)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, r)
+
+			assert.Equal(t, tc.wantID, gotID)
+			assert.Equal(t, tc.wantID, gotChiID)
+			assert.Equal(t, tc.wantID, rr.Header().Get(header))
+		})
+	}
+}
+
+func TestGetFallsBackToChi(t *testing.T) {
+	ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "chi-id")
+	assert.Equal(t, "chi-id", Get(ctx))
+	assert.Equal(t, "", Get(context.Background()))
+}
+
+func TestGenerators(t *testing.T) {
+	ulid := ULID()
+	assert.Regexp(t, regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`), ulid)
+	assert.True(t, Valid(ulid))
+	assert.NotEqual(t, ulid, ULID())
+
+	uuid := UUID()
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), uuid)
+	assert.True(t, Valid(uuid))
+}
+
+func TestEncodeCrockford(t *testing.T) {
+	var b [16]byte
+	assert.Equal(t, "00000000000000000000000000", encodeCrockford(b))
+
+	for i := range b {
+		b[i] = 0xff
+	}
+	assert.Equal(t, "7ZZZZZZZZZZZZZZZZZZZZZZZZZ", encodeCrockford(b))
+
+	b = [16]byte{15: 0x21}
+	assert.Equal(t, "00000000000000000000000011", encodeCrockford(b))
+}
+
+func TestPropagate(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Correlation-Id", "abc-123")
+	r.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Set(TracestateHeader, "congo=t61rcWkgMzE")
+
+	out := http.Header{}
+	h := Middleware(&Options{Header: "X-Correlation-Id"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Propagate(r.Context(), out)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Equal(t, http.Header{
+		"X-Correlation-Id": {"abc-123"},
+		"Traceparent":      {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		"Tracestate":       {"congo=t61rcWkgMzE"},
+	}, out)
+}
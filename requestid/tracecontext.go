@@ -0,0 +1,171 @@
+package requestid
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	// TraceparentHeader identifies the incoming request in a tracing system.
+	TraceparentHeader = "traceparent"
+	// TracestateHeader carries vendor-specific trace information alongside traceparent.
+	TracestateHeader = "tracestate"
+
+	// maxTracestateMembers is the most list members allowed in tracestate by the specification.
+	maxTracestateMembers = 32
+	// traceparentLength is the length of a version 00 traceparent header.
+	traceparentLength = 55
+)
+
+var traceKey = &contextKey{"trace"}
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// String returns the trace ID in lowercase hex.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid reports whether the trace ID isn't all zeros.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// String returns the span ID in lowercase hex.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid reports whether the span ID isn't all zeros.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// FlagSampled is set in TraceContext.Flags when the caller may have recorded the trace.
+const FlagSampled byte = 0x01
+
+// TraceContext is the W3C Trace Context of a request.
+type TraceContext struct {
+	TraceID TraceID
+	// SpanID is the ID of the caller's span, called parent-id in the specification.
+	SpanID SpanID
+	Flags  byte
+	// State is the tracestate header, which is propagated without being interpreted.
+	State string
+}
+
+// Sampled reports whether the caller may have recorded the trace.
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&FlagSampled != 0
+}
+
+// IsValid reports whether the trace and span IDs are valid.
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID.IsValid() && tc.SpanID.IsValid()
+}
+
+// Traceparent returns the trace context formatted as a version 00 traceparent header.
+func (tc TraceContext) Traceparent() string {
+	return "00-" + tc.TraceID.String() + "-" + tc.SpanID.String() + "-" + hex.EncodeToString([]byte{tc.Flags})
+}
+
+var errInvalidTraceparent = errors.New("invalid traceparent header")
+
+// ParseTraceparent parses a traceparent header. Headers from future versions of the
+// specification are accepted as long as they start with the fields defined in version 00.
+func ParseTraceparent(s string) (TraceContext, error) {
+	var tc TraceContext
+	s = strings.TrimSpace(s)
+	if len(s) < traceparentLength {
+		return tc, errInvalidTraceparent
+	}
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return tc, errInvalidTraceparent
+	}
+
+	var version [1]byte
+	if !decodeHex(version[:], s[0:2]) || version[0] == 0xff {
+		return tc, errInvalidTraceparent
+	}
+	if version[0] == 0 && len(s) != traceparentLength {
+		return tc, errInvalidTraceparent
+	}
+	if len(s) > traceparentLength && s[traceparentLength] != '-' {
+		return tc, errInvalidTraceparent
+	}
+
+	var flags [1]byte
+	if !decodeHex(tc.TraceID[:], s[3:35]) || !decodeHex(tc.SpanID[:], s[36:52]) || !decodeHex(flags[:], s[53:55]) {
+		return TraceContext{}, errInvalidTraceparent
+	}
+	tc.Flags = flags[0]
+	if !tc.IsValid() {
+		return TraceContext{}, errInvalidTraceparent
+	}
+	return tc, nil
+}
+
+// decodeHex decodes lowercase hex into dst, which must be exactly half the length of s.
+func decodeHex(dst []byte, s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	n, err := hex.Decode(dst, []byte(s))
+	return err == nil && n == len(dst)
+}
+
+// parseTracestate combines tracestate headers into a single list. If there are more list
+// members than the specification allows, or a member has no value, the header is dropped.
+func parseTracestate(values []string) string {
+	var members []string
+	for _, v := range values {
+		for _, m := range strings.Split(v, ",") {
+			m = strings.TrimSpace(m)
+			if m == "" {
+				continue
+			}
+			if !strings.Contains(m, "=") {
+				return ""
+			}
+			members = append(members, m)
+		}
+	}
+	if len(members) > maxTracestateMembers {
+		return ""
+	}
+	return strings.Join(members, ",")
+}
+
+// Extract reads the trace context from the traceparent and tracestate headers.
+// It returns false if there isn't a valid traceparent header.
+func Extract(h http.Header) (TraceContext, bool) {
+	tc, err := ParseTraceparent(h.Get(TraceparentHeader))
+	if err != nil {
+		return TraceContext{}, false
+	}
+	tc.State = parseTracestate(h.Values(TracestateHeader))
+	return tc, true
+}
+
+// GetTrace returns the trace context from the context, if there is one.
+func GetTrace(ctx context.Context) (TraceContext, bool) {
+	if ctx == nil {
+		return TraceContext{}, false
+	}
+	tc, ok := ctx.Value(traceKey).(TraceContext)
+	return tc, ok
+}
+
+// SetTrace sets the trace context in the context.
+func SetTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceKey, tc)
+}
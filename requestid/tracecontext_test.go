@@ -0,0 +1,94 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	type testcase struct {
+		name    string
+		give    string
+		want    string
+		sampled bool
+		wantErr bool
+	}
+
+	testcases := []testcase{
+		{name: "sampled", give: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", want: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sampled: true},
+		{name: "not sampled", give: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", want: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		{name: "surrounding whitespace", give: " 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 ", want: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sampled: true},
+		{name: "future version", give: "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what-the-future-holds", want: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sampled: true},
+		{name: "empty", give: "", wantErr: true},
+		{name: "forbidden version", give: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "version 00 with extra fields", give: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", wantErr: true},
+		{name: "future version without separator", give: "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01extra", wantErr: true},
+		{name: "uppercase", give: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "zero trace ID", give: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", wantErr: true},
+		{name: "zero span ID", give: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", wantErr: true},
+		{name: "wrong separator", give: "00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseTraceparent(tc.give)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got.Traceparent())
+			assert.Equal(t, tc.sampled, got.Sampled())
+		})
+	}
+}
+
+func TestParseTracestate(t *testing.T) {
+	assert.Equal(t, "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE", parseTracestate([]string{"rojo=00f067aa0ba902b7, ,", "congo=t61rcWkgMzE"}))
+	assert.Equal(t, "", parseTracestate([]string{"rojo"}))
+	assert.Equal(t, "", parseTracestate(nil))
+}
+
+func TestMiddlewareTrace(t *testing.T) {
+	type testcase struct {
+		name        string
+		traceparent string
+		tracestate  string
+		wantOK      bool
+		wantState   string
+	}
+
+	testcases := []testcase{
+		{name: "valid", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", tracestate: "congo=t61rcWkgMzE", wantOK: true, wantState: "congo=t61rcWkgMzE"},
+		{name: "none", wantOK: false},
+		{name: "invalid traceparent drops tracestate", traceparent: "invalid", tracestate: "congo=t61rcWkgMzE", wantOK: false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got TraceContext
+			var ok bool
+			h := Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, ok = GetTrace(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.traceparent != "" {
+				r.Header.Set(TraceparentHeader, tc.traceparent)
+			}
+			if tc.tracestate != "" {
+				r.Header.Set(TracestateHeader, tc.tracestate)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			assert.Equal(t, tc.wantOK, ok)
+			if tc.wantOK {
+				assert.Equal(t, tc.traceparent, got.Traceparent())
+				assert.Equal(t, tc.wantState, got.State)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package serr
 
 import (
 	"net/http"
+	"time"
 )
 
 type NotFoundError struct{}
@@ -69,3 +70,22 @@ func IsBadRequest(err error) bool {
 	_, ok := err.(BadRequestError)
 	return ok
 }
+
+type TooManyRequestsError struct {
+	// RetryAfter is how long the client should wait before retrying, if known.
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface.
+func (e TooManyRequestsError) Error() string {
+	return http.StatusText(http.StatusTooManyRequests)
+}
+
+func TooManyRequests(retryAfter time.Duration) error {
+	return TooManyRequestsError{RetryAfter: retryAfter}
+}
+
+func IsTooManyRequests(err error) bool {
+	_, ok := err.(TooManyRequestsError)
+	return ok
+}
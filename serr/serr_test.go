@@ -1,6 +1,9 @@
 package serr
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestIsBadRequest(t *testing.T) {
 	type args struct {
@@ -27,3 +30,36 @@ func TestIsBadRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestIsTooManyRequests(t *testing.T) {
+	type args struct {
+		err error
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			name: "ok",
+			args: args{
+				err: TooManyRequests(time.Second),
+			},
+			want: true,
+		},
+		{
+			name: "other error",
+			args: args{
+				err: BadRequest("hello"),
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTooManyRequests(tt.args.err); got != tt.want {
+				t.Errorf("IsTooManyRequests() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// Package timeout provides middleware which limits how long requests can take.
+//
+// Unlike http.TimeoutHandler, timed out requests receive a JSON error response
+// through apio.Error, and responses are streamed to the client as they're written
+// rather than being buffered.
+//
+// Use it on route groups to set different deadlines for different routes:
+//
+//	r.Use(timeout.Middleware(10*time.Second, nil))
+//	r.With(timeout.Middleware(time.Minute, nil)).Post("/exports", createExport)
+package timeout
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/common-fate/apikit/apio"
+	"github.com/common-fate/apikit/logger"
+	"go.uber.org/zap"
+)
+
+// Options customise the timeout middleware.
+type Options struct {
+	// StatusCode is the status sent when a request times out. It should be either
+	// http.StatusServiceUnavailable or http.StatusGatewayTimeout.
+	// If zero, http.StatusServiceUnavailable is used.
+	StatusCode int
+	// Message is the error message sent when a request times out.
+	// If empty, "request timed out" is used.
+	Message string
+}
+
+// Middleware returns middleware which cancels the request context after the timeout.
+// Options may be nil to use the defaults.
+//
+// Handlers should stop work when the context is cancelled. If a handler hasn't started
+// writing its response when the deadline passes, the client is sent an error through
+// apio.Error and anything the handler writes afterwards is discarded, with Write returning
+// http.ErrHandlerTimeout. If it has started writing, the middleware waits for it to finish.
+// If the client disconnects, the middleware returns straight away and later writes are discarded.
+//
+// Panics in the handler are propagated, unless the middleware has already returned,
+// in which case they're logged.
+func Middleware(timeout time.Duration, options *Options) func(next http.Handler) http.Handler {
+	var opts Options
+	if options != nil {
+		opts = *options
+	}
+	if opts.StatusCode == 0 {
+		opts.StatusCode = http.StatusServiceUnavailable
+	}
+	if opts.Message == "" {
+		opts.Message = "request timed out"
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			tw := &timeoutWriter{w: w, header: w.Header().Clone()}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						tw.panicked(ctx, p, panicked)
+					}
+				}()
+				next.ServeHTTP(tw, r)
+				close(done)
+			}()
+
+			select {
+			case <-done:
+				tw.finish()
+				return
+			case p := <-panicked:
+				panic(p)
+			case <-ctx.Done():
+			}
+
+			// the parent context was cancelled, such as when the client disconnects.
+			// Any further writes are discarded, as the handler may still be running
+			// once the middleware returns.
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				tw.stop()
+				repanic(panicked)
+				return
+			}
+
+			if !tw.timeout() {
+				// the handler has already started its response, so it's left to finish it.
+				select {
+				case <-done:
+				case p := <-panicked:
+					panic(p)
+				}
+				return
+			}
+			repanic(panicked)
+
+			logger.Get(ctx).Warnw("request timed out", zap.Duration("timeout", timeout), zap.String("method", r.Method), zap.String("path", r.URL.Path))
+			apio.Error(ctx, w, apio.NewRequestError(errors.New(opts.Message), opts.StatusCode))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// timeoutWriter passes a handler's response through to the client until the request times
+// out. The handler has its own copy of the headers, so that they can't be changed while the
+// timeout response is being written.
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.writeHeaderLocked(code)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	tw.wroteHeader = true
+	copyHeader(tw.w.Header(), tw.header)
+	tw.w.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return tw.w.Write(b)
+}
+
+// Flush implements http.Flusher, so streaming responses work through the middleware.
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// timeout stops any further writes from the handler. It returns false if the
+// handler has already started writing its response.
+func (tw *timeoutWriter) timeout() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.wroteHeader {
+		return false
+	}
+	tw.timedOut = true
+	return true
+}
+
+// stop discards any further writes from the handler, whether or not it has started its response.
+func (tw *timeoutWriter) stop() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.timedOut = true
+}
+
+// panicked passes a panic from the handler to the middleware. If the middleware has
+// already stopped the handler's writes, it has returned or is about to, so the panic
+// is logged instead of being lost.
+func (tw *timeoutWriter) panicked(ctx context.Context, p interface{}, panicked chan<- interface{}) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		logger.Get(ctx).Errorw("handler panicked after the request timed out", zap.Any("panic", p), zap.ByteString("stack", debug.Stack()))
+		return
+	}
+	panicked <- p
+}
+
+// repanic propagates a panic sent by the handler before its writes were stopped.
+func repanic(panicked <-chan interface{}) {
+	select {
+	case p := <-panicked:
+		panic(p)
+	default:
+	}
+}
+
+// finish is called once the handler returns. If it didn't write anything,
+// its headers are copied so they're sent with the implicit 200 OK response.
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if !tw.wroteHeader {
+		copyHeader(tw.w.Header(), tw.header)
+	}
+}
+
+func copyHeader(dst, src http.Header) {
+	for k := range dst {
+		if _, ok := src[k]; !ok {
+			delete(dst, k)
+		}
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+}
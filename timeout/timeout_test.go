@@ -0,0 +1,198 @@
+package timeout
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/common-fate/apikit/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMiddleware(t *testing.T) {
+	type testcase struct {
+		name     string
+		options  *Options
+		handler  http.HandlerFunc
+		wantCode int
+		wantBody string
+	}
+
+	testcases := []testcase{
+		{
+			name: "completes in time",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte("ok"))
+			},
+			wantCode: http.StatusCreated,
+			wantBody: "ok",
+		},
+		{
+			name: "times out",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: `{"error":"request timed out"}`,
+		},
+		{
+			name:    "custom status and message",
+			options: &Options{StatusCode: http.StatusGatewayTimeout, Message: "upstream took too long"},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+			wantCode: http.StatusGatewayTimeout,
+			wantBody: `{"error":"upstream took too long"}`,
+		},
+		{
+			name: "response started before the deadline",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("partial"))
+				<-r.Context().Done()
+				_, _ = w.Write([]byte(" response"))
+			},
+			wantCode: http.StatusOK,
+			wantBody: "partial response",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := Middleware(10*time.Millisecond, tc.options)(tc.handler)
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			assert.Equal(t, tc.wantCode, rr.Code)
+			assert.Equal(t, tc.wantBody, rr.Body.String())
+		})
+	}
+}
+
+func TestLateWritesAreDiscarded(t *testing.T) {
+	written := make(chan error, 1)
+	h := Middleware(10*time.Millisecond, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		// give the middleware time to write the timeout response.
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("X-Late", "true")
+		_, err := w.Write([]byte("late"))
+		written <- err
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.ErrHandlerTimeout, <-written)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, `{"error":"request timed out"}`, rr.Body.String())
+	assert.Empty(t, rr.Header().Get("X-Late"))
+}
+
+func TestHeadersWithoutBody(t *testing.T) {
+	h := Middleware(time.Second, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "true")
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "true", rr.Header().Get("X-Test"))
+}
+
+func TestSetsDeadline(t *testing.T) {
+	var deadline time.Time
+	h := Middleware(time.Minute, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+}
+
+func TestClientDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := Middleware(time.Minute, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	// nothing is written, as there's no client to receive it.
+	assert.Empty(t, rr.Body.String())
+}
+
+func TestClientDisconnectMidStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	returned := make(chan struct{})
+	written := make(chan error, 1)
+
+	h := Middleware(time.Minute, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("partial"))
+		close(started)
+		<-r.Context().Done()
+		<-returned
+		_, err := w.Write([]byte(" late"))
+		written <- err
+	}))
+
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	body := rr.Body.String()
+	close(returned)
+
+	assert.Equal(t, "partial", body)
+	assert.Equal(t, http.ErrHandlerTimeout, <-written)
+}
+
+func TestPanicAfterTimeoutIsLogged(t *testing.T) {
+	core, logs := observer.New(zapcore.ErrorLevel)
+	ctx := logger.Set(context.Background(), zap.New(core).Sugar())
+	returned := make(chan struct{})
+
+	h := Middleware(10*time.Millisecond, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		<-returned
+		panic("boom")
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	close(returned)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	deadline := time.Now().Add(time.Second)
+	for logs.FilterMessage("handler panicked after the request timed out").Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the panic to be logged")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	entry := logs.FilterMessage("handler panicked after the request timed out").All()[0]
+	assert.Equal(t, "boom", entry.ContextMap()["panic"])
+}
+
+func TestPanicPropagates(t *testing.T) {
+	h := Middleware(time.Second, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	assert.PanicsWithValue(t, "boom", func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
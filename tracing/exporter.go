@@ -0,0 +1,41 @@
+package tracing
+
+import "sync"
+
+// Exporter sends finished spans to a tracing backend.
+// ExportSpan is called when a sampled span ends, so it shouldn't block.
+type Exporter interface {
+	ExportSpan(s SpanData)
+}
+
+// InMemoryExporter holds spans in memory. It's intended for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewInMemoryExporter returns an empty InMemoryExporter.
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// ExportSpan implements Exporter.
+func (e *InMemoryExporter) ExportSpan(s SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+}
+
+// Spans returns the exported spans, in the order they ended.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset removes all exported spans.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
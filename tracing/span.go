@@ -0,0 +1,261 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/common-fate/apikit/requestid"
+)
+
+var spanKey = &contextKey{"span"}
+
+type contextKey struct {
+	name string
+}
+
+// SpanKind describes the relationship between a span and the work it represents.
+type SpanKind int
+
+const (
+	// SpanKindInternal spans represent work within the service.
+	SpanKindInternal SpanKind = iota
+	// SpanKindServer spans represent the handling of an incoming request.
+	SpanKindServer
+)
+
+// StatusCode is the status of a span.
+type StatusCode int
+
+const (
+	// StatusUnset is the default status of a span.
+	StatusUnset StatusCode = iota
+	// StatusOK marks the operation as having succeeded, overriding any other status.
+	StatusOK
+	// StatusError marks the operation as having failed.
+	StatusError
+)
+
+// Event is something which happened during a span, such as an error.
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]interface{}
+}
+
+// SpanData is a finished span, as passed to an Exporter.
+type SpanData struct {
+	Name string
+	Kind SpanKind
+	// TraceID is the ID of the trace the span belongs to.
+	TraceID requestid.TraceID
+	SpanID  requestid.SpanID
+	// ParentSpanID is the ID of the span's parent. It's zero for root spans.
+	ParentSpanID  requestid.SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]interface{}
+	Events        []Event
+	Status        StatusCode
+	StatusMessage string
+}
+
+// Span records an operation within a trace. It's safe for concurrent use, and all
+// methods can be called on a nil Span, which is returned by Start when there's no
+// span in the context.
+type Span struct {
+	exporter Exporter
+	sampled  bool
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func newSpan(exporter Exporter, sampled bool, name string, kind SpanKind, traceID requestid.TraceID, parent requestid.SpanID) *Span {
+	return &Span{
+		exporter: exporter,
+		sampled:  sampled,
+		data: SpanData{
+			Name:         name,
+			Kind:         kind,
+			TraceID:      traceID,
+			SpanID:       newSpanID(),
+			ParentSpanID: parent,
+			Start:        time.Now(),
+			Attributes:   map[string]interface{}{},
+		},
+	}
+}
+
+// Start starts a child of the span in the context, returning a context containing
+// the new span. Call End on the span once the operation is complete.
+// If there's no span in the context, the returned span is nil.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := newSpan(parent.exporter, parent.sampled, name, SpanKindInternal, parent.data.TraceID, parent.data.SpanID)
+	return contextWithSpan(ctx, s), s
+}
+
+// SpanFromContext returns the current span, or nil if there isn't one.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(spanKey).(*Span)
+	return s
+}
+
+// contextWithSpan makes s the current span, and updates the trace context used by
+// requestid.Propagate so that outgoing requests are children of s.
+func contextWithSpan(ctx context.Context, s *Span) context.Context {
+	tc, _ := requestid.GetTrace(ctx)
+	tc.TraceID = s.data.TraceID
+	tc.SpanID = s.data.SpanID
+	if s.sampled {
+		tc.Flags |= requestid.FlagSampled
+	} else {
+		tc.Flags &^= requestid.FlagSampled
+	}
+	ctx = requestid.SetTrace(ctx, tc)
+	return context.WithValue(ctx, spanKey, s)
+}
+
+// TraceID returns the ID of the trace the span belongs to.
+func (s *Span) TraceID() requestid.TraceID {
+	if s == nil {
+		return requestid.TraceID{}
+	}
+	return s.data.TraceID
+}
+
+// SpanID returns the ID of the span.
+func (s *Span) SpanID() requestid.SpanID {
+	if s == nil {
+		return requestid.SpanID{}
+	}
+	return s.data.SpanID
+}
+
+// SetName changes the name of the span. It does nothing once the span has ended.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.Name = name
+}
+
+// SetAttribute sets an attribute on the span. It does nothing once the span has ended.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.Attributes[key] = value
+}
+
+// RecordError adds an "exception" event for err to the span. It doesn't change the status
+// of the span, as not all errors mean the operation failed. It does nothing once the span has ended.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.Events = append(s.data.Events, Event{
+		Name:       "exception",
+		Time:       time.Now(),
+		Attributes: map[string]interface{}{"exception.message": err.Error()},
+	})
+}
+
+// SetStatus sets the status of the span. Once the status is StatusOK it can't be changed,
+// and the message is only kept for StatusError. It does nothing once the span has ended.
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended || s.data.Status == StatusOK || code == StatusUnset {
+		return
+	}
+	s.data.Status = code
+	if code == StatusError {
+		s.data.StatusMessage = msg
+	} else {
+		s.data.StatusMessage = ""
+	}
+}
+
+// status returns the current status of the span.
+func (s *Span) status() StatusCode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Status
+}
+
+// End finishes the span and exports it if it's sampled. Calls after the first are ignored,
+// as are any changes made to the span afterwards.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	// the exporter gets its own copy, so it isn't affected by late calls on the span.
+	data := s.data
+	data.Attributes = make(map[string]interface{}, len(s.data.Attributes))
+	for k, v := range s.data.Attributes {
+		data.Attributes[k] = v
+	}
+	data.Events = append([]Event(nil), s.data.Events...)
+	s.mu.Unlock()
+
+	if s.sampled && s.exporter != nil {
+		s.exporter.ExportSpan(data)
+	}
+}
+
+func newTraceID() requestid.TraceID {
+	var id requestid.TraceID
+	for !id.IsValid() {
+		randomBytes(id[:])
+	}
+	return id
+}
+
+func newSpanID() requestid.SpanID {
+	var id requestid.SpanID
+	for !id.IsValid() {
+		randomBytes(id[:])
+	}
+	return id
+}
+
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic("tracing: reading random bytes: " + err.Error())
+	}
+}
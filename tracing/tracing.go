@@ -0,0 +1,128 @@
+// Package tracing provides middleware which records a server span for each request,
+// modelled on OpenTelemetry's tracing API.
+//
+// Spans are named after the chi route pattern, such as "GET /users/{id}", so that
+// requests for the same route are grouped together. Errors passed to apio.Error are
+// recorded on the current span, and the request logger from logger.Get includes the
+// trace_id and span_id of the span.
+//
+// The middleware should be added after logger.Middleware, and after requestid.Middleware
+// if it's used:
+//
+//	r.Use(requestid.Middleware(nil))
+//	r.Use(logger.Middleware(log))
+//	r.Use(tracing.Middleware(&tracing.Options{Exporter: exporter}))
+//
+// If the request has a traceparent header, the span continues the caller's trace, and
+// requestid.Propagate sends the span's trace context to downstream services.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/common-fate/apikit/errhandler"
+	"github.com/common-fate/apikit/logger"
+	"github.com/common-fate/apikit/requestid"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func init() {
+	errhandler.Observe(recordError)
+}
+
+// recordError records an error sent by apio.Error on the request's span, if there is one.
+func recordError(ctx context.Context, e *errhandler.Event) {
+	span := SpanFromContext(ctx)
+	span.RecordError(e.Err)
+	if e.Status >= http.StatusInternalServerError {
+		span.SetStatus(StatusError, e.Err.Error())
+	}
+}
+
+// Options customise the tracing middleware.
+type Options struct {
+	// Exporter receives spans once they end. If nil, spans are created so that
+	// trace IDs are logged and propagated, but they aren't exported.
+	Exporter Exporter
+}
+
+// Middleware returns middleware which records a server span for each request.
+// Options may be nil to use the defaults.
+//
+// Requests which continue a trace are sampled if the caller sampled them.
+// Requests which start a new trace are always sampled.
+func Middleware(options *Options) func(next http.Handler) http.Handler {
+	var opts Options
+	if options != nil {
+		opts = *options
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			parent, ok := requestid.GetTrace(ctx)
+			if !ok {
+				parent, ok = requestid.Extract(r.Header)
+				if ok {
+					ctx = requestid.SetTrace(ctx, parent)
+				}
+			}
+
+			var span *Span
+			if ok {
+				span = newSpan(opts.Exporter, parent.Sampled(), r.Method, SpanKindServer, parent.TraceID, parent.SpanID)
+			} else {
+				span = newSpan(opts.Exporter, true, r.Method, SpanKindServer, newTraceID(), requestid.SpanID{})
+			}
+			span.SetAttribute("http.method", r.Method)
+			span.SetAttribute("http.target", r.URL.Path)
+
+			ctx = contextWithSpan(ctx, span)
+			ctx = logger.Set(ctx, logger.Get(ctx).With("trace_id", span.TraceID().String(), "span_id", span.SpanID().String()))
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			defer func() {
+				if p := recover(); p != nil {
+					span.SetStatus(StatusError, fmt.Sprintf("panic: %v", p))
+					end(span, r, http.StatusInternalServerError)
+					panic(p)
+				}
+
+				status := ww.Status()
+				if status == 0 {
+					// the handler didn't write anything, so the server sends a 200 response.
+					status = http.StatusOK
+				}
+				end(span, r, status)
+			}()
+
+			next.ServeHTTP(ww, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// end records the route and response status on a server span and ends it.
+func end(span *Span, r *http.Request, status int) {
+	// chi fills in the route pattern as the request is routed,
+	// so it's only complete once the handler has returned.
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if route := rctx.RoutePattern(); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttribute("http.route", route)
+		}
+	}
+	span.SetAttribute("http.status_code", status)
+
+	// 4xx responses are the client's error rather than the server's,
+	// so only 5xx responses mark server spans as failed.
+	if status >= 500 && span.status() == StatusUnset {
+		span.SetStatus(StatusError, http.StatusText(status))
+	}
+	span.End()
+}
@@ -0,0 +1,222 @@
+package tracing_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/common-fate/apikit/apio"
+	"github.com/common-fate/apikit/logger"
+	"github.com/common-fate/apikit/requestid"
+	"github.com/common-fate/apikit/tracing"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMiddleware(t *testing.T) {
+	type testcase struct {
+		name        string
+		path        string
+		traceparent string
+		wantName    string
+		wantStatus  tracing.StatusCode
+		wantCode    int
+		wantEvents  int
+		wantParent  string
+		wantTraceID string
+		wantSampled bool
+	}
+
+	testcases := []testcase{
+		{name: "new trace", path: "/users/usr_1", wantName: "GET /users/{id}", wantCode: http.StatusOK, wantSampled: true},
+		{
+			name:        "continues trace",
+			path:        "/users/usr_1",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantName:    "GET /users/{id}",
+			wantCode:    http.StatusOK,
+			wantParent:  "00f067aa0ba902b7",
+			wantTraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantSampled: true,
+		},
+		{
+			name:        "caller didn't sample",
+			path:        "/users/usr_1",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		},
+		{name: "client error", path: "/bad", wantName: "GET /bad", wantCode: http.StatusBadRequest, wantEvents: 1, wantSampled: true},
+		{name: "server error", path: "/broken", wantName: "GET /broken", wantStatus: tracing.StatusError, wantCode: http.StatusInternalServerError, wantEvents: 1, wantSampled: true},
+		{name: "not found", path: "/missing", wantName: "GET", wantCode: http.StatusNotFound, wantSampled: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter := tracing.NewInMemoryExporter()
+
+			r := chi.NewRouter()
+			r.Use(tracing.Middleware(&tracing.Options{Exporter: exporter}))
+			r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+			r.Get("/bad", func(w http.ResponseWriter, r *http.Request) {
+				apio.ErrorString(r.Context(), w, "bad request", http.StatusBadRequest)
+			})
+			r.Get("/broken", func(w http.ResponseWriter, r *http.Request) {
+				apio.Error(r.Context(), w, errors.New("database unavailable"))
+			})
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.traceparent != "" {
+				req.Header.Set(requestid.TraceparentHeader, tc.traceparent)
+			}
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := exporter.Spans()
+			if !tc.wantSampled {
+				assert.Empty(t, spans)
+				return
+			}
+			if !assert.Len(t, spans, 1) {
+				return
+			}
+			s := spans[0]
+			assert.Equal(t, tc.wantName, s.Name)
+			assert.Equal(t, tracing.SpanKindServer, s.Kind)
+			assert.Equal(t, tc.wantStatus, s.Status)
+			assert.Equal(t, tc.wantCode, s.Attributes["http.status_code"])
+			assert.Len(t, s.Events, tc.wantEvents)
+			assert.True(t, s.TraceID.IsValid())
+			assert.False(t, s.End.Before(s.Start))
+			if tc.wantParent != "" {
+				assert.Equal(t, tc.wantParent, s.ParentSpanID.String())
+				assert.Equal(t, tc.wantTraceID, s.TraceID.String())
+			} else {
+				assert.False(t, s.ParentSpanID.IsValid())
+			}
+		})
+	}
+}
+
+func TestRecordsErrorMessage(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	h := tracing.Middleware(&tracing.Options{Exporter: exporter})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apio.Error(r.Context(), w, errors.New("database unavailable"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	s := exporter.Spans()[0]
+	assert.Equal(t, "database unavailable", s.StatusMessage)
+	assert.Equal(t, "exception", s.Events[0].Name)
+	assert.Equal(t, "database unavailable", s.Events[0].Attributes["exception.message"])
+}
+
+func TestPanic(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	h := tracing.Middleware(&tracing.Options{Exporter: exporter})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	assert.Panics(t, func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	s := exporter.Spans()[0]
+	assert.Equal(t, tracing.StatusError, s.Status)
+	assert.Equal(t, "panic: boom", s.StatusMessage)
+	assert.Equal(t, http.StatusInternalServerError, s.Attributes["http.status_code"])
+}
+
+func TestPropagation(t *testing.T) {
+	out := http.Header{}
+	var span *tracing.Span
+	h := requestid.Middleware(nil)(tracing.Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span = tracing.SpanFromContext(r.Context())
+		requestid.Propagate(r.Context(), out)
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(requestid.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(requestid.TracestateHeader, "congo=t61rcWkgMzE")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	// downstream services see this request's span as their parent.
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanID().String()+"-01", out.Get(requestid.TraceparentHeader))
+	assert.Equal(t, "congo=t61rcWkgMzE", out.Get(requestid.TracestateHeader))
+}
+
+func TestLoggerFields(t *testing.T) {
+	observed, logs := observer.New(zapcore.InfoLevel)
+
+	var span *tracing.Span
+	h := logger.Middleware(zap.New(observed))(tracing.Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span = tracing.SpanFromContext(r.Context())
+		logger.Get(r.Context()).Info("handling request")
+	})))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	entry := logs.FilterMessage("handling request").All()[0]
+	assert.Contains(t, entry.Context, zap.String("trace_id", span.TraceID().String()))
+	assert.Contains(t, entry.Context, zap.String("span_id", span.SpanID().String()))
+}
+
+func TestStart(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	h := tracing.Middleware(&tracing.Options{Exporter: exporter})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, child := tracing.Start(r.Context(), "load user")
+		child.SetAttribute("user.id", "usr_1")
+		child.SetStatus(tracing.StatusOK, "")
+		child.End()
+		child.End()
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	spans := exporter.Spans()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	child, server := spans[0], spans[1]
+	assert.Equal(t, "load user", child.Name)
+	assert.Equal(t, tracing.SpanKindInternal, child.Kind)
+	assert.Equal(t, server.TraceID, child.TraceID)
+	assert.Equal(t, server.SpanID, child.ParentSpanID)
+	assert.Equal(t, tracing.StatusOK, child.Status)
+	assert.Equal(t, "usr_1", child.Attributes["user.id"])
+
+	exporter.Reset()
+	assert.Empty(t, exporter.Spans())
+}
+
+func TestChangesAfterEndAreIgnored(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	h := tracing.Middleware(&tracing.Options{Exporter: exporter})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, child := tracing.Start(r.Context(), "load user")
+		child.SetAttribute("user.id", "usr_1")
+		child.End()
+
+		// deferred code may still use the span once it has been exported.
+		child.SetName("renamed")
+		child.SetAttribute("user.id", "usr_2")
+		child.RecordError(errors.New("late"))
+		child.SetStatus(tracing.StatusError, "late")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	child := exporter.Spans()[0]
+	assert.Equal(t, "load user", child.Name)
+	assert.Equal(t, "usr_1", child.Attributes["user.id"])
+	assert.Empty(t, child.Events)
+	assert.Equal(t, tracing.StatusUnset, child.Status)
+}
+
+func TestNilSpan(t *testing.T) {
+	ctx, span := tracing.Start(httptest.NewRequest(http.MethodGet, "/", nil).Context(), "no parent")
+	assert.Nil(t, span)
+	assert.Nil(t, tracing.SpanFromContext(ctx))
+
+	// methods on a nil span do nothing.
+	span.SetAttribute("key", "value")
+	span.RecordError(errors.New("error"))
+	span.SetStatus(tracing.StatusError, "error")
+	span.End()
+}